@@ -68,7 +68,7 @@ func (fi bindataFileInfo) Sys() interface{} {
 	return nil
 }
 
-var _templatesIndexTmpl = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\x8c\x94\x4f\x8f\xdb\x2c\x10\xc6\xef\xf9\x14\xf3\x72\x77\xc8\xbe\x5b\x55\x6a\x85\x7d\xe9\xf6\xda\xad\xb4\xed\xa1\xc7\x09\x60\x83\x82\x01\x31\xe3\xa4\xf9\xf6\x95\xff\xa4\xc9\xa6\x89\x76\x4f\x20\xe6\x99\x67\xfc\xfc\x8c\xad\xfe\x7b\x7a\xfe\xf2\xe3\xd7\xf7\xaf\xe0\xb8\x0f\xcd\x4a\xcd\xcb\x4a\x39\x8b\xa6\x59\x01\x00\xa8\xe0\xe3\x0e\x8a\x0d\xb5\x20\x3e\x06\x4b\xce\x5a\x16\xe0\x8a\x6d\x6b\xe1\x98\x33\x7d\x96\x52\x9b\xb8\x4e\x91\xd6\x5d\xda\xaf\x87\x9d\x24\xff\x9b\xad\x8d\x24\x37\x1b\xfd\xe1\xff\x8f\x1b\xa9\x89\x64\x8f\x3e\xae\x35\x91\x68\x56\x4a\xce\xfe\x6a\x9b\xcc\x71\x1c\x67\xfc\x1e\x74\x40\xa2\x5a\x1c\x0a\xe6\x6c\x8b\x58\xa6\x5f\x54\xc6\x1e\x5b\xc0\x59\xdf\x39\xae\xaa\x4f\xa0\x53\xa8\x46\xf9\xa2\xbd\xd6\xeb\x14\x26\x49\x15\xba\x2a\x45\x5b\xb1\xf3\xc5\xcc\x27\xbd\x39\x9f\x5c\x74\x4f\x0e\xb8\x64\x93\x57\x85\xa9\xe8\xfb\xee\x64\x3f\xe6\xa9\x42\xea\x92\x00\x2a\xfa\xcc\xe2\x70\x38\x5c\xb2\x40\x22\xcb\x24\x7d\xdf\xc9\x14\x69\x6a\x58\xd3\xbe\x13\x80\x81\x6b\xf1\xdc\xb6\x5e\x5b\x68\x53\x81\x6f\xc8\x3e\x45\x0c\xf0\xc2\xc8\x9e\xd8\x6b\xba\x7e\x34\x89\x17\x49\xa5\xf1\xfb\x05\xd2\xbc\x3d\x2d\x17\x08\xb6\xa8\x77\x5d\x49\x43\x34\x55\x85\xc4\x05\xc3\x0d\xae\xaf\x89\xdf\x21\x79\x0d\xfa\x8e\xec\x86\x62\x52\xb9\x87\xe6\x09\x19\xa1\xf5\xc1\xc2\x90\x43\x42\xa3\xa4\x7b\xb8\xe1\x77\x8e\x75\xe7\xe8\x75\xda\x77\xde\x9d\xb7\xaf\xca\x75\xfc\x36\x95\x1e\x50\x8f\xef\xa4\x16\x02\x7a\xcb\x2e\x99\x5a\xe4\x44\x2c\xc0\x46\xcd\xc7\x6c\x6b\xd1\x0f\x81\x7d\xc6\xc2\x72\xd4\x57\x06\x19\x6f\x41\x72\x8f\xcd\x8b\x0d\x56\xf3\x0c\x80\xd3\x99\xc1\xe3\x0d\x79\x6e\x94\x8f\x79\x60\x98\x87\x8c\x3d\x02\x22\xf6\x7f\xf7\xde\x2c\xbb\x46\xc9\xfc\xb6\x01\x0d\xdb\xde\xb3\x80\x3d\x86\xc1\xd6\xe2\xe7\x34\xfb\xe4\xb8\x14\xff\x75\x52\x53\xa6\x77\xdc\xb8\x95\x92\xf3\x87\xac\xe4\xf4\xff\xf8\x13\x00\x00\xff\xff\xae\xc8\x64\xb3\x56\x04\x00\x00")
+var _templatesIndexTmpl = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x02\xff\x8c\x58\x6d\x8f\xdb\x36\x12\xfe\xee\x5f\x31\xd1\x01\x95\x8c\x5d\x4b\xde\x24\x57\xe0\xb2\x92\x8a\x34\x2f\x6d\xee\xf2\x72\xc8\xa6\x87\x3b\x04\x45\x41\x4b\x63\x8b\x09\x45\x2a\xe4\xc8\xde\xad\xeb\xff\x7e\x20\x25\xd9\xb2\x2c\x6f\xd6\x1f\x2c\x8a\x9c\x99\x67\xe6\xe1\xcc\x90\x50\xfc\xe8\xe5\x87\x17\x9f\xfe\xf7\xef\x57\x50\x50\x29\xd2\x49\xdc\x3c\x26\x71\x81\x2c\x4f\x27\x00\x00\xb1\xe0\xf2\x2b\x68\x14\x89\x67\xe8\x4e\xa0\x29\x10\xc9\x83\x42\xe3\x32\xf1\x0a\xa2\xca\x3c\x8b\xa2\x2c\x97\xa1\x92\x26\x5c\xa9\x75\x58\x7f\x8d\x0c\xbf\x25\x44\x69\xa2\xf9\x3c\x7b\xfa\xf8\xc7\x79\x94\x19\x13\x95\x8c\xcb\x30\x33\xc6\x4b\x27\x71\xd4\xd8\x8f\x17\x2a\xbf\xb3\x70\x39\x5f\x43\x26\x98\x31\x89\xb7\xd1\xac\xaa\x50\x7b\x2d\x7a\x6f\xc5\xea\xa0\x86\x02\xf9\xaa\xa0\xd9\xec\x1f\x90\x29\x31\xb3\xe2\xad\xec\x50\x3e\x53\xc2\x89\xcc\xc4\x6a\xa6\x24\xce\xa8\xe0\x3a\x6f\x66\xca\xfc\x30\xd3\xd3\x76\x16\x58\x1b\x5b\x34\x58\x70\x8b\xbc\x5c\x75\xe6\x6d\x3c\x33\xa1\x56\xca\x03\xa3\xb3\x03\x17\x9b\xcd\xa6\xcf\x05\x33\x06\xc9\x44\xbc\x5c\x45\x4a\x1a\xa7\x10\x9a\xf5\xca\x03\x26\x28\xf1\x3e\x2c\x97\x3c\x43\x58\x2a\x0d\xef\x19\x71\x25\x99\x80\x1b\x62\xc4\x0d\xf1\xcc\x0c\x5d\x8b\x58\x2f\xd2\x28\xe7\xeb\x74\xd2\x1b\x76\x8f\x1e\x05\x0b\x96\x7d\x5d\x69\x55\xcb\x7c\x36\x63\x86\x34\x13\x23\xbc\x1e\x33\x7e\x86\xc9\x21\xd1\x67\xc4\x46\x24\x9c\x54\x71\x95\xbe\x64\xc4\x60\xc9\x05\x42\x5d\x09\xc5\xf2\x38\x2a\xae\x46\xec\x1d\xc2\x3a\x33\x75\x1c\xed\x03\x73\xe7\xfb\xa9\x32\xf0\x7c\xbb\xe5\x4b\x08\xdf\xa1\x31\x6c\x85\xbb\xdd\xb1\x43\x15\xf0\x3c\xf1\xca\x66\xd1\x4b\x63\x43\x5a\xc9\x55\xba\xdd\x1e\x14\xe2\xa8\x9d\x8c\xa3\x6a\x68\x19\x65\xbe\xdb\x8d\xa0\x7d\x44\x53\x0b\x32\x43\xb4\x5a\x38\x38\xdd\xac\x8e\x30\xbc\xdd\x6a\x26\x57\x78\xce\xc0\x01\xe0\x95\xd6\x4a\x8f\xac\xc6\x82\xf7\x83\x78\xcd\x05\x4a\x56\xf6\xa3\x80\x0d\x33\x20\x15\xb5\x7b\x87\xf9\x33\xd8\x6e\x3b\x7b\x71\x24\xf8\x98\x57\x28\x0c\x8e\xa3\x41\xce\x88\xcd\x1a\x5b\x89\xb7\xdd\x86\x6f\x5e\xee\x76\xde\x43\x7c\xe8\xf0\x81\xc9\x1c\xb8\x81\x05\x72\xb9\x82\x4a\xab\x0c\x8d\xc1\x3c\x1c\x4f\xbf\x85\x4e\xe3\x4a\xab\x95\x46\x63\xa0\x64\xb7\x89\x77\xe5\xd9\x9d\x69\xa7\x52\x88\x4d\xc5\x64\x97\x0d\x0d\xc6\xac\x5b\xf5\xd2\x6f\x35\xd6\x98\xc7\x91\x15\x4a\xcf\x46\x7b\xb2\xab\xe7\xe6\xe3\xa8\x16\xdf\xcf\x89\x78\xa9\x74\x09\x2c\xb3\x5d\x21\xf1\x3c\x28\x91\x0a\x95\x27\x5e\xa5\x0c\x79\x80\x32\xa3\xbb\x0a\x13\xaf\xac\x05\xf1\x8a\x69\x8a\xac\xfc\xcc\x12\x3b\xda\xb8\x64\x55\x13\x34\x2a\x05\xcf\x73\x94\x1e\x58\x7e\x13\x2f\x33\x7a\xf9\x07\xa9\xaf\x76\x66\xcd\x44\x8d\x6e\x43\x5e\xdc\x7c\x7c\xfd\xc9\x4e\xee\x76\x63\xe6\x8a\x27\xae\x9e\x0d\x12\xe4\x48\x8c\x0b\x03\x81\xaa\x9a\x06\x36\x8d\xa3\xe2\xc9\x88\x4e\x95\xc6\x82\x2d\x50\xd8\x6e\x97\x78\x79\xa3\xee\xed\xed\xbc\x79\x19\x47\x6e\x3d\x75\xdb\xd5\x77\x98\xf0\x96\x3a\x77\x3b\x3d\x57\x13\x7b\x23\x27\x55\x36\x82\x88\x39\xb7\x0e\x7a\xe9\xab\x66\xf0\x20\xb8\x4e\xc9\xc1\xed\x2d\x3c\x04\x6e\x8d\xda\x38\xe1\xff\x34\x83\x07\xc1\x75\x4a\x0e\x6e\x6f\xe1\x21\x70\x1a\x05\x32\x83\x7f\xe4\x8c\xd0\x4b\x3f\x36\x6f\xb6\xce\xf0\x2c\xb0\x13\x6d\x81\x8f\xd4\xdb\x7e\xd3\x37\xf8\x10\x17\x32\x25\x89\x65\xe4\xa5\x2f\x9a\x01\x60\xc9\xb8\x38\x0b\xef\x56\xf7\x59\xd8\xea\x3a\xe8\xbd\xa1\x87\xa0\x4a\x45\x68\xbc\xf4\xbd\x7d\x1c\x61\x59\x5e\x99\x46\xd6\x22\x34\x72\xce\x7e\x3b\xd4\x6a\x63\x12\xef\x89\x67\xef\x04\x26\xf1\x7e\x9c\x7b\xb6\x37\x08\x94\x2b\x2a\x12\xef\x6a\x3e\x9f\x5b\x0f\x3a\x33\x67\x9c\x29\x9e\xa4\x37\x28\x30\x23\x77\xb6\x19\x20\x75\x38\xdf\xce\x54\x41\x9f\x04\xab\xd4\x71\xd0\x8c\x79\xde\x8d\x9a\xd2\x16\x78\x9e\x86\xbe\x25\x53\x2f\x4a\x4e\xfb\x1a\xfe\xcd\x39\xd1\x99\x6e\x17\x4f\x2d\xc5\xae\x6d\x3c\xe0\x5a\x31\x89\x4d\xa6\x79\x45\xe9\x24\x8a\xe0\xb5\x12\x42\x6d\x0c\x20\xcb\x8a\x36\x5c\xa8\x25\x71\x01\x9c\xa0\x60\xb6\x2d\xa3\x3c\x74\xe5\x4b\xd8\x70\x2a\xc0\xa0\x5e\xa3\x9e\x19\x94\x04\xb8\x46\x49\x06\x36\x05\x6a\x04\x2a\x10\x16\x76\x37\x50\x83\xa9\xab\x4a\x69\x32\x16\x86\x0a\x2c\x5d\xa3\x57\x54\xa0\xde\x70\x83\xb0\xb8\x83\x4a\x09\x61\x9b\x3e\x27\x03\x86\x18\xd5\x26\x9c\x04\xcb\x5a\xba\x4e\x09\xc1\x14\xb6\x13\x00\x80\x35\xd3\xb0\xe4\x92\x9b\x02\x73\x48\x60\x9b\xa9\xb2\x12\x48\xf6\xe8\x22\x5d\xe3\x25\x2c\x19\x17\x87\x37\x8d\x5f\x30\xeb\xad\x66\x4c\x66\x28\xf6\x02\xbb\xeb\x89\xb3\xba\xc7\xb1\xb4\x31\xba\xe1\x7f\x62\xb0\xb8\x23\x34\x1d\x6c\x07\x5d\x4b\xeb\x5f\x02\x9f\xfd\x9f\xfd\x4b\xf0\xff\xe5\xfe\xdf\xb9\xff\x5f\x7e\xf6\x7f\xbf\x3e\x12\xe6\x90\xc0\xfc\x30\xb5\x29\xb8\x40\x68\xec\x42\x9a\xc0\xd5\xfc\xf1\x53\xf8\xe1\x07\xe0\x10\x37\x76\xc3\x26\x49\x61\x06\x57\x7d\x5c\xfb\x6b\x94\xa2\x46\xe9\xfa\x68\x89\x5f\x5c\x1c\x26\x0e\x87\x8d\x46\xaa\xb5\x84\x80\x43\x92\x24\x30\x87\x9f\x5a\x1b\xcf\x9a\x67\x48\xea\x35\xbf\xc5\x3c\xb8\x9a\x4e\xe1\xa2\xc1\xff\xcc\xdb\x00\x76\x03\x56\x72\xb4\x59\xb2\xc0\xa0\x49\x8a\x21\x29\xf6\x9c\x72\xa4\x34\xcb\xa1\x21\xb6\x42\xf8\x09\x0e\xaf\x54\x1b\xb8\x00\xff\x19\xf8\x70\xd1\x9b\x5e\x21\x3c\x3b\x96\xea\x31\xc8\x97\xd0\xe2\x85\x36\x89\x86\x8c\x38\xd0\xb0\xaa\x4d\xd1\x97\x0a\x49\xbd\x55\x19\x13\x78\x43\x9a\xcb\x55\x60\x63\xf3\x5d\x4b\xf0\xa7\x63\x2c\xf5\x40\x1c\x2d\x37\xa4\x34\xe6\xf7\x60\xf5\x12\x64\x4c\xcf\xa2\x19\x37\x1e\xc7\xb3\x74\xd9\xd6\x03\x49\x6b\xf4\x8b\xe2\x32\xb0\xe9\x33\x1d\x8d\x1c\xed\x5d\x6c\xe8\x8e\x33\x70\x91\x80\x0f\xb3\x3e\xa1\x4e\x36\x6c\xef\xaf\xf7\xa4\x84\x55\x1f\xdf\xe7\xa5\x6b\x00\x01\x27\x2c\x4f\x12\x5f\x0b\x48\xc0\x8f\x58\xc5\xa3\xf5\x55\xd4\x40\x9a\xc8\xc2\xa3\xcc\x54\x8e\xbf\x7d\x7c\xf3\x42\x95\x95\x92\x28\xc9\x59\x08\x57\x48\xcf\x89\x34\x5f\xd4\x84\x81\xdf\xbb\x1b\xfa\xd3\xe9\x71\xa1\x2c\x98\x86\x04\x9c\xd2\xb7\x1a\xf5\x5d\xd3\x7a\x95\x0e\xfc\xee\xb6\xe6\x0f\x34\x5a\x0e\xc7\x54\xc2\xc1\x45\xcf\xaa\xee\x75\xf7\xa1\x9a\x42\x6d\x46\xd2\xb9\xa3\x37\xb4\x7f\xf6\xc0\x43\x69\x71\x86\x05\x30\xa8\xc0\xc3\x7e\xb5\xb9\x6e\x4b\xce\xdf\xb7\x26\x7f\x88\x60\x7f\x0b\xa6\xc3\x92\xdd\x42\x02\x57\xd7\xa3\x8b\xae\xe3\x9f\x2e\xef\xc0\x5e\xc0\x1d\x66\xd7\x07\x3f\x1f\x97\xd0\x39\xb4\x8a\x69\x94\xf4\x5e\xe5\x18\x6a\x2c\xd5\x1a\x5f\x14\x5c\xe4\xc1\x82\xe9\xe9\x59\x88\xd3\x1c\xb7\x1d\xab\xc3\xe3\x7f\xe2\xfd\xa1\xf5\x04\xef\x0f\xf2\x1d\xa3\x22\x2c\xb9\x1c\x01\xbc\x3c\x82\x1b\x78\x7a\xf4\xd6\xe6\xf7\x19\x5a\xfa\x15\x71\x54\x6a\x1b\x2e\x73\xb5\x09\x5f\xd9\xb3\xeb\x46\xd5\x3a\x3b\x09\xca\x66\x9c\x71\x2b\x90\x80\xc4\x0d\xf4\x64\x03\x5b\x19\x17\xe0\x47\xcd\xd9\xe7\x0f\x3c\x6c\xd4\x42\x96\xe7\x4e\xe7\x2d\x37\x84\x12\x75\xe0\x37\x5e\xf9\x97\x87\x94\x0c\x46\xc9\xb4\x1e\xba\x64\xfd\xe7\xcd\x87\xf7\x76\x0f\x0d\x06\x18\xda\x6a\x9a\x4e\xc7\xe4\x7b\xa0\x99\x50\x06\x83\xe9\x29\xf3\xc7\xb4\xed\xa6\xd7\x23\x34\x8e\xd2\x75\x38\x90\xed\x71\x1d\x8c\xf1\x74\x5b\xe8\x96\xa4\xff\xbe\x7b\xfb\x2b\x51\xf5\x11\xbf\xd5\x68\x68\xe8\xc7\x6d\xa1\x43\x55\xa1\x0c\xfc\x5f\x5e\x7d\xf2\x2f\x6d\x83\x19\x91\x30\x48\xad\xfe\xaf\xee\x3b\x51\xe0\x3f\xcf\x32\xac\xc8\x76\x4c\x56\x55\x82\x67\xee\xfb\x4a\xf4\xc5\x28\xe9\x8f\x21\x48\x9b\x02\x90\xc0\xe9\x4d\x62\x48\xb2\x83\x3b\x94\xef\xd3\xf9\xd3\x73\xf4\x0e\x09\x1a\xa7\x75\xc4\xee\xa3\x24\x81\xc7\xf3\x39\xfc\xf5\x17\x3c\x1a\xee\xa9\x15\xd3\x68\x2a\x25\x0d\x7e\xc2\x5b\xba\x67\x77\x91\x3e\xf1\x12\x55\x4d\x81\xdd\x85\x4b\x6b\x72\xfe\xfd\x5d\x1e\x23\xc7\x1d\x19\xdf\x65\xe7\x04\xf0\xef\xa7\x80\xbb\xb1\xbd\x93\x79\x7f\xd7\x77\xd3\xee\xad\xcd\x28\x77\x47\x22\x2c\x0d\x24\x90\xab\xac\x2e\x51\xd2\x71\x37\x7f\x2e\x44\xe0\xff\xad\xfd\x46\x02\x9f\x7b\x67\xc8\xef\xdd\x6e\xdb\x8f\x6c\xc1\xe1\xb6\x05\x1c\xe2\xc6\x68\x7b\x9b\xba\xb6\x17\xa4\x7e\x54\xbd\x43\xce\xde\x78\xf6\x2e\x35\xee\xc5\x51\x77\x21\x9e\xc4\x51\xf3\x21\x33\x8e\xdc\xf7\xd3\xff\x0f\x00\xb6\xfe\xd6\x3e\x56\x15\x00\x00")
 
 func templatesIndexTmplBytes() ([]byte, error) {
 	return bindataRead(
@@ -83,7 +83,7 @@ func templatesIndexTmpl() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "templates/index.tmpl", size: 1110, mode: os.FileMode(420), modTime: time.Unix(1482330583, 0)}
+	info := bindataFileInfo{name: "templates/index.tmpl", size: 5462, mode: os.FileMode(420), modTime: time.Unix(1792423822, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/audit"
+	"github.com/ONSdigital/dp-dd-file-uploader/config"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// auditCommand checks the audit log hasn't been tampered with, e.g.
+//
+//	dp-dd-file-uploader audit verify --file /var/log/uploads.audit
+//
+// The key is read from AUDIT_KEY, or a file, rather than a flag, so it isn't left in shell history
+// or shown in the process list.
+func auditCommand(args []string, cfg *config.Config) error {
+	if len(args) == 0 || args[0] != "verify" {
+		return errors.New("Usage: dp-dd-file-uploader audit verify [--file path] [--key-file path]")
+	}
+
+	flags := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+	file := flags.String("file", cfg.AuditLog, "the audit log to verify (default $AUDIT_LOG)")
+	keyFile := flags.String("key-file", "", "a file holding the key the audit log was written with (default $AUDIT_KEY)")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if len(*file) == 0 {
+		return errors.New("No audit log to verify, set AUDIT_LOG or --file")
+	}
+
+	key := cfg.AuditKey
+	if len(*keyFile) > 0 {
+		b, err := ioutil.ReadFile(*keyFile)
+		if err != nil {
+			return err
+		}
+		key = strings.TrimRight(string(b), "\r\n")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	verified, err := audit.Verify(f, []byte(key))
+	if err != nil {
+		log.Debug("Audit log verification failed", log.Data{"file": *file, "verified": verified})
+		return err
+	}
+
+	fmt.Printf("%s: %d records verified\n", *file, verified)
+	return nil
+}
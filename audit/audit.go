@@ -0,0 +1,308 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// The result of validating an uploaded file as it was stored.
+const (
+	ValidationPassed = "passed"
+	ValidationFailed = "failed"
+)
+
+// The result of scanning an uploaded file for viruses.
+const (
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+	ScanFailed   = "failed"
+)
+
+// genesis is the previous hash of the first record in a log.
+var genesis = hex.EncodeToString(make([]byte, sha256.Size))
+
+// maxLine is the longest line read from a log, well beyond the size of any record.
+const maxLine = 1 << 20
+
+// Record is the audit record of one upload attempt, whether it was rejected, failed or completed.
+type Record struct {
+	Sequence       int64     `json:"seq"`
+	Time           time.Time `json:"time"`
+	RequestID      string    `json:"requestId,omitempty"`
+	Subject        string    `json:"subject,omitempty"`
+	AuthMethod     string    `json:"authMethod,omitempty"`
+	SourceIP       string    `json:"sourceIp,omitempty"`
+	ForwardedFor   string    `json:"forwardedFor,omitempty"`
+	Filename       string    `json:"filename,omitempty"`
+	Dataset        string    `json:"dataset,omitempty"`
+	Size           int64     `json:"size"`
+	SHA256         string    `json:"sha256,omitempty"`
+	Key            string    `json:"key,omitempty"`
+	Topic          string    `json:"topic,omitempty"`
+	Scan           string    `json:"scan,omitempty"`
+	Validation     string    `json:"validation,omitempty"`
+	Outcome        string    `json:"outcome"`
+	Reason         string    `json:"reason,omitempty"`
+	EventPartition *int32    `json:"eventPartition,omitempty"`
+	EventOffset    *int64    `json:"eventOffset,omitempty"`
+	PrevHash       string    `json:"prevHash"`
+}
+
+// entry is a line of the log: a record, and the hash of its exact bytes. As each record includes
+// the hash of the one before it, changing, removing or reordering records breaks the chain.
+type entry struct {
+	Hash   string          `json:"hash"`
+	Record json.RawMessage `json:"record"`
+}
+
+// hash returns the SHA-256 of a record or, given a key, its HMAC-SHA256. Without a key anyone who
+// can write the log can recompute the chain, so its last hash has to be kept somewhere else to be trusted.
+func hash(key []byte, record []byte) string {
+	if len(key) == 0 {
+		sum := sha256.Sum256(record)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(record)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Log is an append-only file of hash-chained audit records.
+type Log struct {
+	path string
+	key  []byte
+
+	mutex    sync.Mutex
+	file     *os.File
+	sequence int64
+	last     string
+}
+
+// Open opens the log at path for appending, creating it if it doesn't exist, and continues the
+// chain from its last record. Records are hashed with the key, if one is given. A last record left
+// incomplete by a crash is removed, as it was never appended.
+func Open(path string, key []byte) (*Log, error) {
+	l := &Log{path: path, key: key, last: genesis}
+
+	if existing, err := os.Open(path); err == nil {
+		err = readEntries(existing, func(line int, e entry, r Record) error {
+			l.sequence, l.last = r.Sequence, e.Hash
+			return nil
+		})
+		existing.Close()
+		if incomplete, ok := err.(*incompleteError); ok {
+			err = os.Truncate(path, incomplete.offset)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read audit log %s: %v", path, err)
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l.file = file
+	return l, nil
+}
+
+// Append adds a record to the end of the log, filling in its sequence number, previous hash and,
+// if it isn't set, its time. Appending to a nil log does nothing, so auditing can be disabled.
+func (l *Log) Append(r Record) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	r.Sequence = l.sequence + 1
+	r.PrevHash = l.last
+	if r.Time.IsZero() {
+		r.Time = time.Now().UTC()
+	}
+
+	record, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	e := entry{Hash: hash(l.key, record), Record: record}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if _, err = l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err = l.file.Sync(); err != nil {
+		return err
+	}
+
+	l.sequence, l.last = r.Sequence, e.Hash
+	return nil
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.file.Close()
+}
+
+// Filter selects audit records. Empty fields match any record.
+type Filter struct {
+	Subject  string
+	Dataset  string
+	Filename string
+	Outcome  string
+	Since    time.Time
+	Until    time.Time
+	// Limit is the most records to return, the most recent first. Zero means no limit.
+	Limit int
+}
+
+func (f Filter) matches(r Record) bool {
+	return (len(f.Subject) == 0 || r.Subject == f.Subject) &&
+		(len(f.Dataset) == 0 || r.Dataset == f.Dataset) &&
+		(len(f.Filename) == 0 || r.Filename == f.Filename) &&
+		(len(f.Outcome) == 0 || r.Outcome == f.Outcome) &&
+		(f.Since.IsZero() || !r.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || r.Time.Before(f.Until))
+}
+
+// Query returns the records matching the filter, the most recent first.
+func (l *Log) Query(f Filter) ([]Record, error) {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Query(file, f)
+}
+
+// Query returns the records read from r that match the filter, the most recent first. A last record
+// that is still being written is left out.
+func Query(r io.Reader, f Filter) ([]Record, error) {
+	records := []Record{}
+	err := readEntries(r, func(line int, e entry, record Record) error {
+		if f.matches(record) {
+			records = append(records, record)
+		}
+		return nil
+	})
+	if _, ok := err.(*incompleteError); !ok && err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	if f.Limit > 0 && len(records) > f.Limit {
+		records = records[:f.Limit]
+	}
+	return records, nil
+}
+
+// VerifyError describes where the chain of records in a log is broken.
+type VerifyError struct {
+	Line   int
+	Reason string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("Audit log is invalid at line %d: %s", e.Line, e.Reason)
+}
+
+// Verify checks every record read from r is intact and chained to the one before it, using the
+// key the log was opened with, returning the number of records verified, and a VerifyError for the
+// first record that isn't.
+func Verify(r io.Reader, key []byte) (int, error) {
+	verified := 0
+	previous, sequence := genesis, int64(0)
+
+	err := readEntries(r, func(line int, e entry, record Record) error {
+		switch {
+		case hash(key, e.Record) != e.Hash:
+			return &VerifyError{Line: line, Reason: "the record does not match its hash"}
+		case record.PrevHash != previous:
+			return &VerifyError{Line: line, Reason: "the record is not chained to the one before it"}
+		case record.Sequence != sequence+1:
+			return &VerifyError{Line: line, Reason: fmt.Sprintf("expected sequence %d but found %d", sequence+1, record.Sequence)}
+		}
+		previous, sequence = e.Hash, record.Sequence
+		verified++
+		return nil
+	})
+	if incomplete, ok := err.(*incompleteError); ok {
+		err = &VerifyError{Line: incomplete.line, Reason: "the record is incomplete"}
+	}
+	return verified, err
+}
+
+// incompleteError is returned for a last line that isn't a whole record, as it is still being
+// written or the service stopped while writing it. The offset is where the line starts.
+type incompleteError struct {
+	line   int
+	offset int64
+}
+
+func (e *incompleteError) Error() string {
+	return fmt.Sprintf("Audit log line %d is incomplete", e.line)
+}
+
+// readEntries calls fn with each entry read from r, stopping at the first error. Every record is
+// written with a trailing newline, so a last line without one that can't be read is incomplete.
+func readEntries(r io.Reader, fn func(line int, e entry, record Record) error) error {
+	reader := bufio.NewReader(r)
+
+	line, offset := 0, int64(0)
+	for {
+		text, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(text) == 0 {
+			return nil
+		}
+		line++
+		start := offset
+		offset += int64(len(text))
+		if len(text) > maxLine {
+			return &VerifyError{Line: line, Reason: "the line is too long"}
+		}
+		complete := text[len(text)-1] == '\n'
+		text = bytes.TrimSpace(text)
+		if len(text) == 0 {
+			continue
+		}
+
+		var e entry
+		var record Record
+		err = json.Unmarshal(text, &e)
+		if err == nil {
+			err = json.Unmarshal(e.Record, &record)
+		}
+		if err != nil && !complete {
+			return &incompleteError{line: line, offset: start}
+		}
+		if err != nil {
+			return &VerifyError{Line: line, Reason: err.Error()}
+		}
+		if err := fn(line, e, record); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package audit_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/audit"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLog(t *testing.T) {
+
+	Convey("Given an audit log with three records", t, func() {
+		dir, _ := ioutil.TempDir("", "audit-")
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "uploads.audit")
+
+		l, err := audit.Open(path, nil)
+		So(err, ShouldBeNil)
+		start := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+		So(l.Append(audit.Record{Time: start, Subject: "alice", Filename: "a.csv", Outcome: "completed"}), ShouldBeNil)
+		So(l.Append(audit.Record{Time: start.Add(time.Hour), Subject: "bob", Filename: "b.csv", Outcome: "forbidden"}), ShouldBeNil)
+		So(l.Close(), ShouldBeNil)
+
+		l, err = audit.Open(path, nil)
+		So(err, ShouldBeNil)
+		So(l.Append(audit.Record{Time: start.Add(2 * time.Hour), Subject: "alice", Filename: "c.csv", Outcome: "completed"}), ShouldBeNil)
+		So(l.Close(), ShouldBeNil)
+
+		contents, _ := ioutil.ReadFile(path)
+		lines := strings.SplitAfter(strings.TrimSpace(string(contents)), "\n")
+		So(lines, ShouldHaveLength, 3)
+
+		Convey("Then reopening the log continues the chain", func() {
+			verified, err := audit.Verify(bytes.NewReader(contents), nil)
+			So(err, ShouldBeNil)
+			So(verified, ShouldEqual, 3)
+		})
+
+		Convey("Then editing a record is detected", func() {
+			edited := strings.Replace(string(contents), `"subject":"bob"`, `"subject":"eve"`, 1)
+			verified, err := audit.Verify(strings.NewReader(edited), nil)
+			So(verified, ShouldEqual, 1)
+			So(err, ShouldHaveSameTypeAs, &audit.VerifyError{})
+			So(err.(*audit.VerifyError).Line, ShouldEqual, 2)
+		})
+
+		Convey("Then removing a record is detected", func() {
+			removed := lines[0] + lines[2]
+			verified, err := audit.Verify(strings.NewReader(removed), nil)
+			So(verified, ShouldEqual, 1)
+			So(err.(*audit.VerifyError).Line, ShouldEqual, 2)
+		})
+
+		Convey("Then a last record left incomplete by a crash is removed when the log is reopened", func() {
+			partial := lines[0] + lines[1] + lines[2][:len(lines[2])/2]
+			So(ioutil.WriteFile(path, []byte(partial), 0600), ShouldBeNil)
+
+			_, err := audit.Verify(strings.NewReader(partial), nil)
+			So(err.(*audit.VerifyError).Line, ShouldEqual, 3)
+			records, err := audit.Query(strings.NewReader(partial), audit.Filter{})
+			So(err, ShouldBeNil)
+			So(records, ShouldHaveLength, 2)
+
+			l, err = audit.Open(path, nil)
+			So(err, ShouldBeNil)
+			So(l.Append(audit.Record{Subject: "carol", Outcome: "completed"}), ShouldBeNil)
+			So(l.Close(), ShouldBeNil)
+
+			repaired, _ := ioutil.ReadFile(path)
+			verified, err := audit.Verify(bytes.NewReader(repaired), nil)
+			So(err, ShouldBeNil)
+			So(verified, ShouldEqual, 3)
+		})
+
+		Convey("Then queries return matching records, the most recent first", func() {
+			l, _ = audit.Open(path, nil)
+			defer l.Close()
+
+			records, err := l.Query(audit.Filter{Subject: "alice"})
+			So(err, ShouldBeNil)
+			So(records, ShouldHaveLength, 2)
+			So(records[0].Filename, ShouldEqual, "c.csv")
+			So(records[0].Sequence, ShouldEqual, 3)
+
+			records, _ = l.Query(audit.Filter{Since: start.Add(time.Hour), Until: start.Add(2 * time.Hour)})
+			So(records, ShouldHaveLength, 1)
+			So(records[0].Outcome, ShouldEqual, "forbidden")
+
+			records, _ = l.Query(audit.Filter{Limit: 1})
+			So(records, ShouldHaveLength, 1)
+			So(records[0].Sequence, ShouldEqual, 3)
+		})
+	})
+}
+
+func TestKeyedLog(t *testing.T) {
+
+	Convey("Given an audit log with a key", t, func() {
+		dir, _ := ioutil.TempDir("", "audit-")
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "uploads.audit")
+		key := []byte("0123456789abcdef0123456789abcdef")
+
+		l, err := audit.Open(path, key)
+		So(err, ShouldBeNil)
+		So(l.Append(audit.Record{Subject: "alice", Filename: "a.csv", Outcome: "completed"}), ShouldBeNil)
+		So(l.Append(audit.Record{Subject: "bob", Filename: "b.csv", Outcome: "completed"}), ShouldBeNil)
+		So(l.Close(), ShouldBeNil)
+		contents, _ := ioutil.ReadFile(path)
+
+		Convey("Then it verifies with the key", func() {
+			verified, err := audit.Verify(bytes.NewReader(contents), key)
+			So(err, ShouldBeNil)
+			So(verified, ShouldEqual, 2)
+		})
+
+		Convey("Then it can't be verified without the key", func() {
+			verified, err := audit.Verify(bytes.NewReader(contents), nil)
+			So(verified, ShouldEqual, 0)
+			So(err.(*audit.VerifyError).Line, ShouldEqual, 1)
+		})
+	})
+}
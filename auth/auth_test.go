@@ -0,0 +1,225 @@
+package auth_test
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/auth"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// identityHandler records the identity of the requests it handles.
+type identityHandler struct {
+	identity *auth.Identity
+	called   bool
+}
+
+func (h *identityHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.called = true
+	h.identity = auth.FromContext(req.Context())
+}
+
+func segment(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func signHS256(secret string, claims map[string]interface{}) string {
+	signed := segment(map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + segment(claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	signed := segment(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid}) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// jwksHandler publishes the public half of the key as a JWKS document.
+func jwksHandler(key *rsa.PrivateKey, kid string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}
+}
+
+func TestParseCredentials(t *testing.T) {
+
+	Convey("Credentials are parsed with their optional groups", t, func() {
+		credentials, err := auth.ParseCredentials("alice:pa55:admin|census, bob:s3cret")
+		So(err, ShouldBeNil)
+		So(credentials, ShouldResemble, []auth.Credential{
+			{Name: "alice", Secret: "pa55", Groups: []string{"admin", "census"}},
+			{Name: "bob", Secret: "s3cret"},
+		})
+	})
+
+	Convey("Credentials without a secret are rejected", t, func() {
+		_, err := auth.ParseCredentials("alice")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("A name listed more than once is rejected", t, func() {
+		_, err := auth.ParseCredentials("alice:a,alice:b")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+
+	users, _ := auth.ParseCredentials("alice:pa55:admin")
+	keys, _ := auth.ParseCredentials("ingest-bot:0123456789abcdef")
+
+	Convey("Given middleware authenticating static users, API keys and HS256 bearer tokens", t, func() {
+		next := &identityHandler{}
+		middleware := &auth.Middleware{
+			Authenticators: []auth.Authenticator{
+				auth.NewAPIKeys(keys),
+				&auth.BearerTokens{Verifier: &auth.Verifier{Audience: "uploader", Secret: []byte("secret")}, GroupsClaim: "groups"},
+				auth.NewStaticUsers("uploader", users),
+			},
+			Public: map[string]bool{"/health/live": true},
+		}
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("POST", "/", nil)
+
+		Convey("When a request has no credentials", func() {
+			middleware.Handler(next).ServeHTTP(recorder, request)
+
+			Convey("Then it is rejected, telling the client how to authenticate", func() {
+				So(recorder.Code, ShouldEqual, 401)
+				So(recorder.Body.String(), ShouldContainSubstring, auth.AuthenticationRequired)
+				So(recorder.Header()["Www-Authenticate"], ShouldResemble, []string{`Bearer realm="dp-dd-file-uploader"`, `Basic realm="uploader"`})
+				So(next.called, ShouldBeFalse)
+			})
+		})
+
+		Convey("When a public path is requested without credentials", func() {
+			middleware.Handler(next).ServeHTTP(recorder, httptest.NewRequest("GET", "/health/live", nil))
+
+			Convey("Then it is allowed without an identity", func() {
+				So(next.called, ShouldBeTrue)
+				So(next.identity, ShouldBeNil)
+			})
+		})
+
+		Convey("When a static user logs in with basic auth", func() {
+			request.SetBasicAuth("alice", "pa55")
+			middleware.Handler(next).ServeHTTP(recorder, request)
+
+			Convey("Then the request has their identity", func() {
+				So(next.identity, ShouldResemble, &auth.Identity{Subject: "alice", Groups: []string{"admin"}, Method: auth.MethodStatic})
+			})
+		})
+
+		Convey("When a static user gives the wrong password", func() {
+			request.SetBasicAuth("alice", "wrong")
+			middleware.Handler(next).ServeHTTP(recorder, request)
+
+			Convey("Then it is rejected", func() {
+				So(recorder.Code, ShouldEqual, 401)
+				So(next.called, ShouldBeFalse)
+			})
+		})
+
+		Convey("When a client sends an API key", func() {
+			request.Header.Set(auth.APIKeyHeader, "0123456789abcdef")
+			middleware.Handler(next).ServeHTTP(recorder, request)
+
+			Convey("Then the request has the identity of the key's client", func() {
+				So(next.identity.Subject, ShouldEqual, "ingest-bot")
+				So(next.identity.Method, ShouldEqual, auth.MethodAPIKey)
+			})
+		})
+
+		Convey("When a client sends an unknown API key", func() {
+			request.Header.Set("Authorization", "ApiKey guessed")
+			middleware.Handler(next).ServeHTTP(recorder, request)
+
+			Convey("Then it is rejected", func() {
+				So(recorder.Code, ShouldEqual, 401)
+			})
+		})
+
+		Convey("When a client sends a valid bearer token", func() {
+			token := signHS256("secret", map[string]interface{}{
+				"sub": "svc-1", "aud": []string{"uploader"}, "exp": time.Now().Add(time.Hour).Unix(), "groups": []string{"census"},
+			})
+			request.Header.Set("Authorization", "Bearer "+token)
+			middleware.Handler(next).ServeHTTP(recorder, request)
+
+			Convey("Then the request has the identity in the token", func() {
+				So(next.identity, ShouldResemble, &auth.Identity{Subject: "svc-1", Groups: []string{"census"}, Method: auth.MethodJWT})
+			})
+		})
+
+		Convey("When a client sends an expired bearer token", func() {
+			token := signHS256("secret", map[string]interface{}{"sub": "svc-1", "aud": "uploader", "exp": time.Now().Add(-time.Hour).Unix()})
+			request.Header.Set("Authorization", "Bearer "+token)
+			middleware.Handler(next).ServeHTTP(recorder, request)
+
+			Convey("Then it is rejected", func() {
+				So(recorder.Code, ShouldEqual, 401)
+			})
+		})
+	})
+}
+
+func TestVerifier(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	jwks := httptest.NewServer(jwksHandler(key, "key-1"))
+	defer jwks.Close()
+
+	verifier := &auth.Verifier{Issuer: "https://idp.example.com", Audience: "uploader", KeySet: &auth.KeySet{URL: jwks.URL}}
+	claims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": "https://idp.example.com", "aud": "uploader", "sub": "user-1", "name": "User One",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+	}
+
+	Convey("A token signed with a published RS256 key is verified", t, func() {
+		verified, err := verifier.Verify(signRS256(key, "key-1", claims()))
+		So(err, ShouldBeNil)
+		So(verified.Subject, ShouldEqual, "user-1")
+		So(verified.Name, ShouldEqual, "User One")
+	})
+
+	Convey("A token signed with another key is rejected", t, func() {
+		other, _ := rsa.GenerateKey(rand.Reader, 2048)
+		_, err := verifier.Verify(signRS256(other, "key-1", claims()))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("A token from another issuer is rejected", t, func() {
+		c := claims()
+		c["iss"] = "https://elsewhere.example.com"
+		_, err := verifier.Verify(signRS256(key, "key-1", c))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("An HS256 token is rejected when no secret is configured", t, func() {
+		_, err := verifier.Verify(signHS256("", claims()))
+		So(err, ShouldNotBeNil)
+	})
+}
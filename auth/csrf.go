@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+)
+
+// CSRF tokens stop other sites submitting the upload form on behalf of a logged in browser. Each
+// browser is given a random token in a cookie, which forms must send back in CSRFField, or scripts
+// in the CSRFHeader. Another site can make the browser send the cookie, but can't read it.
+const (
+	CSRFCookie = "dp-upload-csrf"
+	CSRFField  = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// ErrInvalidCSRFToken is returned when a request doesn't send back the token in the browser's cookie.
+var ErrInvalidCSRFToken = errors.New("CSRF token is missing or does not match")
+
+// CSRFToken returns the browser's CSRF token, setting a new one in a cookie if it doesn't have one yet.
+func CSRFToken(w http.ResponseWriter, req *http.Request) string {
+	if cookie, err := req.Cookie(CSRFCookie); err == nil && len(cookie.Value) > 0 {
+		return cookie.Value
+	}
+
+	token := randomString()
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token
+}
+
+// NeedsCSRFToken returns whether requests from the identity must send a CSRF token. Browsers send
+// session cookies and basic auth credentials with every request, whichever site it comes from, but
+// API keys and bearer tokens have to be added by the client, so those requests are exempt.
+func NeedsCSRFToken(id *Identity) bool {
+	return id != nil && (id.Method == MethodSession || id.Method == MethodStatic)
+}
+
+// VerifyCSRFToken checks the token sent with a request matches the one in the browser's cookie.
+func VerifyCSRFToken(req *http.Request, token string) error {
+	cookie, err := req.Cookie(CSRFCookie)
+	if err != nil || len(cookie.Value) == 0 || len(token) == 0 {
+		return ErrInvalidCSRFToken
+	}
+	if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(token)) != 1 {
+		return ErrInvalidCSRFToken
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// The ways a request can be authenticated, recorded on its identity.
+const (
+	MethodSession = "session"
+	MethodAPIKey  = "api-key"
+	MethodJWT     = "jwt"
+	MethodStatic  = "static"
+)
+
+var (
+	// ErrNoCredentials is returned by an authenticator when the request has no credentials it understands,
+	// so the next authenticator should be tried.
+	ErrNoCredentials = errors.New("No credentials")
+	// ErrInvalidCredentials is returned when a request has credentials that are wrong or have expired.
+	ErrInvalidCredentials = errors.New("Invalid credentials")
+)
+
+// Identity is who made a request, and how they proved it.
+type Identity struct {
+	Subject string   `json:"sub"`
+	Name    string   `json:"name,omitempty"`
+	Email   string   `json:"email,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+	Method  string   `json:"method"`
+}
+
+// DisplayName returns the name of the identity, or its subject if it doesn't have one.
+func (id *Identity) DisplayName() string {
+	if len(id.Name) > 0 {
+		return id.Name
+	}
+	return id.Subject
+}
+
+// Owner identifies the account behind the identity, namespaced by how it was authenticated, e.g.
+// api-key:alice, as API key clients, static users and identity provider subjects can share names.
+func (id *Identity) Owner() string {
+	return id.Method + ":" + id.Subject
+}
+
+// Authenticator identifies the caller of a request from one kind of credential.
+type Authenticator interface {
+	// Authenticate returns the identity proven by the request's credentials, ErrNoCredentials
+	// if it has none of this kind, or another error if they are not valid.
+	Authenticate(req *http.Request) (*Identity, error)
+}
+
+// Challenger is implemented by authenticators that tell clients how to authenticate
+// in the WWW-Authenticate header of a 401 response.
+type Challenger interface {
+	Challenge() string
+}
+
+type contextKey int
+
+const identityKey contextKey = iota
+
+// WithIdentity returns a copy of the context carrying the identity.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey, id)
+}
+
+// FromContext returns the identity of the request the context belongs to, or nil if
+// authentication is disabled or the request didn't need it.
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey).(*Identity)
+	return id
+}
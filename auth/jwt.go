@@ -0,0 +1,306 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// leeway allows for clock differences between this service and the token issuer.
+const leeway = time.Minute
+
+// Claims are the standard claims of a JWT used to identify its subject.
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	Expiry            int64    `json:"exp"`
+	NotBefore         int64    `json:"nbf"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	Email             string   `json:"email"`
+	Nonce             string   `json:"nonce"`
+
+	// raw holds every claim, so that groups can be read from a configurable claim.
+	raw map[string]interface{}
+}
+
+// audience is the aud claim, which may be a single string or a list.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a audience) contains(value string) bool {
+	for _, aud := range a {
+		if aud == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Identity returns the identity the claims describe, with groups read from the named claim.
+func (c *Claims) Identity(groupsClaim string, method string) *Identity {
+	id := &Identity{Subject: c.Subject, Name: c.Name, Email: c.Email, Method: method}
+	if len(id.Name) == 0 {
+		id.Name = c.PreferredUsername
+	}
+	if groups, ok := c.raw[groupsClaim].([]interface{}); ok {
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				id.Groups = append(id.Groups, name)
+			}
+		}
+	}
+	return id
+}
+
+// Verifier checks the signature and claims of JWTs. Tokens are signed with RS256, using a key
+// from KeySet, or with HS256, using Secret.
+type Verifier struct {
+	Issuer   string
+	Audience string
+	KeySet   *KeySet
+	Secret   []byte
+	now      func() time.Time
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// Verify returns the claims of the token if it is correctly signed, has been issued by the
+// expected issuer for the expected audience and hasn't expired.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Token is not a JWT")
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("Invalid token header: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid token signature: %v", err)
+	}
+	if err := v.verifySignature(h, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, fmt.Errorf("Invalid token claims: %v", err)
+	}
+	if err := decodeSegment(parts[1], &claims.raw); err != nil {
+		return nil, fmt.Errorf("Invalid token claims: %v", err)
+	}
+
+	now := time.Now()
+	if v.now != nil {
+		now = v.now()
+	}
+	switch {
+	case len(v.Issuer) > 0 && claims.Issuer != v.Issuer:
+		return nil, fmt.Errorf("Token issuer %q is not %q", claims.Issuer, v.Issuer)
+	case len(v.Audience) > 0 && !claims.Audience.contains(v.Audience):
+		return nil, fmt.Errorf("Token audience %v does not include %q", []string(claims.Audience), v.Audience)
+	case claims.Expiry == 0 || now.Add(-leeway).After(time.Unix(claims.Expiry, 0)):
+		return nil, errors.New("Token has expired")
+	case claims.NotBefore != 0 && now.Add(leeway).Before(time.Unix(claims.NotBefore, 0)):
+		return nil, errors.New("Token is not valid yet")
+	case len(claims.Subject) == 0:
+		return nil, errors.New("Token has no subject")
+	}
+
+	return claims, nil
+}
+
+func (v *Verifier) verifySignature(h header, signed string, signature []byte) error {
+	switch h.Algorithm {
+	case "RS256":
+		if v.KeySet == nil {
+			return errors.New("RS256 tokens are not accepted")
+		}
+		key, err := v.KeySet.Key(h.KeyID)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("Token signature is invalid")
+		}
+		return nil
+	case "HS256":
+		if len(v.Secret) == 0 {
+			return errors.New("HS256 tokens are not accepted")
+		}
+		mac := hmac.New(sha256.New, v.Secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errors.New("Token signature is invalid")
+		}
+		return nil
+	default:
+		return fmt.Errorf("Token algorithm %q is not supported", h.Algorithm)
+	}
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// BearerTokens authenticates scripted clients by a JWT sent as an Authorization bearer token.
+type BearerTokens struct {
+	Verifier    *Verifier
+	GroupsClaim string
+}
+
+// Authenticate verifies the request's bearer token.
+func (b *BearerTokens) Authenticate(req *http.Request) (*Identity, error) {
+	scheme, token := authorization(req)
+	if !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrNoCredentials
+	}
+
+	claims, err := b.Verifier.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	return claims.Identity(b.GroupsClaim, MethodJWT), nil
+}
+
+// Challenge tells clients to send a bearer token.
+func (b *BearerTokens) Challenge() string {
+	return `Bearer realm="dp-dd-file-uploader"`
+}
+
+// KeySet is the set of RSA public keys published by an identity provider as a JWKS document.
+// Keys are fetched when first needed, and again when a token is signed with an unknown key, so
+// that keys rotated by the provider are picked up. Tokens signed with known keys are verified
+// while the keys are being fetched.
+type KeySet struct {
+	URL    string
+	Client *http.Client
+
+	mutex   sync.RWMutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+	// fetching is held while the keys are fetched, so only one request fetches them at a time.
+	fetching sync.Mutex
+}
+
+// minRefreshInterval stops tokens with made up key IDs causing the key set to be fetched for every request.
+const minRefreshInterval = time.Minute
+
+// Key returns the key with the given ID.
+func (k *KeySet) Key(id string) (*rsa.PublicKey, error) {
+	if key, _, ok := k.lookup(id); ok {
+		return key, nil
+	}
+
+	k.fetching.Lock()
+	defer k.fetching.Unlock()
+
+	// The keys may have been fetched while waiting
+	key, fetched, ok := k.lookup(id)
+	if ok {
+		return key, nil
+	}
+	if time.Since(fetched) < minRefreshInterval {
+		return nil, fmt.Errorf("Token signing key %q is unknown", id)
+	}
+
+	keys, err := k.fetch()
+	k.mutex.Lock()
+	k.fetched = time.Now()
+	if err == nil {
+		k.keys = keys
+	}
+	k.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if key, ok := keys[id]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("Token signing key %q is unknown", id)
+}
+
+// lookup returns the key with the given ID, if it has been fetched, and when the keys were last fetched.
+func (k *KeySet) lookup(id string) (*rsa.PublicKey, time.Time, bool) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	key, ok := k.keys[id]
+	return key, k.fetched, ok
+}
+
+type jwks struct {
+	Keys []struct {
+		KeyType string `json:"kty"`
+		KeyID   string `json:"kid"`
+		Use     string `json:"use"`
+		N       string `json:"n"`
+		E       string `json:"e"`
+	} `json:"keys"`
+}
+
+// fetch fetches the keys from the JWKS document.
+func (k *KeySet) fetch() (map[string]*rsa.PublicKey, error) {
+	res, err := providerClient(k.Client).Get(k.URL)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to fetch signing keys: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to fetch signing keys: %s returned %s", k.URL, res.Status)
+	}
+
+	var doc jwks
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("Unable to read signing keys: %v", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, key := range doc.Keys {
+		if key.KeyType != "RSA" || (len(key.Use) > 0 && key.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(key.N)
+		e, errE := base64.RawURLEncoding.DecodeString(key.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[key.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ONSdigital/go-ns/handlers/response"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// AuthenticationRequired is the message returned to requests without valid credentials.
+var AuthenticationRequired = "Authentication is required."
+
+// Middleware rejects requests that can't be authenticated by any of its authenticators,
+// and makes the identity of those that can available with FromContext.
+type Middleware struct {
+	Authenticators []Authenticator
+	// OIDC handles browser logins, if configured. Browsers without credentials are sent to log in.
+	OIDC *OIDC
+	// Public are the paths that can be requested without authenticating, e.g. health checks.
+	Public map[string]bool
+}
+
+type errorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Handler authenticates each request before passing it to h.
+func (m *Middleware) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if m.Public[req.URL.Path] {
+			h.ServeHTTP(w, req)
+			return
+		}
+
+		for _, authenticator := range m.Authenticators {
+			id, err := authenticator.Authenticate(req)
+			if err == ErrNoCredentials {
+				continue
+			}
+			if err != nil {
+				log.DebugR(req, "Rejecting request with invalid credentials", log.Data{"error": err.Error()})
+				m.unauthenticated(w, req)
+				return
+			}
+
+			log.DebugR(req, "Authenticated request", log.Data{"subject": id.Subject, "method": id.Method})
+			h.ServeHTTP(w, req.WithContext(WithIdentity(req.Context(), id)))
+			return
+		}
+
+		m.unauthenticated(w, req)
+	})
+}
+
+// unauthenticated sends browsers to log in, if they can, and returns a 401 to everything else.
+func (m *Middleware) unauthenticated(w http.ResponseWriter, req *http.Request) {
+	if m.OIDC != nil && req.Method == http.MethodGet && strings.Contains(req.Header.Get("Accept"), "text/html") {
+		http.Redirect(w, req, LoginPath+"?"+url.Values{"return": {req.URL.RequestURI()}}.Encode(), http.StatusFound)
+		return
+	}
+
+	for _, authenticator := range m.Authenticators {
+		if challenger, ok := authenticator.(Challenger); ok {
+			w.Header().Add("WWW-Authenticate", challenger.Challenge())
+		}
+	}
+
+	err := response.WriteJSON(w, errorResponse{Code: "unauthenticated", Message: AuthenticationRequired}, http.StatusUnauthorized)
+	if err != nil {
+		log.ErrorR(req, err, log.Data{"message": "Failed to write JSON response"})
+	}
+}
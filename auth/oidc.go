@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// The paths browsers log in and out at.
+const (
+	LoginPath    = "/auth/login"
+	CallbackPath = "/auth/callback"
+	LogoutPath   = "/auth/logout"
+)
+
+// stateCookie holds the state of a login between redirecting to the identity provider and its callback.
+const stateCookie = "dp-upload-login"
+
+// loginTimeout is the time allowed to log in with the identity provider.
+const loginTimeout = 10 * time.Minute
+
+// providerTimeout is the time allowed for each request to the identity provider.
+const providerTimeout = 10 * time.Second
+
+// providerClient returns the client to talk to the identity provider with, making one with a timeout
+// if there isn't one, so a provider that stops responding can't hold requests up.
+func providerClient(client *http.Client) *http.Client {
+	if client == nil {
+		return &http.Client{Timeout: providerTimeout}
+	}
+	return client
+}
+
+// OIDC logs browsers in with an OpenID Connect identity provider using the authorization code flow,
+// then keeps them logged in with a session cookie.
+type OIDC struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	GroupsClaim  string
+	Sessions     *Sessions
+	Client       *http.Client
+
+	authorizationEndpoint string
+	tokenEndpoint         string
+	verifier              *Verifier
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Discover reads the endpoints and signing keys of an identity provider from its discovery document.
+func Discover(client *http.Client, issuer string) (authorizationEndpoint, tokenEndpoint string, keys *KeySet, err error) {
+	client = providerClient(client)
+
+	res, err := client.Get(strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", "", nil, fmt.Errorf("Unable to discover OIDC provider %s: %v", issuer, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", "", nil, fmt.Errorf("Unable to discover OIDC provider %s: %s", issuer, res.Status)
+	}
+
+	var doc discovery
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return "", "", nil, fmt.Errorf("Unable to read OIDC discovery document: %v", err)
+	}
+	if doc.Issuer != issuer {
+		return "", "", nil, fmt.Errorf("OIDC provider issuer %q does not match %q", doc.Issuer, issuer)
+	}
+
+	return doc.AuthorizationEndpoint, doc.TokenEndpoint, &KeySet{URL: doc.JWKSURI, Client: client}, nil
+}
+
+// NewOIDC discovers the identity provider and returns an OIDC login for the client.
+func NewOIDC(issuer, clientID, clientSecret, redirectURL string, sessions *Sessions) (*OIDC, error) {
+	client := providerClient(nil)
+	authorizationEndpoint, tokenEndpoint, keys, err := Discover(client, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OIDC{
+		ClientID:              clientID,
+		ClientSecret:          clientSecret,
+		RedirectURL:           redirectURL,
+		Scopes:                []string{"openid", "profile", "email"},
+		GroupsClaim:           "groups",
+		Sessions:              sessions,
+		Client:                client,
+		authorizationEndpoint: authorizationEndpoint,
+		tokenEndpoint:         tokenEndpoint,
+		verifier:              &Verifier{Issuer: issuer, Audience: clientID, KeySet: keys},
+	}, nil
+}
+
+// Authenticate returns the identity of a browser that has logged in.
+func (o *OIDC) Authenticate(req *http.Request) (*Identity, error) {
+	return o.Sessions.Authenticate(req)
+}
+
+type loginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Return   string `json:"return"`
+}
+
+// Login redirects the browser to log in with the identity provider.
+func (o *OIDC) Login(w http.ResponseWriter, req *http.Request) {
+	state := loginState{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: randomString(),
+		Return:   localPath(req.URL.Query().Get("return")),
+	}
+
+	value, err := o.Sessions.seal(purposeLogin, state, loginTimeout)
+	if err != nil {
+		log.ErrorR(req, err, log.Data{"message": "Failed to start login"})
+		http.Error(w, "Unable to log in", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, o.Sessions.cookie(stateCookie, value, loginTimeout))
+
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.ClientID},
+		"redirect_uri":          {o.RedirectURL},
+		"scope":                 {strings.Join(o.Scopes, " ")},
+		"state":                 {state.State},
+		"nonce":                 {state.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(o.authorizationEndpoint, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, req, o.authorizationEndpoint+separator+query.Encode(), http.StatusFound)
+}
+
+// Callback completes a login: it exchanges the authorization code for an ID token, verifies it,
+// and starts a session for the identity it names.
+func (o *OIDC) Callback(w http.ResponseWriter, req *http.Request) {
+	var state loginState
+	cookie, err := req.Cookie(stateCookie)
+	if err != nil || o.Sessions.open(purposeLogin, cookie.Value, &state) != nil || req.URL.Query().Get("state") != state.State {
+		log.DebugR(req, "Rejecting login callback with missing or invalid state", nil)
+		http.Error(w, "Login has expired, please try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, o.Sessions.cookie(stateCookie, "", -1))
+
+	if reason := req.URL.Query().Get("error"); len(reason) > 0 {
+		log.DebugR(req, "Identity provider rejected login", log.Data{"error": reason, "description": req.URL.Query().Get("error_description")})
+		http.Error(w, "Login failed", http.StatusForbidden)
+		return
+	}
+
+	claims, err := o.exchange(req.URL.Query().Get("code"), state.Verifier)
+	if err == nil && claims.Nonce != state.Nonce {
+		err = fmt.Errorf("ID token nonce does not match")
+	}
+	if err != nil {
+		log.ErrorR(req, err, log.Data{"message": "Failed to complete login"})
+		http.Error(w, "Login failed", http.StatusForbidden)
+		return
+	}
+
+	id := claims.Identity(o.GroupsClaim, MethodSession)
+	if err := o.Sessions.Start(w, id); err != nil {
+		log.ErrorR(req, err, log.Data{"message": "Failed to start session"})
+		http.Error(w, "Login failed", http.StatusInternalServerError)
+		return
+	}
+
+	log.DebugR(req, "Logged in", log.Data{"subject": id.Subject})
+	http.Redirect(w, req, state.Return, http.StatusFound)
+}
+
+// Logout ends the browser's session.
+func (o *OIDC) Logout(w http.ResponseWriter, req *http.Request) {
+	o.Sessions.End(w)
+	http.Redirect(w, req, "/", http.StatusFound)
+}
+
+type tokenResponse struct {
+	IDToken string `json:"id_token"`
+}
+
+// exchange redeems an authorization code at the token endpoint and verifies the ID token returned.
+func (o *OIDC) exchange(code string, verifier string) (*Claims, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest(http.MethodPost, o.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to exchange authorization code: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to exchange authorization code: token endpoint returned %s", res.Status)
+	}
+
+	var token tokenResponse
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("Unable to read token response: %v", err)
+	}
+	return o.verifier.Verify(token.IDToken)
+}
+
+// localPath returns path if it is on this site, so that a login can't be used to redirect elsewhere.
+func localPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
+	}
+	return path
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
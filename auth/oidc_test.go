@@ -0,0 +1,164 @@
+package auth_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/auth"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeProvider is an OpenID Connect provider that issues an ID token for the nonce of the last login.
+type fakeProvider struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	nonce string
+}
+
+func newFakeProvider() *fakeProvider {
+	p := &fakeProvider{}
+	p.key, _ = rsa.GenerateKey(rand.Reader, 2048)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", jwksHandler(p.key, "key-1"))
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		client, secret, _ := req.BasicAuth()
+		if client != "uploader" || secret != "client-secret" || req.FormValue("code") != "code-1" || len(req.FormValue("code_verifier")) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"id_token": signRS256(p.key, "key-1", map[string]interface{}{
+				"iss": p.URL, "aud": "uploader", "sub": "user-1", "name": "User One", "nonce": p.nonce,
+				"exp": time.Now().Add(time.Hour).Unix(), "groups": []string{"census"},
+			}),
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+func TestOIDC(t *testing.T) {
+	provider := newFakeProvider()
+	defer provider.Close()
+
+	Convey("Given the uploader logs browsers in with an OIDC provider", t, func() {
+		sessions, err := auth.NewSessions(nil, time.Hour, false)
+		So(err, ShouldBeNil)
+		oidc, err := auth.NewOIDC(provider.URL, "uploader", "client-secret", "http://uploader/auth/callback", sessions)
+		So(err, ShouldBeNil)
+
+		next := &identityHandler{}
+		middleware := &auth.Middleware{Authenticators: []auth.Authenticator{oidc}, OIDC: oidc}
+
+		Convey("When a browser without a session opens the upload page", func() {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest("GET", "/", nil)
+			request.Header.Set("Accept", "text/html")
+			middleware.Handler(next).ServeHTTP(recorder, request)
+
+			Convey("Then it is sent to log in", func() {
+				So(recorder.Code, ShouldEqual, http.StatusFound)
+				So(recorder.Header().Get("Location"), ShouldEqual, auth.LoginPath+"?return=%2F")
+			})
+		})
+
+		Convey("When a browser logs in", func() {
+			recorder := httptest.NewRecorder()
+			oidc.Login(recorder, httptest.NewRequest("GET", auth.LoginPath+"?return=/?dataset=CPI", nil))
+
+			So(recorder.Code, ShouldEqual, http.StatusFound)
+			authorize, _ := url.Parse(recorder.Header().Get("Location"))
+			So(authorize.Path, ShouldEqual, "/authorize")
+			So(authorize.Query().Get("client_id"), ShouldEqual, "uploader")
+			So(authorize.Query().Get("code_challenge_method"), ShouldEqual, "S256")
+			provider.nonce = authorize.Query().Get("nonce")
+			loginCookies := recorder.Result().Cookies()
+
+			callback := func(state string) *httptest.ResponseRecorder {
+				recorder := httptest.NewRecorder()
+				request := httptest.NewRequest("GET", auth.CallbackPath+"?code=code-1&state="+url.QueryEscape(state), nil)
+				for _, cookie := range loginCookies {
+					request.AddCookie(cookie)
+				}
+				oidc.Callback(recorder, request)
+				return recorder
+			}
+
+			Convey("Then the callback starts a session and returns the browser to where it was", func() {
+				recorder := callback(authorize.Query().Get("state"))
+				So(recorder.Code, ShouldEqual, http.StatusFound)
+				So(recorder.Header().Get("Location"), ShouldEqual, "/?dataset=CPI")
+
+				request := httptest.NewRequest("POST", "/", nil)
+				for _, cookie := range recorder.Result().Cookies() {
+					if cookie.Name == auth.SessionCookie {
+						request.AddCookie(cookie)
+					}
+				}
+				middleware.Handler(next).ServeHTTP(httptest.NewRecorder(), request)
+				So(next.identity, ShouldResemble, &auth.Identity{Subject: "user-1", Name: "User One", Groups: []string{"census"}, Method: auth.MethodSession})
+			})
+
+			Convey("Then a callback with the wrong state is rejected", func() {
+				recorder := callback("forged")
+				So(recorder.Code, ShouldEqual, http.StatusBadRequest)
+			})
+
+			Convey("Then the login cookie can't be used as a session", func() {
+				request := httptest.NewRequest("POST", "/", nil)
+				for _, cookie := range loginCookies {
+					request.AddCookie(&http.Cookie{Name: auth.SessionCookie, Value: cookie.Value})
+				}
+				recorder := httptest.NewRecorder()
+				middleware.Handler(next).ServeHTTP(recorder, request)
+				So(recorder.Code, ShouldEqual, 401)
+				So(next.called, ShouldBeFalse)
+			})
+		})
+
+		Convey("When a login asks to return to another site", func() {
+			recorder := httptest.NewRecorder()
+			oidc.Login(recorder, httptest.NewRequest("GET", auth.LoginPath+"?return=//evil.example.com", nil))
+			authorize, _ := url.Parse(recorder.Header().Get("Location"))
+			provider.nonce = authorize.Query().Get("nonce")
+
+			request := httptest.NewRequest("GET", auth.CallbackPath+"?code=code-1&state="+url.QueryEscape(authorize.Query().Get("state")), nil)
+			for _, cookie := range recorder.Result().Cookies() {
+				request.AddCookie(cookie)
+			}
+			callback := httptest.NewRecorder()
+			oidc.Callback(callback, request)
+
+			Convey("Then the browser is returned to the upload page instead", func() {
+				So(callback.Header().Get("Location"), ShouldEqual, "/")
+			})
+		})
+
+		Convey("When a session cookie has been tampered with", func() {
+			request := httptest.NewRequest("POST", "/", nil)
+			request.AddCookie(&http.Cookie{Name: auth.SessionCookie, Value: "eyJzdWIiOiJhZG1pbiJ9.forged"})
+			recorder := httptest.NewRecorder()
+			middleware.Handler(next).ServeHTTP(recorder, request)
+
+			Convey("Then it is rejected", func() {
+				So(recorder.Code, ShouldEqual, 401)
+				So(next.called, ShouldBeFalse)
+			})
+		})
+	})
+}
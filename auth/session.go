@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SessionCookie is the name of the cookie holding a browser's session after it has logged in.
+const SessionCookie = "dp-upload-session"
+
+// Sessions keeps browsers logged in with a signed cookie holding their identity, so that no
+// session state needs to be shared between instances of the service.
+type Sessions struct {
+	TTL time.Duration
+	// Secure marks cookies to be sent over HTTPS only.
+	Secure bool
+
+	key []byte
+	now func() time.Time
+}
+
+// NewSessions returns sessions signed with the given key. If the key is empty a random one is
+// generated, and sessions won't survive a restart or be valid on other instances.
+func NewSessions(key []byte, ttl time.Duration, secure bool) (*Sessions, error) {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	return &Sessions{TTL: ttl, Secure: secure, key: key}, nil
+}
+
+// The purposes of sealed values. A value sealed for one purpose can't be opened for another, so e.g. the
+// state of a login can't be passed off as a session.
+const (
+	purposeSession = "session"
+	purposeLogin   = "login"
+)
+
+type sealed struct {
+	Purpose string          `json:"p"`
+	Expires int64           `json:"exp"`
+	Value   json.RawMessage `json:"v"`
+}
+
+// seal signs a value for a purpose so that it can be handed to a browser and checked when it comes back.
+func (s *Sessions) seal(purpose string, value interface{}, ttl time.Duration) (string, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(sealed{Purpose: purpose, Expires: s.time().Add(ttl).Unix(), Value: b})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + s.sign(encoded), nil
+}
+
+// open checks the signature, purpose and expiry of a sealed value and decodes it into v.
+func (s *Sessions) open(purpose string, token string, v interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(s.sign(parts[0])), []byte(parts[1])) {
+		return ErrInvalidCredentials
+	}
+
+	var payload sealed
+	if err := decodeSegment(parts[0], &payload); err != nil || payload.Purpose != purpose {
+		return ErrInvalidCredentials
+	}
+	if s.time().Unix() > payload.Expires {
+		return errors.New("Session has expired")
+	}
+	return json.Unmarshal(payload.Value, v)
+}
+
+func (s *Sessions) sign(value string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Sessions) time() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// Start logs the browser in as the identity.
+func (s *Sessions) Start(w http.ResponseWriter, id *Identity) error {
+	if len(id.Subject) == 0 {
+		return errors.New("Can't start a session for an identity without a subject")
+	}
+	session := *id
+	session.Method = MethodSession
+	value, err := s.seal(purposeSession, session, s.TTL)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, s.cookie(SessionCookie, value, s.TTL))
+	return nil
+}
+
+// End logs the browser out.
+func (s *Sessions) End(w http.ResponseWriter) {
+	http.SetCookie(w, s.cookie(SessionCookie, "", -1))
+}
+
+// Authenticate returns the identity of a browser that has logged in.
+func (s *Sessions) Authenticate(req *http.Request) (*Identity, error) {
+	cookie, err := req.Cookie(SessionCookie)
+	if err != nil || len(cookie.Value) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	id := &Identity{}
+	if err := s.open(purposeSession, cookie.Value, id); err != nil {
+		return nil, err
+	}
+	// Sessions are only started for identities with a subject, and an empty one would match unowned uploads
+	if len(id.Subject) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+	return id, nil
+}
+
+func (s *Sessions) cookie(name string, value string, ttl time.Duration) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   s.Secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if ttl < 0 {
+		cookie.MaxAge = -1
+	} else {
+		cookie.MaxAge = int(ttl.Seconds())
+	}
+	return cookie
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Credential is a user or client with a shared secret: a password for static users, or an API key.
+type Credential struct {
+	Name   string
+	Secret string
+	Groups []string
+}
+
+// ParseCredentials parses a comma separated list of name:secret credentials, each optionally
+// followed by :group1|group2 naming the groups it belongs to, e.g. "alice:pa55:admin|census,bob:pa55".
+func ParseCredentials(value string) ([]Credential, error) {
+	var credentials []Credential
+	seen := make(map[string]bool)
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("%q must be name:secret or name:secret:groups", parts[0])
+		}
+		if seen[parts[0]] {
+			return nil, fmt.Errorf("%q is listed more than once", parts[0])
+		}
+		seen[parts[0]] = true
+
+		credential := Credential{Name: parts[0], Secret: parts[1]}
+		if len(parts) == 3 && len(parts[2]) > 0 {
+			credential.Groups = strings.Split(parts[2], "|")
+		}
+		credentials = append(credentials, credential)
+	}
+
+	return credentials, nil
+}
+
+// StaticUsers authenticates a fixed list of users with HTTP basic auth. It is meant for local
+// development, where there is no identity provider to log in with.
+type StaticUsers struct {
+	Realm string
+	users map[string]Credential
+}
+
+// NewStaticUsers returns an authenticator for the given users.
+func NewStaticUsers(realm string, users []Credential) *StaticUsers {
+	s := &StaticUsers{Realm: realm, users: make(map[string]Credential, len(users))}
+	for _, user := range users {
+		s.users[user.Name] = user
+	}
+	return s
+}
+
+// Authenticate checks the username and password of a basic auth request.
+func (s *StaticUsers) Authenticate(req *http.Request) (*Identity, error) {
+	name, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	user, found := s.users[name]
+	if !found || !secretsEqual(password, user.Secret) {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Identity{Subject: user.Name, Groups: user.Groups, Method: MethodStatic}, nil
+}
+
+// Challenge asks browsers to prompt for a username and password.
+func (s *StaticUsers) Challenge() string {
+	return fmt.Sprintf("Basic realm=%q", s.Realm)
+}
+
+// APIKeyHeader is the header scripted clients send their API key in. An Authorization header
+// of "ApiKey <key>" is also accepted.
+const APIKeyHeader = "X-API-Key"
+
+// APIKeys authenticates scripted clients by the API key they send.
+type APIKeys struct {
+	keys map[[sha256.Size]byte]Credential
+}
+
+// NewAPIKeys returns an authenticator for the given keys, named by the client each belongs to.
+func NewAPIKeys(keys []Credential) *APIKeys {
+	a := &APIKeys{keys: make(map[[sha256.Size]byte]Credential, len(keys))}
+	for _, key := range keys {
+		a.keys[sha256.Sum256([]byte(key.Secret))] = key
+	}
+	return a
+}
+
+// Authenticate looks up the client the request's API key belongs to. Keys are looked up by
+// their hash so that the time taken doesn't reveal how much of a key matched.
+func (a *APIKeys) Authenticate(req *http.Request) (*Identity, error) {
+	key := req.Header.Get(APIKeyHeader)
+	if len(key) == 0 {
+		scheme, value := authorization(req)
+		if !strings.EqualFold(scheme, "ApiKey") {
+			return nil, ErrNoCredentials
+		}
+		key = value
+	}
+
+	client, found := a.keys[sha256.Sum256([]byte(key))]
+	if !found {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Identity{Subject: client.Name, Groups: client.Groups, Method: MethodAPIKey}, nil
+}
+
+// authorization splits the request's Authorization header into its scheme and credentials.
+func authorization(req *http.Request) (string, string) {
+	parts := strings.SplitN(strings.TrimSpace(req.Header.Get("Authorization")), " ", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], strings.TrimSpace(parts[1])
+}
+
+func secretsEqual(a, b string) bool {
+	hashA, hashB := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(hashA[:], hashB[:]) == 1
+}
@@ -0,0 +1,327 @@
+// Package client uploads files to the service's JSON API and follows them until they have been
+// processed. It uses the request and response types of the handlers, so can't drift from the server.
+package client
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/auth"
+	"github.com/ONSdigital/dp-dd-file-uploader/handler"
+	"github.com/ONSdigital/dp-dd-file-uploader/metadata"
+)
+
+// CodeUnavailable is the code of the error returned when the service couldn't be reached, or didn't
+// respond with an error of its own.
+const CodeUnavailable = "unavailable"
+
+// Client uploads files to the service. Its fields can be changed until it is first used.
+type Client struct {
+	// URL is where the service is, e.g. http://localhost:20019.
+	URL string
+	// HTTPClient sends the requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+	// APIKey, if set, authenticates each request.
+	APIKey string
+	// Token, if set, is a bearer JWT authenticating each request.
+	Token string
+	// Retries is the number of times a request is retried when the service can't be reached, is busy
+	// or is limiting the uploads of the caller.
+	Retries int
+	// RetryWait is how long to wait before the first retry, doubling each time. A Retry-After sent by
+	// the service is used instead.
+	RetryWait time.Duration
+	// PollInterval is how often the status of an upload is checked while waiting for it to finish.
+	PollInterval time.Duration
+}
+
+// New returns a client for the service at the given URL, retrying requests a few times.
+func New(serviceURL string) *Client {
+	return &Client{
+		URL:          strings.TrimSuffix(serviceURL, "/"),
+		Retries:      5,
+		RetryWait:    time.Second,
+		PollInterval: time.Second,
+	}
+}
+
+// Error is an error response from the service, or why a request couldn't be sent.
+type Error struct {
+	// Status is the HTTP status of the response, or zero if there wasn't one.
+	Status int
+	handlers.Response
+	// RetryAfter is how long the service asked the client to wait before trying again.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.Status == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%s (%d %s)", e.Message, e.Status, e.Code)
+}
+
+// temporary returns whether the request might succeed if it is sent again.
+func (e *Error) temporary() bool {
+	switch e.Status {
+	case 0, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// UploadOptions control how a file is uploaded.
+type UploadOptions struct {
+	// Name is the name the file is uploaded as. If empty, the name of the file is used.
+	Name string
+	// Metadata describes the dataset the file belongs to.
+	Metadata metadata.Metadata
+	// Compress gzips the file as it is sent. The service decompresses it as it is received.
+	Compress bool
+	// Progress, if set, is called as the file is read with the number of bytes sent so far. It starts
+	// again from zero if the upload is retried.
+	Progress func(sent int64)
+}
+
+// Upload sends a file with PUT /api/v1/files/{filename}, retrying from the start of the file if the
+// service can't be reached or is busy, and returns the status of the queued upload. A refused upload
+// returns an *Error. The service can't resume a partly sent file, so a file whose connection dropped
+// after it was received may be uploaded twice; Find checks for an earlier upload first.
+func (c *Client) Upload(ctx context.Context, path string, options UploadOptions) (handlers.UploadStatus, error) {
+	name := options.Name
+	if len(name) == 0 {
+		name = filepath.Base(path)
+	}
+
+	var status handlers.UploadStatus
+	err := c.retry(ctx, func() (*http.Response, error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		var body io.Reader = file
+		if options.Progress != nil {
+			body = &progressReader{reader: file, progress: options.Progress}
+		}
+		if options.Compress {
+			body = compress(body)
+		}
+
+		req, err := http.NewRequest("PUT", c.URL+handlers.APIPath+"/files/"+url.PathEscape(name), body)
+		if err != nil {
+			return nil, err
+		}
+		if !options.Compress {
+			info, err := file.Stat()
+			if err != nil {
+				return nil, err
+			}
+			req.ContentLength = info.Size()
+		} else {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		options.Metadata.SetHeaders(req.Header)
+		return c.do(ctx, req)
+	}, http.StatusAccepted, &status)
+	return status, err
+}
+
+// Get returns the status of an upload.
+func (c *Client) Get(ctx context.Context, id string) (handlers.UploadStatus, error) {
+	var status handlers.UploadStatus
+	err := c.retry(ctx, func() (*http.Response, error) {
+		req, err := http.NewRequest("GET", c.URL+handlers.APIPath+"/uploads/"+url.PathEscape(id), nil)
+		if err != nil {
+			return nil, err
+		}
+		return c.do(ctx, req)
+	}, http.StatusOK, &status)
+	return status, err
+}
+
+// List returns the caller's recent uploads with the given status, or all of them if status is empty,
+// the most recent first.
+func (c *Client) List(ctx context.Context, status string, limit int) ([]handlers.UploadStatus, error) {
+	query := url.Values{"limit": {strconv.Itoa(limit)}}
+	if len(status) > 0 {
+		query.Set("status", status)
+	}
+
+	var list handlers.UploadList
+	err := c.retry(ctx, func() (*http.Response, error) {
+		req, err := http.NewRequest("GET", c.URL+handlers.APIPath+"/uploads?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		return c.do(ctx, req)
+	}, http.StatusOK, &list)
+	return list.Uploads, err
+}
+
+// Wait polls the status of an upload until it has finished, or the context is done.
+func (c *Client) Wait(ctx context.Context, id string) (handlers.UploadStatus, error) {
+	for {
+		status, err := c.Get(ctx, id)
+		if err != nil || status.Finished() {
+			return status, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-time.After(c.PollInterval):
+		}
+	}
+}
+
+// Find returns the most recent of the caller's uploads of a file with the given name and SHA-256 that
+// completed or is still being processed, or nil if there isn't one, so a file already uploaded isn't
+// uploaded again.
+func (c *Client) Find(ctx context.Context, name string, sha string) (*handlers.UploadStatus, error) {
+	uploads, err := c.List(ctx, "", 1000)
+	if err != nil {
+		return nil, err
+	}
+	for _, upload := range uploads {
+		if upload.Filename != name || upload.SHA256 != sha {
+			continue
+		}
+		switch upload.Status {
+		case handlers.StatusQueued, handlers.StatusProcessing, handlers.StatusCompleted:
+			return &upload, nil
+		}
+	}
+	return nil, nil
+}
+
+// HashFile returns the hex encoded SHA-256 of a file, as the service records it.
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	sha := sha256.New()
+	if _, err = io.Copy(sha, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sha.Sum(nil)), nil
+}
+
+// retry sends a request until it gets a response other than a temporary error, or runs out of retries,
+// decoding the body of the expected response into v.
+func (c *Client) retry(ctx context.Context, send func() (*http.Response, error), expected int, v interface{}) error {
+	wait := c.RetryWait
+	for attempt := 0; ; attempt++ {
+		resp, err := send()
+		err = decode(resp, err, expected, v)
+		e, ok := err.(*Error)
+		if err == nil || !ok || !e.temporary() || attempt >= c.Retries || ctx.Err() != nil {
+			return err
+		}
+
+		delay := wait
+		if e.RetryAfter > 0 {
+			delay = e.RetryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		wait *= 2
+	}
+}
+
+// do sends an authenticated request.
+func (c *Client) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	if len(c.APIKey) > 0 {
+		req.Header.Set(auth.APIKeyHeader, c.APIKey)
+	}
+	if len(c.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return httpClient.Do(req.WithContext(ctx))
+}
+
+// decode reads the body of the expected response into v, returning the error the service responded
+// with instead, or an Error if the request couldn't be sent.
+func decode(resp *http.Response, err error, expected int, v interface{}) error {
+	if err != nil {
+		if _, ok := err.(*url.Error); ok {
+			return &Error{Response: handlers.Response{Code: CodeUnavailable, Message: err.Error()}}
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &Error{Response: handlers.Response{Code: CodeUnavailable, Message: err.Error()}}
+	}
+	if resp.StatusCode == expected {
+		return json.Unmarshal(body, v)
+	}
+
+	e := &Error{Status: resp.StatusCode}
+	if json.Unmarshal(body, &e.Response) != nil || len(e.Code) == 0 {
+		e.Response = handlers.Response{Code: CodeUnavailable, Message: http.StatusText(resp.StatusCode)}
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return e
+}
+
+// compress returns a reader of the gzipped content of the given reader, compressed as it is read.
+func compress(reader io.Reader) io.Reader {
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(pipeWriter)
+		_, err := io.Copy(gz, reader)
+		if err == nil {
+			err = gz.Close()
+		}
+		pipeWriter.CloseWithError(err)
+	}()
+	return pipeReader
+}
+
+// progressReader reports the number of bytes read so far.
+type progressReader struct {
+	reader   io.Reader
+	sent     int64
+	progress func(sent int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.sent += int64(n)
+		r.progress(r.sent)
+	}
+	return n, err
+}
@@ -0,0 +1,172 @@
+package client_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/aws"
+	"github.com/ONSdigital/dp-dd-file-uploader/client"
+	"github.com/ONSdigital/dp-dd-file-uploader/event/eventtest"
+	"github.com/ONSdigital/dp-dd-file-uploader/file/filetest"
+	"github.com/ONSdigital/dp-dd-file-uploader/handler"
+	"github.com/ONSdigital/dp-dd-file-uploader/health"
+	"github.com/ONSdigital/dp-dd-file-uploader/metadata"
+	"github.com/ONSdigital/dp-dd-file-uploader/metrics"
+	"github.com/ONSdigital/dp-dd-file-uploader/render"
+	"github.com/ONSdigital/dp-dd-file-uploader/server"
+	"github.com/ONSdigital/dp-dd-file-uploader/worker"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newService starts an uploader service with dummy dependencies.
+func newService() (*httptest.Server, *filetest.DummyFileStore) {
+	s3URL, _ := url.Parse("s3://bucket/dir")
+	fileStore := filetest.NewDummyFileStore()
+
+	uploads := &handlers.UploadService{
+		FileStore:      fileStore,
+		EventProducer:  eventtest.NewDummyEventProducer(),
+		S3Config:       aws.NewAWSConfig("region1", s3URL),
+		Workers:        worker.NewPool(1, 4),
+		Renderer:       render.New(),
+		TempDir:        os.TempDir(),
+		MetadataSchema: metadata.DefaultSchema(),
+	}
+	srv := server.New(":0", time.Minute, uploads, health.NewChecker(time.Second, 0), nil)
+	return httptest.NewServer(srv.HTTPServer.Handler), fileStore
+}
+
+func writeFile(content string) string {
+	file, _ := ioutil.TempFile("", "dp-upload-")
+	file.WriteString(content)
+	file.Close()
+	return file.Name()
+}
+
+func TestUpload(t *testing.T) {
+	ctx := context.Background()
+	path := writeFile("a,b,c\n1,2,3\n")
+	defer os.Remove(path)
+	sha, err := client.HashFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Given a client of the service", t, func() {
+		service, fileStore := newService()
+		defer service.Close()
+		c := client.New(service.URL)
+		c.PollInterval = 10 * time.Millisecond
+
+		Convey("When a file is uploaded compressed, with metadata", func() {
+			status, err := c.Upload(ctx, path, client.UploadOptions{
+				Name:     "cpi.csv",
+				Metadata: metadata.Metadata{DatasetID: "CPI", ReleaseDate: "2017-06-30"},
+				Compress: true,
+			})
+
+			Convey("Then it is queued with its metadata, and received as it was before it was compressed", func() {
+				So(err, ShouldBeNil)
+				So(status.Filename, ShouldEqual, "cpi.csv")
+				So(status.Metadata, ShouldResemble, &metadata.Metadata{DatasetID: "CPI", ReleaseDate: "2017-06-30"})
+				So(status.SHA256, ShouldEqual, sha)
+			})
+
+			Convey("Then it can be waited for until it has completed", func() {
+				status, err = c.Wait(ctx, status.ID)
+				So(err, ShouldBeNil)
+				So(status.Status, ShouldEqual, handlers.StatusCompleted)
+				So(fileStore.Invocations, ShouldEqual, 1)
+			})
+
+			Convey("Then it can be found by its name and content, so it isn't uploaded again", func() {
+				found, err := c.Find(ctx, "cpi.csv", sha)
+				So(err, ShouldBeNil)
+				So(found, ShouldNotBeNil)
+				So(found.ID, ShouldEqual, status.ID)
+
+				found, err = c.Find(ctx, "cpi.csv", "0000")
+				So(err, ShouldBeNil)
+				So(found, ShouldBeNil)
+			})
+		})
+
+		Convey("When a file is uploaded with invalid metadata", func() {
+			_, err := c.Upload(ctx, path, client.UploadOptions{Metadata: metadata.Metadata{ReleaseDate: "June"}})
+
+			Convey("Then the service's error is returned", func() {
+				e, ok := err.(*client.Error)
+				So(ok, ShouldBeTrue)
+				So(e.Status, ShouldEqual, http.StatusBadRequest)
+				So(e.Code, ShouldEqual, metrics.OutcomeInvalidMetadata)
+				So(fileStore.Invocations, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When progress is followed", func() {
+			var sent int64
+			_, err := c.Upload(ctx, path, client.UploadOptions{Progress: func(n int64) { sent = n }})
+
+			Convey("Then every byte of the file is reported", func() {
+				So(err, ShouldBeNil)
+				So(sent, ShouldEqual, 12)
+			})
+		})
+	})
+
+	Convey("Given a service that is busy the first time a file is uploaded", t, func() {
+		service, _ := newService()
+		defer service.Close()
+		attempts := 0
+		busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if attempts++; attempts == 1 {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{"code":"busy","message":"Too many uploads are being processed, please try again shortly."}`))
+				return
+			}
+			proxy, _ := http.NewRequest(req.Method, service.URL+req.URL.RequestURI(), req.Body)
+			proxy.Header = req.Header
+			proxy.ContentLength = req.ContentLength
+			resp, err := http.DefaultClient.Do(proxy)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			defer resp.Body.Close()
+			w.WriteHeader(resp.StatusCode)
+			body, _ := ioutil.ReadAll(resp.Body)
+			w.Write(body)
+		}))
+		defer busy.Close()
+		c := client.New(busy.URL)
+		c.RetryWait = time.Millisecond
+
+		Convey("When a file is uploaded", func() {
+			status, err := c.Upload(ctx, path, client.UploadOptions{})
+
+			Convey("Then it is sent again once the service has room", func() {
+				So(err, ShouldBeNil)
+				So(attempts, ShouldEqual, 2)
+				So(status.SHA256, ShouldEqual, sha)
+			})
+		})
+
+		Convey("When retries are disabled", func() {
+			c.Retries = 0
+			_, err := c.Upload(ctx, path, client.UploadOptions{})
+
+			Convey("Then the busy error is returned", func() {
+				So(err, ShouldNotBeNil)
+				So(err.(*client.Error).Code, ShouldEqual, metrics.OutcomeBusy)
+				So(attempts, ShouldEqual, 1)
+			})
+		})
+	})
+}
@@ -0,0 +1,302 @@
+// Command dp-upload uploads files, or every file in directories, to dp-dd-file-uploader and waits for
+// them to be processed, e.g.
+//
+//	dp-upload -url https://uploader.example.com -dataset CPI -release-date 2017-06-30 data/cpi/
+//
+// It exits with 0 if every file completed, 3 if any was rejected because its content or metadata is
+// invalid, 1 if any other upload failed and 2 if it was used wrongly.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/client"
+	"github.com/ONSdigital/dp-dd-file-uploader/handler"
+	"github.com/ONSdigital/dp-dd-file-uploader/metadata"
+	"github.com/ONSdigital/dp-dd-file-uploader/metrics"
+)
+
+// The exit codes of the command.
+const (
+	exitCompleted = 0
+	exitFailed    = 1
+	exitUsage     = 2
+	exitRejected  = 3
+)
+
+// codeClientError is the code of a file that couldn't be uploaded because of a problem on this side,
+// e.g. it couldn't be read.
+const codeClientError = "client_error"
+
+type options struct {
+	client   *client.Client
+	upload   client.UploadOptions
+	resume   bool
+	wait     bool
+	timeout  time.Duration
+	json     bool
+	progress bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("dp-upload", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: dp-upload [flags] file|directory...")
+		flags.PrintDefaults()
+	}
+	serviceURL := flags.String("url", envOr("DP_UPLOAD_URL", "http://localhost:20019"), "the URL of the uploader service, or set DP_UPLOAD_URL")
+	// Credentials are read from the environment after parsing, so the usage doesn't show them
+	apiKey := flags.String("api-key", "", "the API key to authenticate with, or set DP_UPLOAD_API_KEY")
+	token := flags.String("token", "", "a bearer JWT to authenticate with, or set DP_UPLOAD_TOKEN")
+	var meta metadata.Metadata
+	flags.StringVar(&meta.DatasetID, "dataset", "", "the ID of the dataset the files belong to")
+	flags.StringVar(&meta.Edition, "edition", "", "the edition of the dataset")
+	flags.StringVar(&meta.Version, "version", "", "the version of the dataset")
+	flags.StringVar(&meta.ReleaseDate, "release-date", "", "the date the dataset is released, e.g. 2017-06-30")
+	flags.StringVar(&meta.Contact, "contact", "", "the email address of the dataset's contact")
+	flags.StringVar(&meta.Notes, "notes", "", "notes about the upload")
+	compress := flags.Bool("compress", true, "gzip files as they are sent, unless they are already compressed")
+	retries := flags.Int("retries", 5, "the number of times to retry a request when the service is unavailable or busy")
+	resume := flags.Bool("resume", false, "skip files you have already uploaded, matched by name and SHA-256, following them instead")
+	wait := flags.Bool("wait", true, "wait for each upload to be processed")
+	timeout := flags.Duration("timeout", 30*time.Minute, "the longest to wait for the uploads to be processed")
+	jsonOutput := flags.Bool("json", false, "write the results as JSON to stdout, for CI pipelines")
+	quiet := flags.Bool("quiet", false, "don't show progress bars")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 || *retries < 0 || *timeout <= 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	paths, err := findFiles(flags.Args())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	if len(*apiKey) == 0 {
+		*apiKey = os.Getenv("DP_UPLOAD_API_KEY")
+	}
+	if len(*token) == 0 {
+		*token = os.Getenv("DP_UPLOAD_TOKEN")
+	}
+
+	c := client.New(*serviceURL)
+	c.APIKey, c.Token, c.Retries = *apiKey, *token, *retries
+	o := options{
+		client:   c,
+		upload:   client.UploadOptions{Metadata: meta, Compress: *compress},
+		resume:   *resume,
+		wait:     *wait,
+		timeout:  *timeout,
+		json:     *jsonOutput,
+		progress: !*quiet && isTerminal(os.Stderr),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	results := uploadFiles(ctx, paths, o, stderr)
+	if o.wait {
+		waitForUploads(ctx, results, o)
+	}
+
+	if o.json {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(results)
+	} else {
+		for _, result := range results.Results {
+			fmt.Fprintln(stdout, describe(result))
+		}
+	}
+	return exitCode(results, o.wait)
+}
+
+// findFiles returns the files named, and every file beneath the directories named, skipping hidden
+// files and directories.
+func findFiles(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		err := filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			hidden := path != arg && strings.HasPrefix(info.Name(), ".")
+			switch {
+			case info.IsDir() && hidden:
+				return filepath.SkipDir
+			case info.Mode().IsRegular() && !hidden:
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("There are no files to upload in %s", strings.Join(args, ", "))
+	}
+	return paths, nil
+}
+
+// uploadFiles uploads each file in turn, or finds the earlier upload of it when resuming.
+func uploadFiles(ctx context.Context, paths []string, o options, stderr io.Writer) handlers.FileResults {
+	results := handlers.FileResults{Results: make([]handlers.FileResult, 0, len(paths))}
+	for _, path := range paths {
+		result := handlers.FileResult{Filename: filepath.Base(path)}
+		if ctx.Err() != nil {
+			result.Error = &handlers.Response{Code: metrics.OutcomeCancelled, Message: "The upload was interrupted."}
+			results.Results = append(results.Results, result)
+			continue
+		}
+
+		if o.resume {
+			earlier, err := findEarlierUpload(ctx, o.client, path)
+			if err != nil {
+				result.Status, result.Error = errorResponse(err)
+				results.Results = append(results.Results, result)
+				continue
+			}
+			if earlier != nil {
+				result.Status, result.Upload = http.StatusOK, earlier
+				results.Results = append(results.Results, result)
+				continue
+			}
+		}
+
+		upload := o.upload
+		upload.Compress = upload.Compress && !alreadyCompressed(path)
+		var bar *progressBar
+		if o.progress {
+			bar = newProgressBar(stderr, path)
+			upload.Progress = bar.update
+		}
+		status, err := o.client.Upload(ctx, path, upload)
+		bar.done()
+		if err != nil {
+			result.Status, result.Error = errorResponse(err)
+		} else {
+			result.Status, result.Upload = http.StatusAccepted, &status
+		}
+		results.Results = append(results.Results, result)
+	}
+	return results
+}
+
+// findEarlierUpload returns the caller's upload of a file with the same name and content that hasn't
+// failed, if there is one.
+func findEarlierUpload(ctx context.Context, c *client.Client, path string) (*handlers.UploadStatus, error) {
+	sha, err := client.HashFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return c.Find(ctx, filepath.Base(path), sha)
+}
+
+// waitForUploads waits for every queued upload to finish, updating its result with how it ended.
+func waitForUploads(ctx context.Context, results handlers.FileResults, o options) {
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+
+	for i := range results.Results {
+		result := &results.Results[i]
+		if result.Upload == nil || result.Upload.Finished() {
+			continue
+		}
+		status, err := o.client.Wait(ctx, result.Upload.ID)
+		if err != nil {
+			_, result.Error = errorResponse(err)
+			continue
+		}
+		result.Upload = &status
+	}
+}
+
+// errorResponse returns the HTTP status, if there was one, and the error response saying why a file
+// couldn't be uploaded or followed.
+func errorResponse(err error) (int, *handlers.Response) {
+	if e, ok := err.(*client.Error); ok {
+		return e.Status, &e.Response
+	}
+	code := codeClientError
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		code = metrics.OutcomeCancelled
+	}
+	return 0, &handlers.Response{Code: code, Message: err.Error()}
+}
+
+// describe returns a line of text saying how the upload of a file ended.
+func describe(result handlers.FileResult) string {
+	switch {
+	case result.Error != nil:
+		return fmt.Sprintf("%s: not uploaded: %s (%s)", result.Filename, result.Error.Message, result.Error.Code)
+	case result.Upload.Error != nil:
+		return fmt.Sprintf("%s: %s: %s (%s)", result.Filename, result.Upload.Status, result.Upload.Error.Message, result.Upload.Error.Code)
+	case len(result.Upload.S3URL) > 0:
+		return fmt.Sprintf("%s: %s %s", result.Filename, result.Upload.Status, result.Upload.S3URL)
+	}
+	return fmt.Sprintf("%s: %s %s", result.Filename, result.Upload.Status, result.Upload.ID)
+}
+
+// exitCode returns the exit code for the results: rejected if any file's content or metadata was
+// invalid, otherwise failed if any file wasn't uploaded or, when waiting, didn't complete.
+func exitCode(results handlers.FileResults, waited bool) int {
+	code := exitCompleted
+	for _, result := range results.Results {
+		switch {
+		case result.Error != nil && result.Error.Code == metrics.OutcomeInvalidMetadata,
+			result.Error == nil && result.Upload.Status == handlers.StatusRejected:
+			return exitRejected
+		case result.Error != nil, waited && result.Upload.Status != handlers.StatusCompleted:
+			code = exitFailed
+		}
+	}
+	return code
+}
+
+// alreadyCompressed returns whether a file is already compressed, so isn't worth compressing again.
+func alreadyCompressed(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".zip", ".gz", ".bz2", ".xz":
+		return true
+	}
+	return false
+}
+
+func envOr(key string, fallback string) string {
+	if value := os.Getenv(key); len(value) > 0 {
+		return value
+	}
+	return fallback
+}
+
+// isTerminal returns whether the file is a terminal, rather than a pipe or file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	barWidth = 30
+	// redrawInterval limits how often a bar is redrawn, as it is updated for every read of the file.
+	redrawInterval = 100 * time.Millisecond
+)
+
+// progressBar shows how much of a file has been sent on a single terminal line. A nil bar shows nothing.
+type progressBar struct {
+	out   io.Writer
+	name  string
+	size  int64
+	sent  int64
+	drawn time.Time
+}
+
+func newProgressBar(out io.Writer, path string) *progressBar {
+	bar := &progressBar{out: out, name: filepath.Base(path)}
+	if info, err := os.Stat(path); err == nil {
+		bar.size = info.Size()
+	}
+	bar.draw()
+	return bar
+}
+
+func (b *progressBar) update(sent int64) {
+	b.sent = sent
+	if time.Since(b.drawn) >= redrawInterval {
+		b.draw()
+	}
+}
+
+// done draws the bar a final time and moves on to the next line.
+func (b *progressBar) done() {
+	if b == nil {
+		return
+	}
+	b.draw()
+	fmt.Fprintln(b.out)
+}
+
+func (b *progressBar) draw() {
+	b.drawn = time.Now()
+	if b.size <= 0 {
+		fmt.Fprintf(b.out, "\r%-30.30s %s", b.name, formatSize(b.sent))
+		return
+	}
+
+	sent := b.sent
+	if sent > b.size {
+		sent = b.size
+	}
+	filled := int(sent * barWidth / b.size)
+	fmt.Fprintf(b.out, "\r%-30.30s [%s%s] %3d%% %s/%s", b.name,
+		strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled),
+		sent*100/b.size, formatSize(sent), formatSize(b.size))
+}
+
+// formatSize returns a number of bytes with a KB, MB or GB suffix.
+func formatSize(bytes int64) string {
+	switch {
+	case bytes >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(bytes)/(1<<30))
+	case bytes >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(bytes)/(1<<20))
+	case bytes >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(bytes)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", bytes)
+}
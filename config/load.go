@@ -0,0 +1,272 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/size"
+	"github.com/ONSdigital/go-ns/log"
+	"gopkg.in/yaml.v2"
+)
+
+// redacted replaces secret values when the configuration is printed or logged.
+const redacted = "REDACTED"
+
+// Errors is every problem found when loading or validating a configuration.
+type Errors []error
+
+func (errs Errors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return "Invalid configuration: " + strings.Join(messages, "; ")
+}
+
+func settingError(key string, format string, args ...interface{}) error {
+	return fmt.Errorf("%s %s", key, fmt.Sprintf(format, args...))
+}
+
+// setting reads and writes one field of the configuration as a string.
+type setting struct {
+	key    string
+	secret bool
+	parse  func(c *Config, value string) error
+	format func(c *Config) string
+}
+
+func stringSetting(key string, field func(c *Config) *string) setting {
+	return setting{
+		key:    key,
+		parse:  func(c *Config, value string) error { *field(c) = value; return nil },
+		format: func(c *Config) string { return *field(c) },
+	}
+}
+
+// secretSetting is a string that is redacted when it is shown, such as a password or key.
+func secretSetting(key string, field func(c *Config) *string) setting {
+	s := stringSetting(key, field)
+	s.secret = true
+	return s
+}
+
+// urlStringSetting is a URL kept as a string, whose password is redacted when it is shown.
+func urlStringSetting(key string, field func(c *Config) *string) setting {
+	s := stringSetting(key, field)
+	s.format = func(c *Config) string {
+		if u, err := url.Parse(*field(c)); err == nil {
+			return redactURL(u)
+		}
+		return *field(c)
+	}
+	return s
+}
+
+func urlSetting(key string, field func(c *Config) **url.URL) setting {
+	return setting{
+		key: key,
+		parse: func(c *Config, value string) error {
+			u, err := url.Parse(value)
+			if err != nil {
+				return err
+			}
+			*field(c) = u
+			return nil
+		},
+		format: func(c *Config) string { return redactURL(*field(c)) },
+	}
+}
+
+func durationSetting(key string, field func(c *Config) *time.Duration) setting {
+	return setting{
+		key: key,
+		parse: func(c *Config, value string) error {
+			d, err := time.ParseDuration(value)
+			if err == nil {
+				*field(c) = d
+			}
+			return err
+		},
+		format: func(c *Config) string { return field(c).String() },
+	}
+}
+
+func intSetting(key string, field func(c *Config) *int) setting {
+	return setting{
+		key: key,
+		parse: func(c *Config, value string) error {
+			i, err := strconv.Atoi(value)
+			if err == nil {
+				*field(c) = i
+			}
+			return err
+		},
+		format: func(c *Config) string { return strconv.Itoa(*field(c)) },
+	}
+}
+
+func sizeSetting(key string, field func(c *Config) *int64) setting {
+	return setting{
+		key: key,
+		parse: func(c *Config, value string) error {
+			parsed, err := size.Parse(value)
+			if err == nil {
+				*field(c) = parsed
+			}
+			return err
+		},
+		format: func(c *Config) string { return strconv.FormatInt(*field(c), 10) },
+	}
+}
+
+// redactURL formats a URL with any password replaced.
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		copied := *u
+		copied.User = url.UserPassword(u.User.Username(), redacted)
+		return copied.String()
+	}
+	return u.String()
+}
+
+// Load reads the configuration from the optional YAML or JSON file, or the file named by
+// CONFIG_FILE, then from environment variables, which take precedence over the file. Every
+// problem found is returned together as Errors, along with the configuration as far as it
+// could be read.
+func Load(file string) (*Config, error) {
+	cfg := Default()
+	var errs Errors
+
+	if len(file) == 0 {
+		file = os.Getenv(ConfigFileKey)
+	}
+	if len(file) > 0 {
+		values, err := readFile(file)
+		if err != nil {
+			return cfg, Errors{err}
+		}
+		errs = append(errs, cfg.apply(values, file)...)
+	}
+
+	env := make(map[string]string)
+	for _, s := range settings {
+		if value := os.Getenv(s.key); len(value) > 0 {
+			env[s.key] = value
+		}
+	}
+	errs = append(errs, cfg.apply(env, "environment")...)
+
+	if err := cfg.Validate(); err != nil {
+		errs = append(errs, err.(Errors)...)
+	}
+
+	if len(errs) > 0 {
+		return cfg, errs
+	}
+	return cfg, nil
+}
+
+// apply sets each of the given values, returning an error for every value that can't be parsed.
+func (c *Config) apply(values map[string]string, source string) []error {
+	var errs []error
+	known := make(map[string]bool)
+
+	for _, s := range settings {
+		known[s.key] = true
+		value, ok := values[s.key]
+		if !ok {
+			continue
+		}
+		if err := s.parse(c, strings.TrimSpace(value)); err != nil {
+			errs = append(errs, settingError(s.key, "from %s is invalid: %v", source, err))
+		}
+	}
+
+	for key := range values {
+		if !known[key] {
+			errs = append(errs, settingError(key, "in %s is not a known setting", source))
+		}
+	}
+
+	return errs
+}
+
+// readFile reads a flat YAML or JSON object of settings, keyed by their environment variable names.
+func readFile(file string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(b))
+		decoder.UseNumber()
+		err = decoder.Decode(&raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &raw)
+	default:
+		return nil, fmt.Errorf("Config file %s must have a .yaml, .yml or .json extension", file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read config file %s: %v", file, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch value.(type) {
+		case map[interface{}]interface{}, map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("Config file %s: %s must be a single value", file, key)
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(value)
+		}
+	}
+	return values, nil
+}
+
+// Values returns every setting keyed by its environment variable name, with secrets redacted.
+func (c *Config) Values() map[string]string {
+	values := make(map[string]string, len(settings))
+	for _, s := range settings {
+		value := s.format(c)
+		if s.secret && len(value) > 0 {
+			value = redacted
+		}
+		values[s.key] = value
+	}
+	return values
+}
+
+// Print writes the effective configuration as JSON, with secrets redacted.
+func (c *Config) Print(w io.Writer) error {
+	b, err := json.MarshalIndent(c.Values(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// Log logs the effective configuration, with secrets redacted.
+func (c *Config) Log() {
+	data := log.Data{}
+	for key, value := range c.Values() {
+		data[key] = value
+	}
+	log.Debug("dp-dd-file-uploader Configuration", data)
+}
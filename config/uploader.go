@@ -1,99 +1,442 @@
 package config
 
 import (
-	"fmt"
-	"github.com/ONSdigital/go-ns/log"
 	"net/url"
 	"os"
+	"path/filepath"
 	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/auth"
+)
+
+// The environment variables, and keys in a config file, for each setting.
+const (
+	bindAddrKey           = "BIND_ADDR"
+	kafkaAddrKey          = "KAFKA_ADDR"
+	awsRegionKey          = "AWS_REGION"
+	topicNameKey          = "TOPIC_NAME"
+	timeoutKey            = "UPLOAD_TIMEOUT"
+	s3URLKey              = "S3_URL"
+	uploadTempDirKey      = "UPLOAD_TEMP_DIR"
+	eventEncodingKey      = "EVENT_ENCODING"
+	eventSourceKey        = "EVENT_SOURCE"
+	routingFileKey        = "ROUTING_FILE"
+	shutdownTimeoutKey    = "SHUTDOWN_TIMEOUT"
+	workerPoolSizeKey     = "WORKER_POOL_SIZE"
+	workerQueueSizeKey    = "WORKER_QUEUE_SIZE"
+	recoveryPolicyKey     = "RECOVERY_POLICY"
+	recoveryMaxAgeKey     = "RECOVERY_MAX_AGE"
+	maxUploadSizeKey      = "MAX_UPLOAD_SIZE"
+	minFreeSpaceKey       = "MIN_FREE_SPACE"
+	healthCheckTimeoutKey = "HEALTH_CHECK_TIMEOUT"
+	healthCacheTTLKey     = "HEALTH_CACHE_TTL"
+	tracingExporterKey    = "TRACING_EXPORTER"
+	otlpEndpointKey       = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	serviceNameKey        = "OTEL_SERVICE_NAME"
+	staticUsersKey        = "AUTH_STATIC_USERS"
+	apiKeysKey            = "AUTH_API_KEYS"
+	jwtIssuerKey          = "AUTH_JWT_ISSUER"
+	jwtAudienceKey        = "AUTH_JWT_AUDIENCE"
+	jwtJWKSURLKey         = "AUTH_JWT_JWKS_URL"
+	jwtSecretKey          = "AUTH_JWT_SECRET"
+	groupsClaimKey        = "AUTH_GROUPS_CLAIM"
+	oidcIssuerURLKey      = "OIDC_ISSUER_URL"
+	oidcClientIDKey       = "OIDC_CLIENT_ID"
+	oidcClientSecretKey   = "OIDC_CLIENT_SECRET"
+	oidcRedirectURLKey    = "OIDC_REDIRECT_URL"
+	sessionKeyKey         = "SESSION_KEY"
+	sessionTTLKey         = "SESSION_TTL"
+	policyFileKey         = "POLICY_FILE"
+	auditLogKey           = "AUDIT_LOG"
+	auditReadersKey       = "AUDIT_READERS"
+	auditKeyKey           = "AUDIT_KEY"
+	clamdAddressKey       = "CLAMD_ADDRESS"
+	scanTimeoutKey        = "SCAN_TIMEOUT"
+	scanFailureModeKey    = "SCAN_FAILURE_MODE"
+	quarantineDirKey      = "QUARANTINE_DIR"
+	rejectedTopicKey      = "REJECTED_TOPIC_NAME"
+	maxUncompressedKey    = "MAX_UNCOMPRESSED_SIZE"
+	maxRatioKey           = "MAX_COMPRESSION_RATIO"
+	userConcurrentKey     = "USER_MAX_CONCURRENT_UPLOADS"
+	userUploadsKey        = "USER_MAX_UPLOADS_PER_HOUR"
+	userBytesKey          = "USER_MAX_BYTES_PER_DAY"
+	ipConcurrentKey       = "IP_MAX_CONCURRENT_UPLOADS"
+	ipUploadsKey          = "IP_MAX_UPLOADS_PER_HOUR"
+	ipBytesKey            = "IP_MAX_BYTES_PER_DAY"
+	metadataSchemaKey     = "METADATA_SCHEMA_FILE"
+	metadataStorageKey    = "METADATA_STORAGE"
 )
 
-const bindAddrKey = "BIND_ADDR"
-const kafkaAddrKey = "KAFKA_ADDR"
-const awsRegionKey = "AWS_REGION"
-const topicNameKey = "TOPIC_NAME"
-const timeoutKey = "UPLOAD_TIMEOUT"
-const s3URLKey = "S3_URL"
-const uploadTempDirKey = "UPLOAD_TEMP_DIR"
+// ConfigFileKey is the environment variable naming a config file, if one isn't given on the command line.
+const ConfigFileKey = "CONFIG_FILE"
 
 const maxUploadTimeout = 1 * time.Hour
 
-// BindAddr the address to bind to.
-var BindAddr = ":20019"
+// Config is the configuration of the service.
+type Config struct {
+	// BindAddr the address to bind to.
+	BindAddr string
 
-// KafkaAddr the Kafka address to send messages to.
-var KafkaAddr = "localhost:9092"
+	// KafkaAddr the Kafka address to send messages to.
+	KafkaAddr string
 
-// AWSRegion the AWS region to use.
-var AWSRegion = "eu-west-1"
+	// AWSRegion the AWS region to use.
+	AWSRegion string
 
-// TopicName the name of the Kafka topic to send messages to.
-var TopicName = "file-uploaded"
+	// TopicName the name of the Kafka topic to send messages to.
+	TopicName string
 
-// UploadTimeout is the time to allow for an upload to complete. As per
-// https://blog.cloudflare.com/the-complete-guide-to-golang-net-http-timeouts/ this will
-// be used to set the ReadTimeout, WriteTimeout and go-ns timeout.Handler timeout as the
-// upload will encompass all three.
-var UploadTimeout = 10 * time.Minute
+	// UploadTimeout is the time to allow for an upload to complete. As per
+	// https://blog.cloudflare.com/the-complete-guide-to-golang-net-http-timeouts/ this will
+	// be used to set the ReadTimeout, WriteTimeout and go-ns timeout.Handler timeout as the
+	// upload will encompass all three.
+	UploadTimeout time.Duration
 
-// Default S3 URL value.
-var S3URL, _ = url.Parse("s3://dp-csv-splitter-develop/" + os.Getenv("USER"))
+	// S3URL is the bucket and path files are stored under.
+	S3URL *url.URL
 
-// UploadTempDir is the directory to store uploaded files in temporarily before uploading to S3.
-var UploadTempDir = os.TempDir()
+	// UploadTempDir is the directory to store uploaded files in temporarily before uploading to S3.
+	UploadTempDir string
 
-func init() {
-	if bindAddrEnv := os.Getenv(bindAddrKey); len(bindAddrEnv) > 0 {
-		BindAddr = bindAddrEnv
-	}
+	// EventEncoding is how events are encoded onto Kafka messages: plain, cloudevents-structured or cloudevents-binary.
+	EventEncoding string
 
-	if kafkaAddrEnv := os.Getenv(kafkaAddrKey); len(kafkaAddrEnv) > 0 {
-		KafkaAddr = kafkaAddrEnv
-	}
+	// EventSource is the CloudEvents source attribute of events sent by this service.
+	EventSource string
 
-	if topicNameEnv := os.Getenv(topicNameKey); len(topicNameEnv) > 0 {
-		TopicName = topicNameEnv
-	}
+	// RoutingFile is an optional JSON file of rules routing uploads to topics and S3 prefixes.
+	RoutingFile string
+
+	// ShutdownTimeout is the time to allow in-flight requests and background uploads to complete on shutdown.
+	ShutdownTimeout time.Duration
+
+	// WorkerPoolSize is the number of uploads that can be processed and sent to S3 at the same time.
+	WorkerPoolSize int
+
+	// WorkerQueueSize is the number of received uploads that can wait for a worker before uploads are rejected.
+	WorkerQueueSize int
+
+	// RecoveryPolicy is what to do on startup with uploads left in UploadTempDir by a previous run: resume, delete or keep.
+	RecoveryPolicy string
+
+	// RecoveryMaxAge is the age beyond which uploads left by a previous run are deleted rather than resumed.
+	RecoveryMaxAge time.Duration
+
+	// MaxUploadSize is the largest file in bytes that can be uploaded. Zero means no limit.
+	MaxUploadSize int64
+
+	// MinFreeSpace is the free space in bytes that must remain in UploadTempDir for an upload to be accepted.
+	MinFreeSpace int64
+
+	// HealthCheckTimeout is the time allowed for each readiness check to complete.
+	HealthCheckTimeout time.Duration
+
+	// HealthCacheTTL is how long the result of a readiness check is reused before it is run again.
+	HealthCacheTTL time.Duration
+
+	// TracingExporter is where trace spans are sent: none, stdout or otlp.
+	TracingExporter string
+
+	// OTLPEndpoint is the OpenTelemetry collector spans are sent to over OTLP/HTTP, when using the otlp exporter.
+	OTLPEndpoint string
+
+	// ServiceName is the name of the service reported with trace spans.
+	ServiceName string
+
+	// StaticUsers are users who log in with HTTP basic auth, for local development: name:password[:group|group],...
+	StaticUsers string
+
+	// APIKeys are the keys scripted clients can authenticate with: name:key[:group|group],...
+	APIKeys string
+
+	// JWTIssuer is the issuer of the bearer JWTs scripted clients can authenticate with.
+	JWTIssuer string
+
+	// JWTAudience is the audience bearer JWTs must be issued for.
+	JWTAudience string
+
+	// JWTJWKSURL is where the keys bearer JWTs are signed with are published. If it isn't set
+	// they are discovered from JWTIssuer.
+	JWTJWKSURL string
+
+	// JWTSecret is the shared secret for bearer JWTs signed with HS256.
+	JWTSecret string
+
+	// GroupsClaim is the JWT claim listing the groups a user belongs to.
+	GroupsClaim string
+
+	// OIDCIssuerURL is the OpenID Connect provider browsers log in with.
+	OIDCIssuerURL string
+
+	// OIDCClientID is the client ID registered with the OpenID Connect provider.
+	OIDCClientID string
+
+	// OIDCClientSecret is the client secret registered with the OpenID Connect provider.
+	OIDCClientSecret string
+
+	// OIDCRedirectURL is the URL of this service's /auth/callback, as registered with the provider.
+	OIDCRedirectURL string
+
+	// SessionKey signs session cookies. If it isn't set a random key is used, so sessions end on restart.
+	SessionKey string
+
+	// SessionTTL is how long a browser stays logged in.
+	SessionTTL time.Duration
+
+	// PolicyFile is an optional JSON file of rules saying which users and groups can upload where.
+	PolicyFile string
+
+	// AuditLog is the file every upload attempt is recorded in. If it isn't set, uploads are not audited.
+	AuditLog string
+
+	// AuditReaders is a comma separated list of the users and groups that can query the audit log.
+	AuditReaders string
+
+	// AuditKey signs the chain of audit records, so it can't be rewritten by someone without it.
+	AuditKey string
 
-	if awsRegionEnv := os.Getenv(awsRegionKey); len(awsRegionEnv) > 0 {
-		AWSRegion = awsRegionEnv
+	// ClamdAddress is the clamd daemon files are scanned with, tcp://host:port or unix:///path. If it
+	// isn't set, files are not scanned.
+	ClamdAddress string
+
+	// ScanTimeout is the time allowed to scan each file.
+	ScanTimeout time.Duration
+
+	// ScanFailureMode is what happens to files that can't be scanned: closed rejects them, open stores them.
+	ScanFailureMode string
+
+	// QuarantineDir is where infected files are moved to.
+	QuarantineDir string
+
+	// RejectedTopic is the Kafka topic file rejected events are sent to.
+	RejectedTopic string
+
+	// MaxUncompressedSize is the largest a file in a zip archive can expand to, in bytes. Zero means no limit.
+	MaxUncompressedSize int64
+
+	// MaxCompressionRatio is the most a file in a zip archive can expand by. Zero means no limit.
+	MaxCompressionRatio int
+
+	// UserMaxConcurrentUploads is the number of uploads each user can make at the same time. Zero means no limit.
+	UserMaxConcurrentUploads int
+
+	// UserMaxUploadsPerHour is the number of uploads each user can start each hour. Zero means no limit.
+	UserMaxUploadsPerHour int
+
+	// UserMaxBytesPerDay is the number of bytes each user can upload each day. Zero means no limit.
+	UserMaxBytesPerDay int64
+
+	// IPMaxConcurrentUploads is the number of uploads each client IP can make at the same time. Zero means no limit.
+	// The client IP is the address connecting to the service, which behind a load balancer is the same for every
+	// client, so the IP limits are off by default.
+	IPMaxConcurrentUploads int
+
+	// IPMaxUploadsPerHour is the number of uploads each client IP can start each hour. Zero means no limit.
+	IPMaxUploadsPerHour int
+
+	// IPMaxBytesPerDay is the number of bytes each client IP can upload each day. Zero means no limit.
+	IPMaxBytesPerDay int64
+
+	// MetadataSchemaFile is an optional JSON file of the rules dataset metadata must follow. If it isn't set,
+	// the built in rules apply.
+	MetadataSchemaFile string
+
+	// MetadataStorage is where dataset metadata is stored: object as S3 object metadata, sidecar as a JSON file
+	// alongside the upload.
+	MetadataStorage string
+}
+
+// Default returns the configuration used for any setting that isn't set in a file or the environment.
+func Default() *Config {
+	s3URL, _ := url.Parse("s3://dp-csv-splitter-develop/" + os.Getenv("USER"))
+
+	return &Config{
+		BindAddr:                 ":20019",
+		KafkaAddr:                "localhost:9092",
+		AWSRegion:                "eu-west-1",
+		TopicName:                "file-uploaded",
+		UploadTimeout:            10 * time.Minute,
+		S3URL:                    s3URL,
+		UploadTempDir:            os.TempDir(),
+		EventEncoding:            "plain",
+		EventSource:              "dp-dd-file-uploader",
+		ShutdownTimeout:          30 * time.Second,
+		WorkerPoolSize:           4,
+		WorkerQueueSize:          16,
+		RecoveryPolicy:           "resume",
+		RecoveryMaxAge:           24 * time.Hour,
+		MaxUploadSize:            2 << 30,
+		MinFreeSpace:             1 << 30,
+		HealthCheckTimeout:       5 * time.Second,
+		HealthCacheTTL:           10 * time.Second,
+		TracingExporter:          "none",
+		OTLPEndpoint:             "http://localhost:4318",
+		ServiceName:              "dp-dd-file-uploader",
+		GroupsClaim:              "groups",
+		SessionTTL:               8 * time.Hour,
+		ScanTimeout:              2 * time.Minute,
+		ScanFailureMode:          "closed",
+		QuarantineDir:            filepath.Join(os.TempDir(), "dp-dd-file-uploader-quarantine"),
+		RejectedTopic:            "file-rejected",
+		MaxUncompressedSize:      10 << 30,
+		MaxCompressionRatio:      200,
+		UserMaxConcurrentUploads: 4,
+		UserMaxUploadsPerHour:    100,
+		UserMaxBytesPerDay:       20 << 30,
+		MetadataStorage:          "object",
 	}
+}
+
+// settings lists every setting in the order they are documented.
+var settings = []setting{
+	stringSetting(bindAddrKey, func(c *Config) *string { return &c.BindAddr }),
+	stringSetting(kafkaAddrKey, func(c *Config) *string { return &c.KafkaAddr }),
+	stringSetting(topicNameKey, func(c *Config) *string { return &c.TopicName }),
+	stringSetting(awsRegionKey, func(c *Config) *string { return &c.AWSRegion }),
+	urlSetting(s3URLKey, func(c *Config) **url.URL { return &c.S3URL }),
+	durationSetting(timeoutKey, func(c *Config) *time.Duration { return &c.UploadTimeout }),
+	stringSetting(eventEncodingKey, func(c *Config) *string { return &c.EventEncoding }),
+	stringSetting(eventSourceKey, func(c *Config) *string { return &c.EventSource }),
+	stringSetting(routingFileKey, func(c *Config) *string { return &c.RoutingFile }),
+	durationSetting(shutdownTimeoutKey, func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+	intSetting(workerPoolSizeKey, func(c *Config) *int { return &c.WorkerPoolSize }),
+	intSetting(workerQueueSizeKey, func(c *Config) *int { return &c.WorkerQueueSize }),
+	stringSetting(uploadTempDirKey, func(c *Config) *string { return &c.UploadTempDir }),
+	stringSetting(recoveryPolicyKey, func(c *Config) *string { return &c.RecoveryPolicy }),
+	durationSetting(recoveryMaxAgeKey, func(c *Config) *time.Duration { return &c.RecoveryMaxAge }),
+	sizeSetting(maxUploadSizeKey, func(c *Config) *int64 { return &c.MaxUploadSize }),
+	sizeSetting(minFreeSpaceKey, func(c *Config) *int64 { return &c.MinFreeSpace }),
+	durationSetting(healthCheckTimeoutKey, func(c *Config) *time.Duration { return &c.HealthCheckTimeout }),
+	durationSetting(healthCacheTTLKey, func(c *Config) *time.Duration { return &c.HealthCacheTTL }),
+	stringSetting(tracingExporterKey, func(c *Config) *string { return &c.TracingExporter }),
+	urlStringSetting(otlpEndpointKey, func(c *Config) *string { return &c.OTLPEndpoint }),
+	stringSetting(serviceNameKey, func(c *Config) *string { return &c.ServiceName }),
+	secretSetting(staticUsersKey, func(c *Config) *string { return &c.StaticUsers }),
+	secretSetting(apiKeysKey, func(c *Config) *string { return &c.APIKeys }),
+	stringSetting(jwtIssuerKey, func(c *Config) *string { return &c.JWTIssuer }),
+	stringSetting(jwtAudienceKey, func(c *Config) *string { return &c.JWTAudience }),
+	stringSetting(jwtJWKSURLKey, func(c *Config) *string { return &c.JWTJWKSURL }),
+	secretSetting(jwtSecretKey, func(c *Config) *string { return &c.JWTSecret }),
+	stringSetting(groupsClaimKey, func(c *Config) *string { return &c.GroupsClaim }),
+	stringSetting(oidcIssuerURLKey, func(c *Config) *string { return &c.OIDCIssuerURL }),
+	stringSetting(oidcClientIDKey, func(c *Config) *string { return &c.OIDCClientID }),
+	secretSetting(oidcClientSecretKey, func(c *Config) *string { return &c.OIDCClientSecret }),
+	stringSetting(oidcRedirectURLKey, func(c *Config) *string { return &c.OIDCRedirectURL }),
+	secretSetting(sessionKeyKey, func(c *Config) *string { return &c.SessionKey }),
+	durationSetting(sessionTTLKey, func(c *Config) *time.Duration { return &c.SessionTTL }),
+	stringSetting(policyFileKey, func(c *Config) *string { return &c.PolicyFile }),
+	stringSetting(auditLogKey, func(c *Config) *string { return &c.AuditLog }),
+	stringSetting(auditReadersKey, func(c *Config) *string { return &c.AuditReaders }),
+	secretSetting(auditKeyKey, func(c *Config) *string { return &c.AuditKey }),
+	stringSetting(clamdAddressKey, func(c *Config) *string { return &c.ClamdAddress }),
+	durationSetting(scanTimeoutKey, func(c *Config) *time.Duration { return &c.ScanTimeout }),
+	stringSetting(scanFailureModeKey, func(c *Config) *string { return &c.ScanFailureMode }),
+	stringSetting(quarantineDirKey, func(c *Config) *string { return &c.QuarantineDir }),
+	stringSetting(rejectedTopicKey, func(c *Config) *string { return &c.RejectedTopic }),
+	sizeSetting(maxUncompressedKey, func(c *Config) *int64 { return &c.MaxUncompressedSize }),
+	intSetting(maxRatioKey, func(c *Config) *int { return &c.MaxCompressionRatio }),
+	intSetting(userConcurrentKey, func(c *Config) *int { return &c.UserMaxConcurrentUploads }),
+	intSetting(userUploadsKey, func(c *Config) *int { return &c.UserMaxUploadsPerHour }),
+	sizeSetting(userBytesKey, func(c *Config) *int64 { return &c.UserMaxBytesPerDay }),
+	intSetting(ipConcurrentKey, func(c *Config) *int { return &c.IPMaxConcurrentUploads }),
+	intSetting(ipUploadsKey, func(c *Config) *int { return &c.IPMaxUploadsPerHour }),
+	sizeSetting(ipBytesKey, func(c *Config) *int64 { return &c.IPMaxBytesPerDay }),
+	stringSetting(metadataSchemaKey, func(c *Config) *string { return &c.MetadataSchemaFile }),
+	stringSetting(metadataStorageKey, func(c *Config) *string { return &c.MetadataStorage }),
+}
 
-	if s3URLEnv := os.Getenv(s3URLKey); len(s3URLEnv) > 0 {
-		var err error
-		if S3URL, err = url.Parse(s3URLEnv); err != nil {
-			log.Error(err, log.Data{"Failed to parse S3URL env var, will use default.": S3URL})
+// Validate checks every setting, returning all of the problems found as Errors.
+func (c *Config) Validate() error {
+	var errs Errors
+	check := func(ok bool, key string, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, settingError(key, format, args...))
 		}
 	}
 
-	if timeoutEnv := os.Getenv(timeoutKey); len(timeoutEnv) > 0 {
-		var err error
-		UploadTimeout, err = time.ParseDuration(timeoutEnv)
-		if err == nil && UploadTimeout > maxUploadTimeout {
-			err = fmt.Errorf("Upload timeout too large: %v max allowed: %v", UploadTimeout, maxUploadTimeout)
-		}
-		if err != nil {
-			log.Error(err, log.Data{
-				"timeout": timeoutEnv,
-			})
-			os.Exit(1)
-		}
+	check(len(c.BindAddr) > 0, bindAddrKey, "must be set")
+	check(len(c.KafkaAddr) > 0, kafkaAddrKey, "must be set")
+	check(len(c.TopicName) > 0, topicNameKey, "must be set")
+	check(len(c.AWSRegion) > 0, awsRegionKey, "must be set")
+	check(c.S3URL != nil && c.S3URL.Scheme == "s3" && len(c.S3URL.Host) > 0, s3URLKey, "must be an s3:// URL including the bucket name")
+	check(c.UploadTimeout > 0 && c.UploadTimeout <= maxUploadTimeout, timeoutKey, "must be more than 0 and at most %v", maxUploadTimeout)
+	check(oneOf(c.EventEncoding, "plain", "cloudevents-structured", "cloudevents-binary"), eventEncodingKey, "must be plain, cloudevents-structured or cloudevents-binary")
+	check(c.ShutdownTimeout >= 0, shutdownTimeoutKey, "must not be negative")
+	check(c.WorkerPoolSize >= 1, workerPoolSizeKey, "must be at least 1")
+	check(c.WorkerQueueSize >= 0, workerQueueSizeKey, "must not be negative")
+	check(len(c.UploadTempDir) > 0, uploadTempDirKey, "must be set")
+	check(oneOf(c.RecoveryPolicy, "resume", "delete", "keep"), recoveryPolicyKey, "must be resume, delete or keep")
+	check(c.RecoveryMaxAge > 0, recoveryMaxAgeKey, "must be more than 0")
+	check(c.MaxUploadSize >= 0, maxUploadSizeKey, "must not be negative")
+	check(c.MinFreeSpace >= 0, minFreeSpaceKey, "must not be negative")
+	check(c.HealthCheckTimeout > 0, healthCheckTimeoutKey, "must be more than 0")
+	check(c.HealthCacheTTL >= 0, healthCacheTTLKey, "must not be negative")
+	check(oneOf(c.TracingExporter, "none", "stdout", "otlp"), tracingExporterKey, "must be none, stdout or otlp")
+	if c.TracingExporter == "otlp" {
+		check(isHTTPURL(c.OTLPEndpoint), otlpEndpointKey, "must be an http:// or https:// URL")
 	}
 
-	if uploadDir := os.Getenv(uploadTempDirKey); len(uploadDir) > 0 {
-		UploadTempDir = uploadDir
+	_, err := auth.ParseCredentials(c.StaticUsers)
+	check(err == nil, staticUsersKey, "is invalid: %v", err)
+	_, err = auth.ParseCredentials(c.APIKeys)
+	check(err == nil, apiKeysKey, "is invalid: %v", err)
+	if len(c.JWTJWKSURL) > 0 {
+		check(isHTTPURL(c.JWTJWKSURL), jwtJWKSURLKey, "must be an http:// or https:// URL")
+	}
+	if len(c.JWTAudience) > 0 || len(c.JWTJWKSURL) > 0 {
+		check(len(c.JWTIssuer) > 0 || len(c.JWTSecret) > 0, jwtIssuerKey, "must be set to accept bearer JWTs")
+	}
+	// Without an audience, any token the provider issues for another application would be accepted
+	if len(c.JWTIssuer) > 0 || len(c.JWTJWKSURL) > 0 {
+		check(len(c.JWTAudience) > 0, jwtAudienceKey, "must be set to accept bearer JWTs signed by an identity provider")
+	}
+	if len(c.OIDCIssuerURL) > 0 {
+		check(isHTTPURL(c.OIDCIssuerURL), oidcIssuerURLKey, "must be an http:// or https:// URL")
+		check(len(c.OIDCClientID) > 0, oidcClientIDKey, "must be set to log in with OIDC")
+		check(isHTTPURL(c.OIDCRedirectURL), oidcRedirectURLKey, "must be the http:// or https:// URL of /auth/callback")
+	}
+	check(len(c.SessionKey) == 0 || len(c.SessionKey) >= 32, sessionKeyKey, "must be at least 32 characters")
+	check(c.SessionTTL > 0, sessionTTLKey, "must be more than 0")
+	check(len(c.AuditKey) == 0 || len(c.AuditKey) >= 32, auditKeyKey, "must be at least 32 characters")
+	if len(c.ClamdAddress) > 0 {
+		u, err := url.Parse(c.ClamdAddress)
+		check(err == nil && (u.Scheme == "tcp" && len(u.Host) > 0 || u.Scheme == "unix" && len(u.Path) > 0), clamdAddressKey, "must be tcp://host:port or unix:///path")
+		check(c.ScanTimeout > 0, scanTimeoutKey, "must be more than 0")
+		check(len(c.QuarantineDir) > 0, quarantineDirKey, "must be set")
 	}
+	check(len(c.RejectedTopic) > 0, rejectedTopicKey, "must be set")
+	check(oneOf(c.ScanFailureMode, "closed", "open"), scanFailureModeKey, "must be closed or open")
+	check(c.MaxUncompressedSize >= 0, maxUncompressedKey, "must not be negative")
+	check(c.MaxCompressionRatio >= 0, maxRatioKey, "must not be negative")
+	check(c.UserMaxConcurrentUploads >= 0, userConcurrentKey, "must not be negative")
+	check(c.UserMaxUploadsPerHour >= 0, userUploadsKey, "must not be negative")
+	check(c.UserMaxBytesPerDay >= 0, userBytesKey, "must not be negative")
+	check(c.IPMaxConcurrentUploads >= 0, ipConcurrentKey, "must not be negative")
+	check(c.IPMaxUploadsPerHour >= 0, ipUploadsKey, "must not be negative")
+	check(c.IPMaxBytesPerDay >= 0, ipBytesKey, "must not be negative")
+	check(oneOf(c.MetadataStorage, "object", "sidecar"), metadataStorageKey, "must be object or sidecar")
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// AuthEnabled returns whether any way of authenticating is configured. If none is, uploads are open to anyone.
+func (c *Config) AuthEnabled() bool {
+	return len(c.StaticUsers) > 0 || len(c.APIKeys) > 0 || len(c.JWTIssuer) > 0 || len(c.JWTSecret) > 0 || len(c.OIDCIssuerURL) > 0
 }
 
-func Load() {
-	// Will call init().
-	log.Debug("dp-dd-file-uploader Configuration", log.Data{
-		bindAddrKey:      BindAddr,
-		kafkaAddrKey:     KafkaAddr,
-		topicNameKey:     TopicName,
-		awsRegionKey:     AWSRegion,
-		timeoutKey:       UploadTimeout,
-		s3URLKey:         S3URL,
-		uploadTempDirKey: UploadTempDir,
-	})
+func isHTTPURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && oneOf(u.Scheme, "http", "https") && len(u.Host) > 0
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
 }
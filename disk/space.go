@@ -0,0 +1,44 @@
+package disk
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Usage describes the space on the filesystem holding a directory.
+type Usage struct {
+	Dir        string `json:"dir"`
+	FreeBytes  uint64 `json:"freeBytes"`
+	TotalBytes uint64 `json:"totalBytes"`
+}
+
+// FreeSpace returns the number of bytes available to unprivileged users on the filesystem holding dir.
+func FreeSpace(dir string) (uint64, error) {
+	usage, err := GetUsage(dir)
+	if err != nil {
+		return 0, err
+	}
+	return usage.FreeBytes, nil
+}
+
+// CheckDir confirms a file can be written to dir and that it has at least minFree bytes free.
+func CheckDir(dir string, minFree int64) error {
+	f, err := ioutil.TempFile(dir, "healthcheck-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	if err = os.Remove(f.Name()); err != nil {
+		return err
+	}
+
+	free, err := FreeSpace(dir)
+	if err != nil {
+		return err
+	}
+	if minFree > 0 && free < uint64(minFree) {
+		return fmt.Errorf("Only %d bytes free in %s, at least %d required", free, dir, minFree)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package disk_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/disk"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGetUsage(t *testing.T) {
+
+	Convey("Given the OS temp directory", t, func() {
+		dir := os.TempDir()
+
+		Convey("When its usage is read", func() {
+			usage, err := disk.GetUsage(dir)
+
+			Convey("Then the free space is no more than the total space", func() {
+				So(err, ShouldBeNil)
+				So(usage.TotalBytes, ShouldBeGreaterThan, 0)
+				So(usage.FreeBytes, ShouldBeLessThanOrEqualTo, usage.TotalBytes)
+			})
+		})
+	})
+
+	Convey("Given a directory that does not exist", t, func() {
+		Convey("Then an error is returned", func() {
+			_, err := disk.FreeSpace("/does/not/exist")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
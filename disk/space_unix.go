@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package disk
+
+import "syscall"
+
+// GetUsage returns the free and total space on the filesystem holding dir.
+func GetUsage(dir string) (Usage, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return Usage{}, err
+	}
+
+	return Usage{
+		Dir:        dir,
+		FreeBytes:  uint64(stat.Bavail) * uint64(stat.Bsize),
+		TotalBytes: uint64(stat.Blocks) * uint64(stat.Bsize),
+	}, nil
+}
@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package disk
+
+import "errors"
+
+// GetUsage is not supported on Windows.
+func GetUsage(dir string) (Usage, error) {
+	return Usage{}, errors.New("Disk usage is not supported on this platform")
+}
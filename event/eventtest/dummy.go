@@ -12,15 +12,39 @@ func NewDummyEventProducer() *DummyEventProducer {
 
 type DummyEventProducer struct {
 	Invocations int
+	Topics      []string
+	Events      []event.FileUploaded
+	Rejections  []event.FileRejected
 }
 
 func (eventProducer *DummyEventProducer) FileUploaded(event event.FileUploaded) error {
+	return eventProducer.FileUploadedToTopic("", event)
+}
+
+func (eventProducer *DummyEventProducer) FileUploadedToTopic(topic string, event event.FileUploaded) error {
+	_, err := eventProducer.SendFileUploaded(topic, event)
+	return err
+}
+
+// SendFileUploaded records the event, and reports it as written at the next offset of partition 0.
+func (eventProducer *DummyEventProducer) SendFileUploaded(topic string, uploaded event.FileUploaded) (event.Receipt, error) {
 
 	eventProducer.Invocations++
+	eventProducer.Topics = append(eventProducer.Topics, topic)
+	eventProducer.Events = append(eventProducer.Events, uploaded)
 
-	if strings.Contains(event.S3URL, "EventError") {
-		return errors.New("Error sending event")
+	if strings.Contains(uploaded.S3URL, "EventError") {
+		return event.Receipt{}, errors.New("Error sending event")
 	}
 
+	return event.Receipt{Topic: topic, Offset: int64(eventProducer.Invocations - 1)}, nil
+}
+
+// FileRejectedToTopic records the rejection event.
+func (eventProducer *DummyEventProducer) FileRejectedToTopic(topic string, rejected event.FileRejected) error {
+
+	eventProducer.Topics = append(eventProducer.Topics, topic)
+	eventProducer.Rejections = append(eventProducer.Rejections, rejected)
+
 	return nil
 }
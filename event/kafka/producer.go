@@ -1,50 +1,308 @@
 package kafka
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/ONSdigital/dp-dd-file-uploader/event"
+	"github.com/ONSdigital/dp-dd-file-uploader/metrics"
+	"github.com/ONSdigital/dp-dd-file-uploader/tracing"
 	"github.com/Shopify/sarama"
 )
 
-func NewProducer(kafkaAddress string, topicName string) (*Producer, error) {
+// The supported ways of encoding an event onto a Kafka message.
+const (
+	// EncodingPlain sends the event JSON as the message value with no headers.
+	EncodingPlain = "plain"
+	// EncodingStructured sends a CloudEvents 1.0 JSON envelope as the message value.
+	EncodingStructured = "cloudevents-structured"
+	// EncodingBinary sends the event JSON as the message value with the CloudEvents attributes as headers.
+	EncodingBinary = "cloudevents-binary"
+)
+
+// The CloudEvents types of the events sent by the producer.
+const (
+	FileUploadedType = "uk.gov.ons.dp.dd.file-uploaded"
+	FileRejectedType = "uk.gov.ons.dp.dd.file-rejected"
+)
+
+const cloudEventsSpecVersion = "1.0"
+const contentTypeJSON = "application/json"
+const contentTypeCloudEventsJSON = "application/cloudevents+json; charset=UTF-8"
+
+// NewProducer creates a producer connected to the given Kafka address. The encoding must be one of
+// EncodingPlain, EncodingStructured or EncodingBinary, and source is used as the CloudEvents source.
+func NewProducer(kafkaAddress string, topicName string, encoding string, source string) (*Producer, error) {
+	if !IsValidEncoding(encoding) {
+		return nil, fmt.Errorf("Unsupported event encoding: %q", encoding)
+	}
+
 	kafkaConfig := sarama.NewConfig()
 	kafkaConfig.Producer.Retry.Max = 5
 	kafkaConfig.Producer.RequiredAcks = sarama.WaitForAll
 	kafkaConfig.Producer.Return.Successes = true
 	kafkaConfig.Producer.Return.Errors = true
+	if encoding != EncodingPlain {
+		// Record headers were introduced in Kafka 0.11.
+		kafkaConfig.Version = sarama.V0_11_0_0
+	}
 
-	producer, err := sarama.NewSyncProducer([]string{kafkaAddress}, kafkaConfig)
+	// The client is kept so that broker and topic metadata can be checked.
+	client, err := sarama.NewClient([]string{kafkaAddress}, kafkaConfig)
 	if err != nil {
 		return nil, err
 	}
 
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
 	return &Producer{
 		Producer:  producer,
+		Client:    client,
 		TopicName: topicName,
+		Encoding:  encoding,
+		Source:    source,
 	}, nil
 }
 
+// IsValidEncoding returns true if the given encoding is supported by the producer.
+func IsValidEncoding(encoding string) bool {
+	switch encoding {
+	case EncodingPlain, EncodingStructured, EncodingBinary:
+		return true
+	}
+	return false
+}
+
 // Producer wraps an internal kafka producer
 type Producer struct {
 	Producer  sarama.SyncProducer
+	Client    sarama.Client
 	TopicName string
+	Encoding  string
+	Source    string
+}
+
+// cloudEvent is the CloudEvents 1.0 JSON envelope used for the structured encoding.
+type cloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Type            string      `json:"type"`
+	Source          string      `json:"source"`
+	Time            string      `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	RequestID       string      `json:"requestid,omitempty"`
+	Traceparent     string      `json:"traceparent,omitempty"`
+	Data            interface{} `json:"data"`
 }
 
-// FileUploaded sends a new event.
+// message is an event and the attributes it is sent with, whichever type of event it is.
+type message struct {
+	key         string
+	eventType   string
+	time        int64
+	requestID   string
+	traceparent string
+	data        interface{}
+}
+
+// FileUploaded sends a new event to the producer's default topic.
 func (kafka Producer) FileUploaded(event event.FileUploaded) error {
+	return kafka.FileUploadedToTopic(kafka.TopicName, event)
+}
+
+// FileUploadedToTopic sends a new event to the given topic, using the default topic if it is empty.
+func (kafka Producer) FileUploadedToTopic(topic string, event event.FileUploaded) error {
+	_, err := kafka.SendFileUploaded(topic, event)
+	return err
+}
+
+// SendFileUploaded sends a new event to the given topic, using the default topic if it is empty,
+// and returns the partition and offset it was written at.
+func (kafka Producer) SendFileUploaded(topic string, uploaded event.FileUploaded) (event.Receipt, error) {
+
+	if len(topic) == 0 {
+		topic = kafka.TopicName
+	}
 
-	eventJSON, err := json.Marshal(event)
+	return kafka.send(topic, message{
+		key:         uploaded.S3URL,
+		eventType:   FileUploadedType,
+		time:        uploaded.Time,
+		requestID:   uploaded.RequestID,
+		traceparent: uploaded.Traceparent,
+		data:        uploaded,
+	})
+}
+
+// FileRejectedToTopic sends a file rejected event to the given topic.
+func (kafka Producer) FileRejectedToTopic(topic string, rejected event.FileRejected) error {
+	_, err := kafka.send(topic, message{
+		key:         rejected.Filename,
+		eventType:   FileRejectedType,
+		time:        rejected.Time,
+		requestID:   rejected.RequestID,
+		traceparent: rejected.Traceparent,
+		data:        rejected,
+	})
+	return err
+}
+
+func (kafka Producer) send(topic string, msg message) (event.Receipt, error) {
+
+	producerMsg, err := kafka.newMessage(topic, msg)
 	if err != nil {
+		return event.Receipt{}, err
+	}
+
+	started := time.Now()
+	partition, offset, err := kafka.Producer.SendMessage(producerMsg)
+	metrics.KafkaSendDuration.WithLabelValues(topic).Observe(time.Since(started).Seconds())
+	if err != nil {
+		metrics.KafkaSendErrors.WithLabelValues(topic).Inc()
+		return event.Receipt{}, err
+	}
+
+	return event.Receipt{Topic: topic, Partition: partition, Offset: offset}, nil
+}
+
+// Close shuts down the producer, waiting for any buffered messages to be sent.
+func (kafka Producer) Close() error {
+	err := kafka.Producer.Close()
+	if kafka.Client != nil {
+		if clientErr := kafka.Client.Close(); err == nil {
+			err = clientErr
+		}
+	}
+	return err
+}
+
+// Check refreshes the metadata for the default topic and the given topics, returning an error
+// if no brokers are available or any of the topics has no partitions.
+func (kafka Producer) Check(ctx context.Context, topics ...string) error {
+	if kafka.Client == nil {
+		return errors.New("The Kafka client has not been configured")
+	}
+
+	topics = append([]string{kafka.TopicName}, topics...)
+	if err := kafka.Client.RefreshMetadata(topics...); err != nil {
 		return err
 	}
 
+	if len(kafka.Client.Brokers()) == 0 {
+		return errors.New("No Kafka brokers are available")
+	}
+
+	for _, topic := range topics {
+		partitions, err := kafka.Client.Partitions(topic)
+		if err != nil {
+			return fmt.Errorf("Topic %s: %v", topic, err)
+		}
+		if len(partitions) == 0 {
+			return fmt.Errorf("Topic %s has no partitions", topic)
+		}
+	}
+
+	return nil
+}
+
+func (kafka Producer) newMessage(topic string, msg message) (*sarama.ProducerMessage, error) {
+
 	producerMsg := &sarama.ProducerMessage{
-		Topic: kafka.TopicName,
-		Key:   sarama.StringEncoder(event.S3URL),
-		Value: sarama.ByteEncoder(eventJSON),
+		Topic: topic,
+		Key:   sarama.StringEncoder(msg.key),
 	}
 
-	_, _, err = kafka.Producer.SendMessage(producerMsg)
+	if kafka.Encoding == "" || kafka.Encoding == EncodingPlain {
+		eventJSON, err := json.Marshal(msg.data)
+		if err != nil {
+			return nil, err
+		}
+		producerMsg.Value = sarama.ByteEncoder(eventJSON)
+		producerMsg.Headers = withTraceparent(nil, msg.traceparent)
+		return producerMsg, nil
+	}
 
-	return err
+	id, err := newEventID()
+	if err != nil {
+		return nil, err
+	}
+	eventTime := time.Unix(msg.time, 0).UTC().Format(time.RFC3339)
+
+	switch kafka.Encoding {
+	case EncodingStructured:
+		envelopeJSON, err := json.Marshal(cloudEvent{
+			SpecVersion:     cloudEventsSpecVersion,
+			ID:              id,
+			Type:            msg.eventType,
+			Source:          kafka.Source,
+			Time:            eventTime,
+			DataContentType: contentTypeJSON,
+			RequestID:       msg.requestID,
+			Traceparent:     msg.traceparent,
+			Data:            msg.data,
+		})
+		if err != nil {
+			return nil, err
+		}
+		producerMsg.Value = sarama.ByteEncoder(envelopeJSON)
+		producerMsg.Headers = withTraceparent([]sarama.RecordHeader{
+			header("content-type", contentTypeCloudEventsJSON),
+		}, msg.traceparent)
+	case EncodingBinary:
+		eventJSON, err := json.Marshal(msg.data)
+		if err != nil {
+			return nil, err
+		}
+		producerMsg.Value = sarama.ByteEncoder(eventJSON)
+		producerMsg.Headers = []sarama.RecordHeader{
+			header("ce_specversion", cloudEventsSpecVersion),
+			header("ce_id", id),
+			header("ce_type", msg.eventType),
+			header("ce_source", kafka.Source),
+			header("ce_time", eventTime),
+			header("content-type", contentTypeJSON),
+		}
+		if len(msg.requestID) > 0 {
+			producerMsg.Headers = append(producerMsg.Headers, header("ce_requestid", msg.requestID))
+		}
+		if len(msg.traceparent) > 0 {
+			producerMsg.Headers = append(producerMsg.Headers, header("ce_traceparent", msg.traceparent))
+		}
+		producerMsg.Headers = withTraceparent(producerMsg.Headers, msg.traceparent)
+	default:
+		return nil, fmt.Errorf("Unsupported event encoding: %q", kafka.Encoding)
+	}
+
+	return producerMsg, nil
+}
+
+// withTraceparent adds the W3C traceparent header for the event, if it has one, so that
+// consumers can continue the trace whatever the encoding.
+func withTraceparent(headers []sarama.RecordHeader, traceparent string) []sarama.RecordHeader {
+	if len(traceparent) == 0 {
+		return headers
+	}
+	return append(headers, header(tracing.TraceparentHeader, traceparent))
+}
+
+func header(key, value string) sarama.RecordHeader {
+	return sarama.RecordHeader{Key: []byte(key), Value: []byte(value)}
+}
+
+// newEventID returns a random identifier for a CloudEvent.
+func newEventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
@@ -50,3 +50,138 @@ func TestProcessor(t *testing.T) {
 		})
 	})
 }
+
+// capturingProducer records the messages sent so headers can be inspected.
+type capturingProducer struct {
+	sarama.SyncProducer
+	messages []*sarama.ProducerMessage
+}
+
+func (p *capturingProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.messages = append(p.messages, msg)
+	return 0, int64(len(p.messages)), nil
+}
+
+func headerValues(msg *sarama.ProducerMessage) map[string]string {
+	values := make(map[string]string)
+	for _, h := range msg.Headers {
+		values[string(h.Key)] = string(h.Value)
+	}
+	return values
+}
+
+func TestCloudEventsEncoding(t *testing.T) {
+
+	uploaded := event.FileUploaded{
+		Time:        1483228800,
+		S3URL:       "s3://bucket/dir/file.csv",
+		RequestID:   "abcdef",
+		Traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	}
+
+	Convey("Given a producer using the structured CloudEvents encoding", t, func() {
+		capture := &capturingProducer{}
+		eventProducer := kafka.Producer{
+			Producer:  capture,
+			TopicName: "fileUploaded",
+			Encoding:  kafka.EncodingStructured,
+			Source:    "test-source",
+		}
+
+		Convey("When a file uploaded event is sent", func() {
+			err := eventProducer.FileUploaded(uploaded)
+			So(err, ShouldBeNil)
+			So(capture.messages, ShouldHaveLength, 1)
+
+			Convey("Then the value is a CloudEvents envelope containing the event", func() {
+				value, _ := capture.messages[0].Value.Encode()
+				var envelope map[string]interface{}
+				So(json.Unmarshal(value, &envelope), ShouldBeNil)
+				So(envelope["specversion"], ShouldEqual, "1.0")
+				So(envelope["type"], ShouldEqual, kafka.FileUploadedType)
+				So(envelope["source"], ShouldEqual, "test-source")
+				So(envelope["id"], ShouldNotBeBlank)
+				So(envelope["time"], ShouldEqual, "2017-01-01T00:00:00Z")
+				So(envelope["requestid"], ShouldEqual, "abcdef")
+				So(envelope["traceparent"], ShouldEqual, uploaded.Traceparent)
+				So(envelope["data"].(map[string]interface{})["s3URL"], ShouldEqual, uploaded.S3URL)
+			})
+
+			Convey("And the content-type header identifies the envelope", func() {
+				So(headerValues(capture.messages[0])["content-type"], ShouldStartWith, "application/cloudevents+json")
+				So(headerValues(capture.messages[0])["traceparent"], ShouldEqual, uploaded.Traceparent)
+			})
+		})
+	})
+
+	Convey("Given a producer using the binary CloudEvents encoding", t, func() {
+		capture := &capturingProducer{}
+		eventProducer := kafka.Producer{
+			Producer:  capture,
+			TopicName: "fileUploaded",
+			Encoding:  kafka.EncodingBinary,
+			Source:    "test-source",
+		}
+
+		Convey("When a file uploaded event is sent", func() {
+			err := eventProducer.FileUploaded(uploaded)
+			So(err, ShouldBeNil)
+			So(capture.messages, ShouldHaveLength, 1)
+
+			Convey("Then the value is the plain event", func() {
+				value, _ := capture.messages[0].Value.Encode()
+				var sent event.FileUploaded
+				So(json.Unmarshal(value, &sent), ShouldBeNil)
+				So(sent.S3URL, ShouldEqual, uploaded.S3URL)
+				So(sent.Time, ShouldEqual, uploaded.Time)
+			})
+
+			Convey("And the CloudEvents attributes are sent as headers", func() {
+				headers := headerValues(capture.messages[0])
+				So(headers["ce_specversion"], ShouldEqual, "1.0")
+				So(headers["ce_id"], ShouldNotBeBlank)
+				So(headers["ce_type"], ShouldEqual, kafka.FileUploadedType)
+				So(headers["ce_source"], ShouldEqual, "test-source")
+				So(headers["ce_requestid"], ShouldEqual, "abcdef")
+				So(headers["ce_traceparent"], ShouldEqual, uploaded.Traceparent)
+				So(headers["traceparent"], ShouldEqual, uploaded.Traceparent)
+				So(headers["content-type"], ShouldEqual, "application/json")
+			})
+		})
+	})
+}
+
+func TestFileRejected(t *testing.T) {
+
+	Convey("Given a producer using the structured CloudEvents encoding", t, func() {
+		capture := &capturingProducer{}
+		eventProducer := kafka.Producer{
+			Producer:  capture,
+			TopicName: "fileUploaded",
+			Encoding:  kafka.EncodingStructured,
+			Source:    "test-source",
+		}
+
+		Convey("When a file rejected event is sent", func() {
+			err := eventProducer.FileRejectedToTopic("fileRejected", event.FileRejected{
+				Time:      1483228800,
+				Filename:  "infected.csv",
+				Reason:    "The file is infected with Eicar-Test-Signature.",
+				Signature: "Eicar-Test-Signature",
+				RequestID: "abcdef",
+			})
+			So(err, ShouldBeNil)
+			So(capture.messages, ShouldHaveLength, 1)
+
+			Convey("Then it is sent to the given topic with the file rejected type", func() {
+				So(capture.messages[0].Topic, ShouldEqual, "fileRejected")
+				value, _ := capture.messages[0].Value.Encode()
+				var envelope map[string]interface{}
+				So(json.Unmarshal(value, &envelope), ShouldBeNil)
+				So(envelope["type"], ShouldEqual, kafka.FileRejectedType)
+				So(envelope["requestid"], ShouldEqual, "abcdef")
+				So(envelope["data"].(map[string]interface{})["signature"], ShouldEqual, "Eicar-Test-Signature")
+			})
+		})
+	})
+}
@@ -1,12 +1,51 @@
 package event
 
+import "github.com/ONSdigital/dp-dd-file-uploader/metadata"
+
 // Producer interface for sending events.
 type Producer interface {
 	FileUploaded(event FileUploaded) (err error)
+	FileUploadedToTopic(topic string, event FileUploaded) (err error)
+	FileRejectedToTopic(topic string, event FileRejected) (err error)
+}
+
+// Receipt is where an event was written.
+type Receipt struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+}
+
+// ReceiptProducer is implemented by producers that can report where each event was written.
+type ReceiptProducer interface {
+	SendFileUploaded(topic string, event FileUploaded) (Receipt, error)
 }
 
-// FileUploaded event
+// FileUploaded event. The request ID and W3C traceparent are sent as message headers rather than in the event.
 type FileUploaded struct {
-	Time     int64  `json:"time"`
-	S3URL    string `json:"s3URL"`
+	Time       int64     `json:"time"`
+	S3URL      string    `json:"s3URL"`
+	UploadedBy *Uploader `json:"uploadedBy,omitempty"`
+	// Metadata describes the dataset the file belongs to, if any was given with it.
+	Metadata    *metadata.Metadata `json:"metadata,omitempty"`
+	RequestID   string             `json:"-"`
+	Traceparent string             `json:"-"`
+}
+
+// Uploader is the authenticated identity that uploaded a file, and how it was authenticated.
+type Uploader struct {
+	Subject string `json:"subject"`
+	Name    string `json:"name,omitempty"`
+	Method  string `json:"method"`
+}
+
+// FileRejected event, sent when an uploaded file is refused rather than stored, e.g. because it is infected.
+type FileRejected struct {
+	Time        int64     `json:"time"`
+	Filename    string    `json:"filename"`
+	Reason      string    `json:"reason"`
+	Signature   string    `json:"signature,omitempty"`
+	UploadedBy  *Uploader `json:"uploadedBy,omitempty"`
+	RequestID   string    `json:"-"`
+	Traceparent string    `json:"-"`
 }
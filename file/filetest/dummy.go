@@ -2,9 +2,12 @@ package filetest
 
 import (
 	"errors"
+	"github.com/ONSdigital/dp-dd-file-uploader/file"
 	"github.com/ONSdigital/go-ns/log"
 	"io"
+	"io/ioutil"
 	"strings"
+	"time"
 )
 
 func NewDummyFileStore() *DummyFileStore {
@@ -13,17 +16,55 @@ func NewDummyFileStore() *DummyFileStore {
 
 type DummyFileStore struct {
 	Invocations int
+	Files       []file.Info
+	Filenames   []string
+	Metadata    []map[string]string
+	// ReadFiles reads each file to the end as S3 would, returning any error reading it.
+	ReadFiles bool
+	// ObjectMetadata and Contents are read back by FileMetadata and ReadFile, keyed by filename.
+	ObjectMetadata map[string]map[string]string
+	Contents       map[string]string
 }
 
-func (fileStore *DummyFileStore) SaveFile(reader io.Reader, filename string) error {
+func (fileStore *DummyFileStore) SaveFile(reader io.Reader, filename string, metadata map[string]string) error {
 
 	fileStore.Invocations++
+	fileStore.Filenames = append(fileStore.Filenames, filename)
+	fileStore.Metadata = append(fileStore.Metadata, metadata)
 
 	log.Debug("Save file called.", nil)
 
 	if strings.Contains(filename, "fileSaveError") {
 		return errors.New("Error saving file")
 	}
+	if fileStore.ReadFiles {
+		_, err := io.Copy(ioutil.Discard, reader)
+		return err
+	}
 
 	return nil
 }
+
+func (fileStore *DummyFileStore) ListFiles(prefix string, since time.Time) ([]file.Info, error) {
+
+	var files []file.Info
+	for _, info := range fileStore.Files {
+		if strings.HasPrefix(info.Filename, prefix) && !info.LastModified.Before(since) {
+			files = append(files, info)
+		}
+	}
+
+	return files, nil
+}
+
+func (fileStore *DummyFileStore) FileMetadata(filename string) (map[string]string, error) {
+	return fileStore.ObjectMetadata[filename], nil
+}
+
+func (fileStore *DummyFileStore) ReadFile(filename string) (io.ReadCloser, error) {
+	content, ok := fileStore.Contents[filename]
+	if !ok {
+		return nil, errors.New("File not found: " + filename)
+	}
+	return ioutil.NopCloser(strings.NewReader(content)), nil
+}
@@ -1,19 +1,30 @@
 package s3
 
 import (
+	"context"
 	"github.com/ONSdigital/dp-dd-file-uploader/aws"
+	"github.com/ONSdigital/dp-dd-file-uploader/file"
+	"github.com/ONSdigital/dp-dd-file-uploader/metrics"
 	"github.com/ONSdigital/go-ns/log"
 	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
+	awsS3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
 	"io"
+	"net/http"
+	"strings"
+	"time"
 )
 
 // NewFileStore factory method to initialise AWS S3 classes.
 func NewFileStore(s3Config *aws.Config) *FileStore {
+	awsSession := session.New(&awsSDK.Config{Region: s3Config.GetRegion()})
 	return &FileStore{
-		Uploader: s3manager.NewUploader(session.New(&awsSDK.Config{Region: s3Config.GetRegion()})),
+		Uploader: s3manager.NewUploader(awsSession),
+		Client:   awsS3.New(awsSession),
 		S3Config: s3Config,
 	}
 }
@@ -21,23 +32,115 @@ func NewFileStore(s3Config *aws.Config) *FileStore {
 // FileStore S3 implementation
 type FileStore struct {
 	Uploader s3manageriface.UploaderAPI
+	Client   s3iface.S3API
 	S3Config *aws.Config
 }
 
-// SaveFile sends the file from the given reader to S3 under the given filename.
-func (fs FileStore) SaveFile(reader io.Reader, filename string) error {
-	result, err := fs.Uploader.Upload(&s3manager.UploadInput{
+// SaveFile sends the file from the given reader to S3 under the given filename, with the
+// metadata stored as user-defined object metadata.
+func (fs FileStore) SaveFile(reader io.Reader, filename string, metadata map[string]string) error {
+	started := time.Now()
+	input := &s3manager.UploadInput{
 		Body:   reader,
 		Bucket: fs.S3Config.GetBucketName(),
 		Key:    fs.S3Config.GetFilePath(filename),
-	})
+	}
+	for key, value := range metadata {
+		if len(value) > 0 {
+			if input.Metadata == nil {
+				input.Metadata = make(map[string]*string)
+			}
+			input.Metadata[key] = awsSDK.String(value)
+		}
+	}
+
+	result, err := fs.Uploader.Upload(input)
 	if err != nil {
+		metrics.S3UploadDuration.WithLabelValues("error").Observe(time.Since(started).Seconds())
 		log.Error(err, log.Data{"message": "Failed to upload"})
 		return err
 	}
+	metrics.S3UploadDuration.WithLabelValues("ok").Observe(time.Since(started).Seconds())
 
 	log.Debug("Upload successful", log.Data{
 		"uploadLocation": result.Location,
 	})
 	return nil
 }
+
+// Check confirms the configured bucket exists and is accessible, giving up once the context is done.
+func (fs FileStore) Check(ctx context.Context) error {
+	req, _ := fs.Client.HeadBucketRequest(&awsS3.HeadBucketInput{
+		Bucket: fs.S3Config.GetBucketName(),
+	})
+	// The vendored SDK predates HeadBucketWithContext, so the context is set on the HTTP request, which
+	// is kept when the request is retried
+	req.HTTPRequest = req.HTTPRequest.WithContext(ctx)
+	return req.Send()
+}
+
+// FileMetadata returns the user-defined metadata a file was stored with, keyed in lower case.
+func (fs FileStore) FileMetadata(filename string) (map[string]string, error) {
+	output, err := fs.Client.HeadObject(&awsS3.HeadObjectInput{
+		Bucket: fs.S3Config.GetBucketName(),
+		Key:    fs.S3Config.GetFilePath(filename),
+	})
+	if failure, ok := err.(awserr.RequestFailure); ok && failure.StatusCode() == http.StatusNotFound {
+		return nil, file.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make(map[string]string, len(output.Metadata))
+	for key, value := range output.Metadata {
+		metadata[strings.ToLower(key)] = awsSDK.StringValue(value)
+	}
+	return metadata, nil
+}
+
+// ReadFile returns the content of a stored file, which must be closed once read.
+func (fs FileStore) ReadFile(filename string) (io.ReadCloser, error) {
+	output, err := fs.Client.GetObject(&awsS3.GetObjectInput{
+		Bucket: fs.S3Config.GetBucketName(),
+		Key:    fs.S3Config.GetFilePath(filename),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Body, nil
+}
+
+// ListFiles lists the files stored under the given prefix, relative to the configured S3 path,
+// that were last modified at or after the given time.
+func (fs FileStore) ListFiles(prefix string, since time.Time) ([]file.Info, error) {
+	root := *fs.S3Config.GetFilePath("")
+	var files []file.Info
+
+	err := fs.Client.ListObjectsV2Pages(&awsS3.ListObjectsV2Input{
+		Bucket: fs.S3Config.GetBucketName(),
+		Prefix: fs.S3Config.GetFilePath(prefix),
+	}, func(page *awsS3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			if object.Key == nil || strings.HasSuffix(*object.Key, "/") {
+				continue
+			}
+			lastModified := awsSDK.TimeValue(object.LastModified)
+			if lastModified.Before(since) {
+				continue
+			}
+			files = append(files, file.Info{
+				Filename:     strings.TrimPrefix(*object.Key, root),
+				Size:         awsSDK.Int64Value(object.Size),
+				LastModified: lastModified,
+			})
+		}
+		return true
+	})
+	if err != nil {
+		log.Error(err, log.Data{"message": "Failed to list files", "prefix": prefix})
+		return nil, err
+	}
+
+	return files, nil
+}
@@ -1,22 +1,33 @@
 package s3_test
 
 import (
+	"context"
 	"github.com/ONSdigital/dp-dd-file-uploader/aws"
 	"github.com/ONSdigital/dp-dd-file-uploader/file/s3"
+	awsSDK "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awsS3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 )
 
 type mockUploader struct {
 	invocations int
+	input       *s3manager.UploadInput
 }
 
-func (mockUploader *mockUploader) Upload(*s3manager.UploadInput, ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+func (mockUploader *mockUploader) Upload(input *s3manager.UploadInput, options ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
 
 	mockUploader.invocations++
+	mockUploader.input = input
 
 	return &s3manager.UploadOutput{
 		Location: "",
@@ -42,9 +53,131 @@ func TestUpload(t *testing.T) {
 			reader := strings.NewReader("this is data")
 
 			Convey("When SaveFile is called", func() {
-				s3FileStore.SaveFile(reader, "filename")
+				s3FileStore.SaveFile(reader, "filename", nil)
 
 				So(uploader.invocations, ShouldEqual, 1)
+				So(uploader.input.Metadata, ShouldBeNil)
+			})
+
+			Convey("When SaveFile is called with metadata", func() {
+				s3FileStore.SaveFile(reader, "filename", map[string]string{
+					"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+					"empty":       "",
+				})
+
+				So(uploader.input.Metadata, ShouldHaveLength, 1)
+				So(awsSDK.StringValue(uploader.input.Metadata["traceparent"]), ShouldEqual, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+			})
+		})
+	})
+}
+
+type mockClient struct {
+	s3iface.S3API
+	input   *awsS3.ListObjectsV2Input
+	objects []*awsS3.Object
+}
+
+func (mockClient *mockClient) HeadObject(input *awsS3.HeadObjectInput) (*awsS3.HeadObjectOutput, error) {
+	return &awsS3.HeadObjectOutput{Metadata: map[string]*string{"Dataset-Id": awsSDK.String(*input.Key)}}, nil
+}
+
+func (mockClient *mockClient) ListObjectsV2Pages(input *awsS3.ListObjectsV2Input, fn func(*awsS3.ListObjectsV2Output, bool) bool) error {
+	mockClient.input = input
+	fn(&awsS3.ListObjectsV2Output{Contents: mockClient.objects}, true)
+	return nil
+}
+
+func TestListFiles(t *testing.T) {
+
+	Convey("Given a s3FileStore instance with a mock s3 client containing some objects", t, func() {
+
+		s3URL, _ := url.Parse("s3://dp-csv-splitter/smooosh")
+		day := time.Date(2017, 1, 2, 0, 0, 0, 0, time.UTC)
+
+		client := mockClient{objects: []*awsS3.Object{
+			{Key: awsSDK.String("smooosh/census/old.csv"), LastModified: awsSDK.Time(day.Add(-time.Hour)), Size: awsSDK.Int64(1)},
+			{Key: awsSDK.String("smooosh/census/new.csv"), LastModified: awsSDK.Time(day.Add(time.Hour)), Size: awsSDK.Int64(2)},
+		}}
+
+		s3FileStore := s3.FileStore{
+			Client:   &client,
+			S3Config: aws.NewAWSConfig("region1", s3URL),
+		}
+
+		Convey("When ListFiles is called", func() {
+			files, err := s3FileStore.ListFiles("census", day)
+
+			Convey("Then the prefix is listed relative to the configured path", func() {
+				So(err, ShouldBeNil)
+				So(*client.input.Bucket, ShouldEqual, "dp-csv-splitter")
+				So(*client.input.Prefix, ShouldEqual, "smooosh/census")
+			})
+
+			Convey("And only files modified since the given time are returned, relative to the configured path", func() {
+				So(files, ShouldHaveLength, 1)
+				So(files[0].Filename, ShouldEqual, "census/new.csv")
+				So(files[0].Size, ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+func TestFileMetadata(t *testing.T) {
+
+	Convey("Given a s3FileStore instance with a mock s3 client", t, func() {
+		s3URL, _ := url.Parse("s3://dp-csv-splitter/smooosh")
+		s3FileStore := s3.FileStore{
+			Client:   &mockClient{},
+			S3Config: aws.NewAWSConfig("region1", s3URL),
+		}
+
+		Convey("When the metadata of a file is read", func() {
+			metadata, err := s3FileStore.FileMetadata("census/new.csv")
+
+			Convey("Then the object under the configured path is read, with its keys in lower case", func() {
+				So(err, ShouldBeNil)
+				So(metadata, ShouldResemble, map[string]string{"dataset-id": "smooosh/census/new.csv"})
+			})
+		})
+	})
+}
+
+func TestCheck(t *testing.T) {
+
+	Convey("Given a s3FileStore instance whose S3 endpoint never responds", t, func() {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			select {
+			case <-release:
+			case <-req.Context().Done():
+			}
+		}))
+		defer server.Close()
+		defer close(release)
+
+		s3URL, _ := url.Parse("s3://dp-csv-splitter/smooosh")
+		awsSession := session.New(&awsSDK.Config{
+			Region:           awsSDK.String("eu-west-1"),
+			Endpoint:         awsSDK.String(server.URL),
+			S3ForcePathStyle: awsSDK.Bool(true),
+			Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+			MaxRetries:       awsSDK.Int(0),
+		})
+		s3FileStore := s3.FileStore{
+			Client:   awsS3.New(awsSession),
+			S3Config: aws.NewAWSConfig("region1", s3URL),
+		}
+
+		Convey("When the check's context times out", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			started := time.Now()
+			err := s3FileStore.Check(ctx)
+
+			Convey("Then the check gives up with an error", func() {
+				So(err, ShouldNotBeNil)
+				So(time.Since(started), ShouldBeLessThan, 5*time.Second)
 			})
 		})
 	})
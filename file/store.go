@@ -1,9 +1,34 @@
 package file
 
 import (
+	"errors"
 	"io"
+	"time"
 )
 
+// ErrNotFound is returned when reading a file that hasn't been stored.
+var ErrNotFound = errors.New("File not found")
+
+// Store saves files, along with metadata describing them.
 type Store interface {
-	SaveFile(reader io.Reader, filename string) (err error)
+	SaveFile(reader io.Reader, filename string, metadata map[string]string) (err error)
+}
+
+// Lister lists files that have previously been stored.
+type Lister interface {
+	ListFiles(prefix string, since time.Time) (files []Info, err error)
+}
+
+// Reader reads back the metadata and content of files that have previously been stored.
+type Reader interface {
+	// FileMetadata returns the metadata a file was stored with, keyed in lower case, or ErrNotFound.
+	FileMetadata(filename string) (map[string]string, error)
+	ReadFile(filename string) (io.ReadCloser, error)
+}
+
+// Info describes a stored file. The filename is relative to the configured storage location.
+type Info struct {
+	Filename     string
+	Size         int64
+	LastModified time.Time
 }
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/auth"
+	"github.com/ONSdigital/dp-dd-file-uploader/metadata"
+	"github.com/ONSdigital/dp-dd-file-uploader/metrics"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// APIPath is the path the versioned JSON API is served under.
+const APIPath = "/api/v1"
+
+// The headers naming a file, and the dataset it belongs to, when it is uploaded as the raw request body. The
+// rest of the dataset metadata is sent in headers named after its form fields, e.g. X-Release-Date.
+const (
+	FilenameHeader = "X-Filename"
+	DatasetHeader  = "X-Dataset"
+)
+
+// The codes of API errors that aren't the outcome of an upload.
+const (
+	CodeNotFound         = "not_found"
+	CodeNotCancellable   = "not_cancellable"
+	CodeValidationFailed = "validation_failed"
+	CodeInternalError    = "internal_error"
+)
+
+var MissingFilename string = "The " + FilenameHeader + " header must give the name of the file."
+var MissingPathFilename string = "The path must end with the name of the file."
+var UnsupportedEncoding string = "Files can only be sent with a gzip Content-Encoding."
+var UploadNotFound string = "There is no upload with that ID."
+var UploadFinished string = "The upload has already finished, so can't be cancelled."
+var UploadCancelled string = "The upload was cancelled."
+
+// The number of uploads listed when no limit is given.
+const defaultUploadLimit = 100
+
+// CreateUpload receives a file sent as a multipart form, like the upload form, or as the raw request
+// body named by the X-Filename header, and returns the status of the queued upload.
+func (s *UploadService) CreateUpload(w http.ResponseWriter, req *http.Request) {
+	source := readRawFile
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		source = readMultipartFiles
+	}
+
+	s.createUpload(w, req, source)
+}
+
+// PutFile receives a file sent as the raw request body, named by the last part of the path, and
+// returns the status of the queued upload.
+func (s *UploadService) PutFile(w http.ResponseWriter, req *http.Request) {
+	s.createUpload(w, req, readPathFile)
+}
+
+// createUpload receives the files from the source. A single file is responded to with the status of
+// the queued upload, or why it was refused; several with a 207 listing the result for each.
+func (s *UploadService) createUpload(w http.ResponseWriter, req *http.Request, source fileSource) {
+	results := s.receive(w, req, source)
+	if results == nil {
+		return
+	}
+
+	if len(results) == 1 {
+		if results[0].refusal != nil {
+			results[0].refusal.write(w, req)
+			return
+		}
+		w.Header().Set("Location", APIPath+"/uploads/"+results[0].job.id)
+		writeJSONResponse(w, req, results[0].job.Status(), http.StatusAccepted)
+		return
+	}
+
+	body := FileResults{Results: make([]FileResult, 0, len(results))}
+	for _, result := range results {
+		file := FileResult{Filename: result.filename, Status: http.StatusAccepted}
+		if result.refusal != nil {
+			file.Status, file.Error = result.refusal.status, &result.refusal.body
+		} else {
+			status := result.job.Status()
+			file.Upload = &status
+		}
+		body.Results = append(body.Results, file)
+	}
+	writeJSONResponse(w, req, body, http.StatusMultiStatus)
+}
+
+// FileResult is the outcome of one of several files uploaded in a request: the upload, if the file
+// was queued, or why it was refused.
+type FileResult struct {
+	Filename string `json:"filename"`
+	// Status is the HTTP status the file would have been responded to with on its own.
+	Status int           `json:"status"`
+	Upload *UploadStatus `json:"upload,omitempty"`
+	Error  *Response     `json:"error,omitempty"`
+}
+
+// FileResults are the outcomes of the files uploaded in a request, in the order they were sent.
+type FileResults struct {
+	Results []FileResult `json:"results"`
+}
+
+// readRawFile reads a file sent as the request body, named by the X-Filename header.
+func readRawFile(req *http.Request, receive func(fields map[string]string, filename string, body io.Reader, size int64) error) error {
+	filename := strings.TrimSpace(req.Header.Get(FilenameHeader))
+	if len(filename) > 0 {
+		filename = filepath.Base(filepath.FromSlash(filename))
+	}
+	return readBody(req, filename, MissingFilename, receive)
+}
+
+// readPathFile reads a file sent as the request body, named by the last part of the path.
+func readPathFile(req *http.Request, receive func(fields map[string]string, filename string, body io.Reader, size int64) error) error {
+	return readBody(req, path.Base(req.URL.Path), MissingPathFilename, receive)
+}
+
+// readBody reads a file with the given name sent as the request body, decompressing it if it was
+// sent with a gzip Content-Encoding. The dataset metadata, if any, is given by headers such as X-Dataset.
+func readBody(req *http.Request, filename string, missing string, receive func(fields map[string]string, filename string, body io.Reader, size int64) error) error {
+	if len(filename) == 0 || filename == "." || filename == "/" || filename == string(filepath.Separator) {
+		return requestError(missing)
+	}
+	if !validFilename(filename) {
+		return requestError(InvalidFilename)
+	}
+	fields := metadata.HeaderFields(req.Header)
+
+	switch encoding := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return receive(fields, filename, req.Body, req.ContentLength)
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(req.Body)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		// The size of the decompressed file isn't known until it has been read
+		return receive(fields, filename, reader, -1)
+	default:
+		return requestError(UnsupportedEncoding)
+	}
+}
+
+// GetUpload returns the status of an upload.
+func (s *UploadService) GetUpload(w http.ResponseWriter, req *http.Request) {
+	j := s.findJob(req)
+	if j == nil {
+		writeJSONResponse(w, req, Response{Code: CodeNotFound, Message: UploadNotFound}, http.StatusNotFound)
+		return
+	}
+	writeJSONResponse(w, req, j.Status(), http.StatusOK)
+}
+
+// ListUploads returns the uploads the caller can see, the most recent first, up to limit. If a status
+// query parameter is given, only uploads with that status are listed.
+func (s *UploadService) ListUploads(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	status := query.Get("status")
+	switch status {
+	case "", StatusQueued, StatusProcessing, StatusCompleted, StatusFailed, StatusRejected, StatusCancelled:
+	default:
+		writeJSONResponse(w, req, Response{Code: metrics.OutcomeBadRequest, Message: "The status parameter must be one of queued, processing, completed, failed, rejected or cancelled."}, http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultUploadLimit
+	if value := query.Get("limit"); len(value) > 0 {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxJobs {
+			writeJSONResponse(w, req, Response{Code: metrics.OutcomeBadRequest, Message: "The limit parameter must be between 1 and " + strconv.Itoa(maxJobs) + "."}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	identity := auth.FromContext(req.Context())
+	uploads := s.jobs.list(func(j *job) bool {
+		return canSee(identity, j) && (len(status) == 0 || j.Status().Status == status)
+	}, limit)
+	writeJSONResponse(w, req, UploadList{Uploads: uploads}, http.StatusOK)
+}
+
+// CancelUpload cancels an upload that hasn't finished. A queued upload is cancelled straight away; one
+// being processed is cancelled before it is announced, unless it has already been stored.
+func (s *UploadService) CancelUpload(w http.ResponseWriter, req *http.Request) {
+	j := s.findJob(req)
+	if j == nil {
+		writeJSONResponse(w, req, Response{Code: CodeNotFound, Message: UploadNotFound}, http.StatusNotFound)
+		return
+	}
+	if !j.requestCancel() {
+		writeJSONResponse(w, req, Response{Code: CodeNotCancellable, Message: UploadFinished}, http.StatusConflict)
+		return
+	}
+
+	log.DebugR(req, "Cancelling upload", log.Data{"id": j.id})
+	writeJSONResponse(w, req, j.Status(), http.StatusAccepted)
+}
+
+// findJob returns the upload with the ID in the request path, if the caller can see it.
+func (s *UploadService) findJob(req *http.Request) *job {
+	j := s.jobs.get(req.URL.Query().Get(":id"))
+	if j == nil || !canSee(auth.FromContext(req.Context()), j) {
+		return nil
+	}
+	return j
+}
+
+// canSee returns whether the identity can see an upload. Users can see their own uploads, and anyone
+// can see every upload if authentication is disabled.
+func canSee(identity *auth.Identity, j *job) bool {
+	return identity == nil || identity.Owner() == j.owner
+}
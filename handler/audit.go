@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/audit"
+	"github.com/ONSdigital/dp-dd-file-uploader/auth"
+	"github.com/ONSdigital/dp-dd-file-uploader/event"
+	"github.com/ONSdigital/dp-dd-file-uploader/metrics"
+	"github.com/ONSdigital/go-ns/handlers/response"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+var AuditDisabled string = "Auditing is not enabled."
+var AuditForbidden string = "You are not allowed to read the audit log."
+
+// The number of audit records returned by a query that doesn't set a limit, and the most that can be asked for.
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// AuditResponse is the result of an audit query.
+type AuditResponse struct {
+	Records []audit.Record `json:"records"`
+}
+
+// newAttempt starts the audit record of an upload request, with who made it and where from.
+func newAttempt(req *http.Request) audit.Record {
+	record := audit.Record{
+		Time:         time.Now().UTC(),
+		RequestID:    log.Context(req),
+		SourceIP:     sourceIP(req),
+		ForwardedFor: req.Header.Get("X-Forwarded-For"),
+	}
+	if identity := auth.FromContext(req.Context()); identity != nil {
+		record.Subject, record.AuthMethod = identity.Subject, identity.Method
+	}
+	return record
+}
+
+// rejected counts an upload rejected while it was being received, and notes why for the audit log.
+func rejected(attempt *audit.Record, outcome string, reason string) {
+	metrics.Uploads.WithLabelValues(outcome).Inc()
+	attempt.Outcome, attempt.Reason = outcome, reason
+}
+
+// audit appends the record to the audit log, if auditing is enabled.
+func (s *UploadService) audit(record audit.Record) {
+	if err := s.Audit.Append(record); err != nil {
+		log.ErrorC(record.RequestID, err, log.Data{"message": "Failed to write audit record", "outcome": record.Outcome})
+	}
+}
+
+// auditRecord starts the audit record of processing an upload that has been received.
+func (m Manifest) auditRecord() audit.Record {
+	record := audit.Record{
+		RequestID:    m.Context,
+		SourceIP:     m.SourceIP,
+		ForwardedFor: m.ForwardedFor,
+		Filename:     m.Filename,
+		Dataset:      m.Dataset,
+		Size:         m.Size,
+		SHA256:       m.SHA256,
+	}
+	if m.Identity != nil {
+		record.Subject, record.AuthMethod = m.Identity.Subject, m.Identity.Method
+	}
+	return record
+}
+
+// sendEvent sends the event, returning where it was written if the producer reports it.
+func (s *UploadService) sendEvent(topic string, uploaded event.FileUploaded) (*event.Receipt, error) {
+	if producer, ok := s.EventProducer.(event.ReceiptProducer); ok {
+		receipt, err := producer.SendFileUploaded(topic, uploaded)
+		if err != nil {
+			return nil, err
+		}
+		return &receipt, nil
+	}
+	return nil, s.EventProducer.FileUploadedToTopic(topic, uploaded)
+}
+
+func sourceIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// AuditQuery returns the audit records matching the subject, dataset, filename and outcome query
+// parameters, stored since and until the given RFC3339 times, the most recent first, up to limit.
+func (s *UploadService) AuditQuery(w http.ResponseWriter, req *http.Request) {
+	if s.Audit == nil {
+		writeJSONResponse(w, req, Response{Code: CodeNotFound, Message: AuditDisabled}, http.StatusNotFound)
+		return
+	}
+	if !s.canReadAudit(auth.FromContext(req.Context())) {
+		writeJSONResponse(w, req, Response{Code: metrics.OutcomeForbidden, Message: AuditForbidden}, http.StatusForbidden)
+		return
+	}
+
+	query := req.URL.Query()
+	filter := audit.Filter{
+		Subject:  query.Get("subject"),
+		Dataset:  query.Get("dataset"),
+		Filename: query.Get("filename"),
+		Outcome:  query.Get("outcome"),
+		Limit:    defaultAuditLimit,
+	}
+
+	var err error
+	for param, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(param); len(value) > 0 {
+			if *t, err = time.Parse(time.RFC3339, value); err != nil {
+				writeJSONResponse(w, req, Response{Code: metrics.OutcomeBadRequest, Message: "The " + param + " parameter must be an RFC3339 time."}, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if value := query.Get("limit"); len(value) > 0 {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 1 || filter.Limit > maxAuditLimit {
+			writeJSONResponse(w, req, Response{Code: metrics.OutcomeBadRequest, Message: "The limit parameter must be between 1 and " + strconv.Itoa(maxAuditLimit) + "."}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	records, err := s.Audit.Query(filter)
+	if err != nil {
+		log.ErrorR(req, err, log.Data{"message": "Failed to query the audit log"})
+		writeJSONResponse(w, req, Response{Code: CodeInternalError, Message: "Failed to query the audit log."}, http.StatusInternalServerError)
+		return
+	}
+
+	err = response.WriteJSON(w, AuditResponse{Records: records}, http.StatusOK)
+	if err != nil {
+		log.ErrorR(req, err, log.Data{"message": "Failed to write JSON response"})
+	}
+}
+
+// canReadAudit returns whether the identity is one of the audit readers, or a member of one of their groups.
+// If there are no audit readers, no one can read the audit log.
+func (s *UploadService) canReadAudit(identity *auth.Identity) bool {
+	if identity == nil {
+		return false
+	}
+	for _, reader := range s.AuditReaders {
+		if reader == identity.Subject {
+			return true
+		}
+		for _, group := range identity.Groups {
+			if reader == group {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/audit"
+	"github.com/ONSdigital/dp-dd-file-uploader/auth"
+	"github.com/ONSdigital/dp-dd-file-uploader/handler"
+	"github.com/ONSdigital/dp-dd-file-uploader/metrics"
+	"github.com/ONSdigital/dp-dd-file-uploader/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUploadHandlerAudit(t *testing.T) {
+
+	Convey("Given uploads are being audited", t, func() {
+		dir, _ := ioutil.TempDir("", "audit-")
+		defer os.RemoveAll(dir)
+
+		service, _, _ := newUploadService()
+		service.Audit, _ = audit.Open(filepath.Join(dir, "uploads.audit"), nil)
+		defer service.Audit.Close()
+		service.AuditReaders = []string{"auditors"}
+
+		upload := func(identity *auth.Identity) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest("POST", "/", strings.NewReader(exampleMultipartBody))
+			request.Header.Add("Content-Type", "multipart/form-data; boundary=----WebKitFormBoundaryezYpRsrGowIiw0K4")
+			request.RemoteAddr = "192.0.2.1:51234"
+			service.Upload(recorder, request.WithContext(auth.WithIdentity(request.Context(), identity)))
+			return recorder
+		}
+		query := func(identity *auth.Identity, params string) (*httptest.ResponseRecorder, []audit.Record) {
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest("GET", "/audit?"+params, nil)
+			service.AuditQuery(recorder, request.WithContext(auth.WithIdentity(request.Context(), identity)))
+			var response handlers.AuditResponse
+			json.Unmarshal(recorder.Body.Bytes(), &response)
+			return recorder, response.Records
+		}
+		auditor := &auth.Identity{Subject: "carol", Groups: []string{"auditors"}}
+
+		Convey("When a file is uploaded", func() {
+			So(upload(&auth.Identity{Subject: "alice", Method: auth.MethodAPIKey}).Code, ShouldEqual, 202)
+			So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+
+			Convey("Then the completed upload is recorded with its checksum, key and event offset", func() {
+				recorder, records := query(auditor, "subject=alice")
+				So(recorder.Code, ShouldEqual, 200)
+				So(records, ShouldHaveLength, 1)
+
+				record := records[0]
+				So(record.Outcome, ShouldEqual, "completed")
+				So(record.AuthMethod, ShouldEqual, auth.MethodAPIKey)
+				So(record.SourceIP, ShouldEqual, "192.0.2.1")
+				So(record.Filename, ShouldEqual, "AF001EW.csv")
+				So(record.SHA256, ShouldHaveLength, 64)
+				So(record.Key, ShouldEqual, "AF001EW.csv")
+				So(record.EventOffset, ShouldNotBeNil)
+				So(*record.EventOffset, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When an upload is forbidden", func() {
+			service.Policy, _ = policy.New(policy.Rule{Users: []string{"bob"}})
+			So(upload(&auth.Identity{Subject: "mallory"}).Code, ShouldEqual, 403)
+
+			Convey("Then the rejection is recorded with its reason", func() {
+				_, records := query(auditor, "outcome=forbidden")
+				So(records, ShouldHaveLength, 1)
+				So(records[0].Subject, ShouldEqual, "mallory")
+				So(records[0].Reason, ShouldEqual, "mallory is not allowed to upload files.")
+			})
+		})
+
+		Convey("When someone who isn't an audit reader queries the log", func() {
+			recorder, _ := query(&auth.Identity{Subject: "alice"}, "")
+
+			Convey("Then they are forbidden", func() {
+				So(recorder.Code, ShouldEqual, 403)
+			})
+		})
+
+		Convey("When no audit readers are configured", func() {
+			service.AuditReaders = nil
+			recorder, _ := query(auditor, "")
+
+			Convey("Then no one can query the log", func() {
+				So(recorder.Code, ShouldEqual, 403)
+			})
+		})
+
+		Convey("When the log is queried with an invalid limit", func() {
+			recorder, _ := query(auditor, "limit=0")
+
+			Convey("Then a bad request is returned with its code", func() {
+				var response handlers.Response
+				json.Unmarshal(recorder.Body.Bytes(), &response)
+				So(recorder.Code, ShouldEqual, 400)
+				So(response.Code, ShouldEqual, metrics.OutcomeBadRequest)
+			})
+		})
+
+		Convey("When the log is queried with an invalid time", func() {
+			recorder, _ := query(auditor, "since=yesterday")
+
+			Convey("Then a bad request is returned", func() {
+				So(recorder.Code, ShouldEqual, 400)
+			})
+		})
+	})
+}
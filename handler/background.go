@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/ONSdigital/go-ns/handlers/response"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// StopAccepting rejects any new uploads, and ends the event streams following uploads so they don't hold
+// up shutdown. Uploads already being received are still queued for processing.
+func (s *UploadService) StopAccepting() {
+	atomic.StoreInt32(&s.stopped, 1)
+	s.jobs.stop()
+}
+
+func (s *UploadService) accepting() bool {
+	return atomic.LoadInt32(&s.stopped) == 0
+}
+
+// CheckAccepting returns an error once the service has stopped accepting uploads.
+func (s *UploadService) CheckAccepting(ctx context.Context) error {
+	if !s.accepting() {
+		return errors.New(ShuttingDown)
+	}
+	return nil
+}
+
+// WorkerStats writes the queue depth and utilisation of the background upload workers as JSON.
+func (s *UploadService) WorkerStats(w http.ResponseWriter, req *http.Request) {
+	if s.Workers == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err := response.WriteJSON(w, s.Workers.Stats(), http.StatusOK)
+	if err != nil {
+		log.ErrorR(req, err, log.Data{"message": "Failed to write JSON response"})
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ONSdigital/go-ns/log"
+)
+
+const (
+	// eventInterval is the least time between the events sent for an upload, so a fast moving upload
+	// sends a few events a second rather than one for every row.
+	eventInterval = 250 * time.Millisecond
+	// eventHeartbeat is how often a comment is sent while an upload isn't changing, so proxies don't
+	// close the stream for being idle.
+	eventHeartbeat = 15 * time.Second
+	// maxEventStream is the longest an event stream is kept open. Browsers reconnect to carry on.
+	maxEventStream = 10 * time.Minute
+	// eventRetry is how long, in milliseconds, browsers wait before reconnecting to a stream.
+	eventRetry = 2000
+)
+
+// UploadEvents streams the status of an upload as server-sent events, sending a status event with the
+// same body as GetUpload each time its stage, row count or bytes stored change, until it has finished.
+func (s *UploadService) UploadEvents(w http.ResponseWriter, req *http.Request) {
+	j := s.findJob(req)
+	if j == nil {
+		writeJSONResponse(w, req, Response{Code: CodeNotFound, Message: UploadNotFound}, http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONResponse(w, req, Response{Code: CodeInternalError, Message: "The response can't be streamed."}, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stops nginx buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetry)
+
+	log.DebugR(req, "Streaming upload events", log.Data{"id": j.id})
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	end := time.After(maxEventStream)
+	for id := 1; ; id++ {
+		status, changed := j.watch()
+		if err := writeEvent(w, id, "status", status); err != nil {
+			log.ErrorR(req, err, log.Data{"message": "Failed to write upload event", "id": j.id})
+			return
+		}
+		flusher.Flush()
+		if status.Finished() {
+			return
+		}
+
+	wait:
+		for {
+			select {
+			case <-changed:
+				break wait
+			case <-heartbeat.C:
+				io.WriteString(w, ": heartbeat\n\n")
+				flusher.Flush()
+			case <-end:
+				return
+			case <-s.jobs.stopping():
+				return
+			case <-req.Context().Done():
+				return
+			}
+		}
+
+		select {
+		case <-time.After(eventInterval):
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// writeEvent writes a server-sent event with its data encoded as JSON.
+func writeEvent(w io.Writer, id int, name string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, name, b)
+	return err
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/disk"
+	"github.com/ONSdigital/go-ns/handlers/response"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// HealthcheckResponse reports the space available for receiving uploads.
+type HealthcheckResponse struct {
+	TempDir      disk.Usage `json:"tempDir"`
+	MinFreeBytes int64      `json:"minFreeBytes"`
+	Error        string     `json:"error,omitempty"`
+}
+
+// Healthcheck returns 200 while the service is running, along with the free space in the temp dir.
+func (s *UploadService) Healthcheck(w http.ResponseWriter, req *http.Request) {
+	body := HealthcheckResponse{MinFreeBytes: s.MinFreeSpace}
+
+	usage, err := disk.GetUsage(s.TempDir)
+	if err != nil {
+		log.ErrorR(req, err, log.Data{"message": "Unable to determine free space", "dir": s.TempDir})
+		body.Error = err.Error()
+	}
+	body.TempDir = usage
+	body.TempDir.Dir = s.TempDir
+
+	err = response.WriteJSON(w, body, http.StatusOK)
+	if err != nil {
+		log.ErrorR(req, err, log.Data{"message": "Failed to write JSON response"})
+	}
+}
@@ -6,8 +6,9 @@ import (
 	"net/http"
 )
 
-func Home(w http.ResponseWriter, _ *http.Request) {
-	err := render.Home(w)
+// Home renders the upload form.
+func (s *UploadService) Home(w http.ResponseWriter, req *http.Request) {
+	err := render.Home(s.Renderer, w, req)
 	if err != nil {
 		log.Error(err, log.Data{"message": "Failed to render home page"})
 	}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/audit"
+	"github.com/ONSdigital/dp-dd-file-uploader/metadata"
+	"github.com/ONSdigital/dp-dd-file-uploader/metrics"
+)
+
+// The statuses of an upload, from when it is received until it has been processed.
+const (
+	StatusQueued     = "queued"
+	StatusProcessing = "processing"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+	StatusRejected   = "rejected"
+	StatusCancelled  = "cancelled"
+)
+
+// The stages an upload goes through while it is being processed.
+const (
+	StageScanning      = "scanning"
+	StageDecompressing = "decompressing"
+	StageStoring       = "storing"
+	StageAnnouncing    = "announcing"
+)
+
+// maxJobs is the number of finished uploads whose status is kept.
+const maxJobs = 1000
+
+// UploadStatus is the status of an upload, as returned by the API.
+type UploadStatus struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	Dataset  string `json:"dataset,omitempty"`
+	// Metadata describes the dataset the file belongs to, if any was given with it.
+	Metadata *metadata.Metadata `json:"metadata,omitempty"`
+	Status   string             `json:"status"`
+	Size     int64              `json:"size"`
+	SHA256   string             `json:"sha256"`
+	// UploadedBy is the subject of the identity that uploaded the file, if authentication is enabled.
+	UploadedBy string `json:"uploadedBy,omitempty"`
+	// Key and S3URL are where the file is stored, once it has been routed.
+	Key   string `json:"key,omitempty"`
+	S3URL string `json:"s3URL,omitempty"`
+	// Error is why the upload failed, was rejected or was cancelled.
+	Error *Response `json:"error,omitempty"`
+	// Stage is the step the upload has reached while it is being processed.
+	Stage string `json:"stage,omitempty"`
+	// Rows is the number of CSV rows validated so far, and BytesStored the number of bytes sent to S3.
+	Rows        int64     `json:"rows,omitempty"`
+	BytesStored int64     `json:"bytesStored,omitempty"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+}
+
+// Finished returns whether the upload has been processed, one way or another.
+func (u UploadStatus) Finished() bool {
+	return u.Status != StatusQueued && u.Status != StatusProcessing
+}
+
+// UploadList is a page of uploads, the most recent first.
+type UploadList struct {
+	Uploads []UploadStatus `json:"uploads"`
+}
+
+// job follows an upload through the background workers, and can cancel it.
+type job struct {
+	id     string
+	owner  string
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mutex  sync.Mutex
+	status UploadStatus
+	// changed is closed, and replaced, whenever the status changes.
+	changed chan struct{}
+}
+
+func newJob(manifest Manifest) *job {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &job{
+		id:      manifest.ID,
+		owner:   manifest.owner(),
+		ctx:     ctx,
+		cancel:  cancel,
+		changed: make(chan struct{}),
+		status: UploadStatus{
+			ID:         manifest.ID,
+			Filename:   manifest.Filename,
+			Dataset:    manifest.Dataset,
+			Metadata:   manifest.Metadata,
+			Status:     StatusQueued,
+			Size:       manifest.Size,
+			SHA256:     manifest.SHA256,
+			UploadedBy: manifest.uploadedBy(),
+			Created:    manifest.Created,
+			Updated:    time.Now().UTC(),
+		},
+	}
+}
+
+// newUploadID returns a random ID for an upload.
+func newUploadID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Status returns a copy of the job's current status.
+func (j *job) Status() UploadStatus {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.status
+}
+
+// watch returns a copy of the job's current status, and a channel that is closed once it changes.
+func (j *job) watch() (UploadStatus, <-chan struct{}) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.status, j.changed
+}
+
+func (j *job) update(f func(status *UploadStatus)) {
+	if j == nil {
+		return
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	f(&j.status)
+	j.status.Updated = time.Now().UTC()
+	j.notify()
+}
+
+// notify wakes anyone watching the job. The mutex must be held.
+func (j *job) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// start moves a queued job on to processing, returning false if it was cancelled while it was queued.
+func (j *job) start() bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.status.Status != StatusQueued {
+		return false
+	}
+	j.status.Status, j.status.Updated = StatusProcessing, time.Now().UTC()
+	j.notify()
+	return true
+}
+
+// finish sets the final status of the job from the audit record of processing it.
+func (j *job) finish(record audit.Record) {
+	if j == nil {
+		return
+	}
+	j.update(func(status *UploadStatus) {
+		status.Stage = ""
+		switch {
+		case record.Outcome == metrics.OutcomeCompleted:
+			status.Status = StatusCompleted
+			return
+		case record.Outcome == metrics.OutcomeCancelled:
+			status.Status = StatusCancelled
+		case record.Validation == audit.ValidationFailed:
+			status.Status = StatusRejected
+			status.Error = &Response{Code: CodeValidationFailed, Message: record.Reason}
+			return
+		case record.Outcome == metrics.OutcomeInfected, record.Outcome == metrics.OutcomeInvalidZip, record.Outcome == metrics.OutcomeForbidden:
+			status.Status = StatusRejected
+		default:
+			status.Status = StatusFailed
+		}
+		status.Error = &Response{Code: record.Outcome, Message: record.Reason}
+	})
+	j.cancel()
+}
+
+// requestCancel cancels the job, returning false if it has already finished. A queued job is cancelled
+// straight away, while one being processed is cancelled once its worker notices.
+func (j *job) requestCancel() bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.status.Finished() {
+		return false
+	}
+	j.cancel()
+	if j.status.Status == StatusQueued {
+		j.status.Status, j.status.Updated = StatusCancelled, time.Now().UTC()
+		j.status.Error = &Response{Code: metrics.OutcomeCancelled, Message: UploadCancelled}
+		j.notify()
+	}
+	return true
+}
+
+// jobList holds the uploads received by the service, so their status can be followed. Only the
+// most recent maxJobs finished uploads are kept. The zero value is an empty list.
+type jobList struct {
+	mutex sync.Mutex
+	byID  map[string]*job
+	order []*job
+	// stopped is closed once the service stops, so anyone following a job stops waiting for it.
+	stopped chan struct{}
+}
+
+func (l *jobList) add(j *job) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.byID == nil {
+		l.byID = make(map[string]*job)
+	}
+	l.byID[j.id] = j
+	l.order = append(l.order, j)
+
+	excess := len(l.order) - maxJobs
+	if excess <= 0 {
+		return
+	}
+	kept := l.order[:0]
+	for _, old := range l.order {
+		if excess > 0 && old.Status().Finished() {
+			delete(l.byID, old.id)
+			excess--
+			continue
+		}
+		kept = append(kept, old)
+	}
+	l.order = kept
+}
+
+// stopping returns a channel that is closed once the service stops.
+func (l *jobList) stopping() <-chan struct{} {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.stoppedChan()
+}
+
+func (l *jobList) stop() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	stopped := l.stoppedChan()
+	select {
+	case <-stopped:
+	default:
+		close(stopped)
+	}
+}
+
+// stoppedChan returns the stopped channel, creating it if need be. The mutex must be held.
+func (l *jobList) stoppedChan() chan struct{} {
+	if l.stopped == nil {
+		l.stopped = make(chan struct{})
+	}
+	return l.stopped
+}
+
+// get returns the job with the given ID, or nil if there isn't one.
+func (l *jobList) get(id string) *job {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.byID[id]
+}
+
+// list returns the status of up to limit jobs the match function accepts, the most recent first.
+func (l *jobList) list(match func(*job) bool, limit int) []UploadStatus {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	statuses := []UploadStatus{}
+	for i := len(l.order) - 1; i >= 0 && len(statuses) < limit; i-- {
+		if match(l.order[i]) {
+			statuses = append(statuses, l.order[i].Status())
+		}
+	}
+	return statuses
+}
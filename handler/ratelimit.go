@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/audit"
+	"github.com/ONSdigital/dp-dd-file-uploader/auth"
+	"github.com/ONSdigital/dp-dd-file-uploader/metrics"
+	"github.com/ONSdigital/dp-dd-file-uploader/ratelimit"
+	"github.com/ONSdigital/dp-dd-file-uploader/render"
+	"github.com/ONSdigital/go-ns/handlers/response"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// RateLimitResponse is the JSON body of an upload refused for being over a limit.
+type RateLimitResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Limit describes the limit reached, e.g. "100 uploads an hour".
+	Limit string `json:"limit"`
+	// RetryAfter is the number of seconds until an upload could be allowed.
+	RetryAfter int `json:"retryAfter"`
+}
+
+// limitedUpload is the upload a request is counted as against the rate limits, the number of files
+// received from it and their bytes, after any Content-Encoding has been decompressed.
+type limitedUpload struct {
+	upload   *ratelimit.Upload
+	files    int
+	received int64
+}
+
+type limitedUploadKey struct{}
+
+// limitedUploadFrom returns the rate limited upload a request is part of, or nil if it isn't limited.
+func limitedUploadFrom(ctx context.Context) *limitedUpload {
+	upload, _ := ctx.Value(limitedUploadKey{}).(*limitedUpload)
+	return upload
+}
+
+// next counts another file of the request against the hourly limits, returning a LimitError if it is
+// over one. The first file was counted when the upload began.
+func (u *limitedUpload) next() error {
+	if u == nil {
+		return nil
+	}
+	u.files++
+	if u.files == 1 {
+		return nil
+	}
+	return u.upload.Next()
+}
+
+// receive counts the bytes of a file received. Receiving for a request that isn't limited does nothing.
+func (u *limitedUpload) receive(bytes int64) {
+	if u != nil {
+		u.received += bytes
+	}
+}
+
+// RateLimit limits the uploads handled by next for each user and client IP. Uploads over a limit get
+// a 429, as JSON or, for browsers, the upload form with a message saying when to try again. If the
+// limits can't be checked, e.g. because a shared store is down, uploads are allowed.
+func (s *UploadService) RateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if s.Limiter == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		var user string
+		if identity := auth.FromContext(req.Context()); identity != nil {
+			user = identity.Subject
+		}
+		size := req.ContentLength
+		if size < 0 {
+			size = 0
+		}
+
+		upload, err := s.Limiter.Begin(user, sourceIP(req), size)
+		if limit, ok := err.(*ratelimit.LimitError); ok {
+			s.handleRateLimited(w, req, limit)
+			return
+		}
+		if err != nil {
+			log.ErrorR(req, err, log.Data{"message": "Unable to check upload limits, allowing the upload"})
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		limited := &limitedUpload{upload: upload}
+		req = req.WithContext(context.WithValue(req.Context(), limitedUploadKey{}, limited))
+		// Ended even if next panics, so the upload doesn't hold a concurrent upload slot forever
+		defer func() {
+			if err := upload.End(limited.received); err != nil {
+				log.ErrorR(req, err, log.Data{"message": "Unable to record the end of an upload against its limits"})
+			}
+		}()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// limitFile counts each file in a request as an upload against the hourly limits, refusing one that
+// would take the uploader over a limit. If the limits can't be checked, the file is allowed.
+func limitFile(req *http.Request, attempt *audit.Record) *refusal {
+	err := limitedUploadFrom(req.Context()).next()
+	limit, ok := err.(*ratelimit.LimitError)
+	if !ok {
+		if err != nil {
+			log.ErrorR(req, err, log.Data{"message": "Unable to check upload limits, allowing the file"})
+		}
+		return nil
+	}
+
+	log.DebugR(req, "Rejecting file over its limit", log.Data{"who": limit.Who, "limit": limit.Limit})
+	rejected(attempt, metrics.OutcomeRateLimited, limit.Error())
+	return &refusal{status: http.StatusTooManyRequests, retryAfter: strconv.Itoa(retryAfterSeconds(limit)), body: Response{Code: metrics.OutcomeRateLimited, Message: limit.Error()}}
+}
+
+func (s *UploadService) handleRateLimited(w http.ResponseWriter, req *http.Request, limit *ratelimit.LimitError) {
+	log.DebugR(req, "Rejecting upload over its limit", log.Data{"who": limit.Who, "limit": limit.Limit})
+	attempt := newAttempt(req)
+	rejected(&attempt, metrics.OutcomeRateLimited, limit.Error())
+	s.audit(attempt)
+
+	seconds := retryAfterSeconds(limit)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	if s.Renderer != nil && strings.Contains(req.Header.Get("Accept"), "text/html") {
+		if err := render.HomeMessage(s.Renderer, w, req, http.StatusTooManyRequests, limit.Error()); err != nil {
+			log.ErrorR(req, err, log.Data{"message": "Failed to render home page"})
+		}
+		return
+	}
+
+	body := RateLimitResponse{Code: metrics.OutcomeRateLimited, Message: limit.Error(), Limit: limit.Limit, RetryAfter: seconds}
+	if err := response.WriteJSON(w, body, http.StatusTooManyRequests); err != nil {
+		log.ErrorR(req, err, log.Data{"message": "Failed to write JSON response"})
+	}
+}
+
+// retryAfterSeconds returns the whole number of seconds until an upload over the limit could be allowed.
+func retryAfterSeconds(limit *ratelimit.LimitError) int {
+	return int((limit.RetryAfter + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,155 @@
+package handlers_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/auth"
+	"github.com/ONSdigital/dp-dd-file-uploader/handler"
+	"github.com/ONSdigital/dp-dd-file-uploader/ratelimit"
+	"github.com/ONSdigital/dp-dd-file-uploader/worker"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRateLimit(t *testing.T) {
+
+	Convey("Given each user is limited to one upload an hour", t, func() {
+		service, fileStore, _ := newUploadService()
+		service.Limiter = ratelimit.New(ratelimit.NewMemoryStore(), ratelimit.Limits{UploadsPerHour: 1}, ratelimit.Limits{})
+		handler := service.RateLimit(http.HandlerFunc(service.Upload))
+
+		upload := func(accept string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest("POST", "/", strings.NewReader(exampleMultipartBody))
+			request.Header.Add("Content-Type", "multipart/form-data; boundary=----WebKitFormBoundaryezYpRsrGowIiw0K4")
+			request.Header.Set("Accept", accept)
+			identity := &auth.Identity{Subject: "alice", Method: auth.MethodAPIKey}
+			handler.ServeHTTP(recorder, request.WithContext(auth.WithIdentity(request.Context(), identity)))
+			return recorder
+		}
+
+		So(upload("application/json").Code, ShouldEqual, 202)
+		So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+		So(fileStore.Invocations, ShouldEqual, 1)
+
+		Convey("When a script uploads another file", func() {
+			recorder := upload("application/json")
+
+			Convey("Then it gets a 429 saying which limit was reached and when to retry", func() {
+				So(recorder.Code, ShouldEqual, 429)
+				So(recorder.Header().Get("Retry-After"), ShouldNotBeBlank)
+
+				var response handlers.RateLimitResponse
+				So(json.Unmarshal(recorder.Body.Bytes(), &response), ShouldBeNil)
+				So(response.Limit, ShouldEqual, "1 upload an hour")
+				So(response.Message, ShouldStartWith, "alice has reached the limit of 1 upload an hour")
+				So(response.RetryAfter, ShouldBeGreaterThan, 0)
+			})
+		})
+
+		Convey("When a browser uploads another file", func() {
+			recorder := upload("text/html,application/xhtml+xml")
+
+			Convey("Then the upload form is shown with a message", func() {
+				So(recorder.Code, ShouldEqual, 429)
+				So(recorder.Header().Get("Content-Type"), ShouldStartWith, "text/html")
+				So(recorder.Body.String(), ShouldContainSubstring, "alice has reached the limit of 1 upload an hour")
+			})
+		})
+	})
+}
+
+func TestRateLimitPanic(t *testing.T) {
+
+	Convey("Given each user is limited to one upload at a time", t, func() {
+		service, _, _ := newUploadService()
+		service.Limiter = ratelimit.New(ratelimit.NewMemoryStore(), ratelimit.Limits{Concurrent: 1}, ratelimit.Limits{})
+		panicking := service.RateLimit(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			panic("upload failed")
+		}))
+		upload := func(handler http.Handler) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest("POST", "/", strings.NewReader(exampleMultipartBody))
+			request.Header.Add("Content-Type", "multipart/form-data; boundary=----WebKitFormBoundaryezYpRsrGowIiw0K4")
+			identity := &auth.Identity{Subject: "alice", Method: auth.MethodAPIKey}
+			handler.ServeHTTP(recorder, request.WithContext(auth.WithIdentity(request.Context(), identity)))
+			return recorder
+		}
+
+		Convey("When an upload panics", func() {
+			So(func() { upload(panicking) }, ShouldPanic)
+
+			Convey("Then its upload slot is freed for the next upload", func() {
+				So(upload(service.RateLimit(http.HandlerFunc(service.Upload))).Code, ShouldEqual, 202)
+			})
+		})
+	})
+}
+
+func TestRateLimitBytes(t *testing.T) {
+
+	Convey("Given each user is limited to 64KB a day", t, func() {
+		service, _, _ := newUploadService()
+		service.Limiter = ratelimit.New(ratelimit.NewMemoryStore(), ratelimit.Limits{BytesPerDay: 64 << 10}, ratelimit.Limits{})
+		handler := service.RateLimit(http.HandlerFunc(service.CreateUpload))
+
+		upload := func(contents string) *httptest.ResponseRecorder {
+			var body bytes.Buffer
+			compressed := gzip.NewWriter(&body)
+			compressed.Write([]byte(contents))
+			compressed.Close()
+
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest("POST", "/api/v1/uploads", &body)
+			request.Header.Set("X-Filename", "AF001EW.csv")
+			request.Header.Set("Content-Encoding", "gzip")
+			identity := &auth.Identity{Subject: "alice", Method: auth.MethodAPIKey}
+			handler.ServeHTTP(recorder, request.WithContext(auth.WithIdentity(request.Context(), identity)))
+			return recorder
+		}
+
+		Convey("When a gzipped file that is smaller than the limit, but larger once decompressed, is uploaded", func() {
+			So(upload("a,b,c\n"+strings.Repeat("1,2,3\n", 20000)).Code, ShouldEqual, 202)
+
+			Convey("Then the decompressed size is counted against the limit", func() {
+				So(upload("a,b,c\n1,2,3\n").Code, ShouldEqual, 429)
+			})
+		})
+	})
+}
+
+func TestRateLimitFiles(t *testing.T) {
+
+	Convey("Given each user is limited to two uploads an hour", t, func() {
+		service, fileStore, _ := newUploadService()
+		service.Workers = worker.NewPool(1, 10)
+		service.Limiter = ratelimit.New(ratelimit.NewMemoryStore(), ratelimit.Limits{UploadsPerHour: 2}, ratelimit.Limits{})
+		handler := service.RateLimit(http.HandlerFunc(service.CreateUpload))
+
+		Convey("When three files are uploaded in one request", func() {
+			recorder := httptest.NewRecorder()
+			request := multipartFiles("north.csv", "south.csv", "east.csv")
+			identity := &auth.Identity{Subject: "alice", Method: auth.MethodAPIKey}
+			handler.ServeHTTP(recorder, request.WithContext(auth.WithIdentity(request.Context(), identity)))
+
+			Convey("Then each file counts as an upload, and the one over the limit is refused", func() {
+				So(recorder.Code, ShouldEqual, 207)
+				var response handlers.FileResults
+				So(json.Unmarshal(recorder.Body.Bytes(), &response), ShouldBeNil)
+				So(response.Results, ShouldHaveLength, 3)
+				So(response.Results[0].Status, ShouldEqual, 202)
+				So(response.Results[1].Status, ShouldEqual, 202)
+				So(response.Results[2].Status, ShouldEqual, 429)
+				So(response.Results[2].Error.Code, ShouldEqual, "rate_limited")
+				So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+				So(fileStore.Invocations, ShouldEqual, 2)
+			})
+		})
+	})
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/auth"
+	"github.com/ONSdigital/dp-dd-file-uploader/metadata"
+	"github.com/ONSdigital/dp-dd-file-uploader/worker"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// The policies for uploads found in the temp directory on startup.
+const (
+	// RecoveryResume processes complete uploads as if they had just been received.
+	RecoveryResume = "resume"
+	// RecoveryDelete removes any uploads left behind.
+	RecoveryDelete = "delete"
+	// RecoveryKeep leaves uploads in place for manual inspection.
+	RecoveryKeep = "keep"
+)
+
+const tempFilePrefix = "file-upload-"
+const manifestSuffix = ".manifest.json"
+
+// IsValidRecoveryPolicy returns true if the given policy is supported.
+func IsValidRecoveryPolicy(policy string) bool {
+	switch policy {
+	case RecoveryResume, RecoveryDelete, RecoveryKeep:
+		return true
+	}
+	return false
+}
+
+// Manifest is written alongside each complete temp file, so the upload can be recovered after a crash.
+type Manifest struct {
+	ID           string             `json:"id,omitempty"`
+	Filename     string             `json:"filename"`
+	Dataset      string             `json:"dataset,omitempty"`
+	Metadata     *metadata.Metadata `json:"metadata,omitempty"`
+	Context      string             `json:"context,omitempty"`
+	Traceparent  string             `json:"traceparent,omitempty"`
+	Identity     *auth.Identity     `json:"identity,omitempty"`
+	SourceIP     string             `json:"sourceIp,omitempty"`
+	ForwardedFor string             `json:"forwardedFor,omitempty"`
+	Size         int64              `json:"size"`
+	SHA256       string             `json:"sha256,omitempty"`
+	Created      time.Time          `json:"created"`
+}
+
+func manifestName(tempFilename string) string {
+	return tempFilename + manifestSuffix
+}
+
+// writeManifest writes the manifest for a temp file. It is written to a separate file and renamed
+// so that a manifest is only ever seen once it is complete.
+func writeManifest(tempFilename string, manifest Manifest) error {
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	partial := manifestName(tempFilename) + ".partial"
+	if err = ioutil.WriteFile(partial, b, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(partial, manifestName(tempFilename))
+}
+
+func readManifest(tempFilename string) (*Manifest, error) {
+	b, err := ioutil.ReadFile(manifestName(tempFilename))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err = json.Unmarshal(b, &manifest); err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+// removeTempFile removes a temp file along with its manifest, if it has one.
+func removeTempFile(tempFilename string, context string) {
+	for _, filename := range []string{tempFilename, manifestName(tempFilename)} {
+		err := os.Remove(filename)
+		if err != nil && !os.IsNotExist(err) {
+			log.ErrorC(context, err, log.Data{"message": "Unable to remove temporary file", "file": filename})
+		}
+	}
+}
+
+// RecoverUploads scans the temp directory for uploads left behind by a previous run of the service. It
+// must be run before the service receives any uploads, as a file still being received has no manifest.
+// Uploads are kept under the keep policy. Otherwise uploads without a manifest were not fully received,
+// so are removed, and complete uploads are resumed or removed according to the policy, with any older
+// than maxAge removed when resuming. Uploads there isn't room for in the queue are resumed in the
+// background as it empties, so the service can start receiving uploads without waiting for them.
+func (s *UploadService) RecoverUploads(policy string, maxAge time.Duration) error {
+	dir := s.TempDir
+	context := "recovery"
+
+	if !IsValidRecoveryPolicy(policy) {
+		return fmt.Errorf("Unsupported recovery policy: %q", policy)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	// Uploads there's no room for yet, in the order they were found
+	var waiting []recoveredUpload
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, tempFilePrefix) {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		data := log.Data{"file": path, "policy": policy}
+
+		if strings.HasSuffix(name, manifestSuffix) || strings.HasSuffix(name, manifestSuffix+".partial") {
+			tempFilename := strings.TrimSuffix(strings.TrimSuffix(path, ".partial"), manifestSuffix)
+			if _, err := os.Stat(tempFilename); os.IsNotExist(err) {
+				log.DebugC(context, "Removing manifest with no upload", data)
+				removeTempFile(path, context)
+			}
+			continue
+		}
+
+		if policy == RecoveryKeep {
+			log.DebugC(context, "Keeping upload left by a previous run", data)
+			continue
+		}
+
+		manifest, err := readManifest(path)
+		if err != nil || manifest.Size != entry.Size() {
+			log.DebugC(context, "Removing incomplete upload", data)
+			removeTempFile(path, context)
+			continue
+		}
+		data["filename"] = manifest.Filename
+		data["context"] = manifest.Context
+
+		if policy == RecoveryDelete || time.Since(manifest.Created) > maxAge {
+			log.DebugC(context, "Removing upload left by a previous run", data)
+			removeTempFile(path, context)
+			continue
+		}
+
+		err = s.resumeUpload(s.Workers.Submit, path, *manifest)
+		if err == worker.ErrQueueFull {
+			waiting = append(waiting, recoveredUpload{path: path, manifest: *manifest})
+			continue
+		}
+		if err != nil {
+			log.ErrorC(context, err, data)
+			continue
+		}
+		log.DebugC(context, "Resumed upload left by a previous run", data)
+	}
+
+	if len(waiting) > 0 {
+		log.DebugC(context, "Resuming uploads left by a previous run as the queue empties", log.Data{"uploads": len(waiting)})
+		go func() {
+			for _, upload := range waiting {
+				data := log.Data{"file": upload.path, "policy": policy, "filename": upload.manifest.Filename, "context": upload.manifest.Context}
+				if err := s.resumeUpload(s.Workers.SubmitWait, upload.path, upload.manifest); err != nil {
+					// Uploads not resumed before shutdown are left to be recovered by the next run
+					log.ErrorC(context, err, data)
+					continue
+				}
+				log.DebugC(context, "Resumed upload left by a previous run", data)
+			}
+		}()
+	}
+
+	return nil
+}
+
+// recoveredUpload is a complete upload left in the temp directory by a previous run.
+type recoveredUpload struct {
+	path     string
+	manifest Manifest
+}
+
+// resumeUpload queues a recovered upload for processing with the given way of submitting to the workers.
+func (s *UploadService) resumeUpload(submit func(worker.Job) error, path string, manifest Manifest) error {
+	tempFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.submitUploadWith(submit, tempFile, manifest); err != nil {
+		tempFile.Close()
+	}
+	return err
+}
+
+// TempDirUsage returns the total size of the uploads currently in the temp directory.
+func TempDirUsage(dir string) int64 {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+
+	var total int64
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), tempFilePrefix) {
+			total += entry.Size()
+		}
+	}
+	return total
+}
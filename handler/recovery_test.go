@@ -0,0 +1,91 @@
+package handlers_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/handler"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecoverUploads(t *testing.T) {
+	csv := "header_1,header_2,header_3\nvalue_1,value_2,value_3\n"
+	manifest := `{"filename": "recovered.csv", "context": "abc", "size": 51, "created": "` + time.Now().UTC().Format(time.RFC3339) + `"}`
+
+	Convey("Given a temp directory containing a complete upload and a partially received upload", t, func() {
+		dir, _ := ioutil.TempDir("", "recovery-")
+		defer os.RemoveAll(dir)
+
+		complete := filepath.Join(dir, "file-upload-1")
+		ioutil.WriteFile(complete, []byte(csv), 0600)
+		ioutil.WriteFile(complete+".manifest.json", []byte(manifest), 0600)
+		partial := filepath.Join(dir, "file-upload-2")
+		ioutil.WriteFile(partial, []byte(csv[:10]), 0600)
+
+		service, fileStore, _ := newUploadService()
+		service.TempDir = dir
+
+		Convey("When uploads are recovered with the resume policy", func() {
+			err := service.RecoverUploads(handlers.RecoveryResume, time.Hour)
+			So(err, ShouldBeNil)
+			So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+
+			Convey("Then the complete upload is processed and every temp file is removed", func() {
+				So(fileStore.Invocations, ShouldEqual, 1)
+				files, _ := ioutil.ReadDir(dir)
+				So(files, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When there are more complete uploads than there is room for in the queue", func() {
+			for _, name := range []string{"file-upload-3", "file-upload-4"} {
+				ioutil.WriteFile(filepath.Join(dir, name), []byte(csv), 0600)
+				ioutil.WriteFile(filepath.Join(dir, name)+".manifest.json", []byte(manifest), 0600)
+			}
+			release := make(chan struct{})
+			So(service.Workers.Submit(func() { <-release }), ShouldBeNil)
+
+			err := service.RecoverUploads(handlers.RecoveryResume, time.Hour)
+			So(err, ShouldBeNil)
+
+			Convey("Then recovery doesn't wait for room, and the rest are resumed as the queue empties", func() {
+				So(handlers.TempDirUsage(dir), ShouldBeGreaterThan, 0)
+				close(release)
+				deadline := time.Now().Add(5 * time.Second)
+				for handlers.TempDirUsage(dir) > 0 && time.Now().Before(deadline) {
+					time.Sleep(10 * time.Millisecond)
+				}
+				So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+				So(fileStore.Invocations, ShouldEqual, 3)
+				files, _ := ioutil.ReadDir(dir)
+				So(files, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When uploads are recovered with the delete policy", func() {
+			err := service.RecoverUploads(handlers.RecoveryDelete, time.Hour)
+			So(err, ShouldBeNil)
+			So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+
+			Convey("Then nothing is processed and every temp file is removed", func() {
+				So(fileStore.Invocations, ShouldEqual, 0)
+				files, _ := ioutil.ReadDir(dir)
+				So(files, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When uploads are recovered with the keep policy", func() {
+			err := service.RecoverUploads(handlers.RecoveryKeep, time.Hour)
+			So(err, ShouldBeNil)
+
+			Convey("Then the temp files are left in place", func() {
+				So(fileStore.Invocations, ShouldEqual, 0)
+				files, _ := ioutil.ReadDir(dir)
+				So(files, ShouldHaveLength, 3)
+			})
+		})
+	})
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/audit"
+	"github.com/ONSdigital/dp-dd-file-uploader/event"
+	"github.com/ONSdigital/dp-dd-file-uploader/metrics"
+	"github.com/ONSdigital/dp-dd-file-uploader/tracing"
+	"github.com/ONSdigital/go-ns/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var ScanFailed string = "The file could not be scanned for viruses."
+
+// InfectedError is returned when an uploaded file is found to contain a virus.
+type InfectedError struct {
+	Signature string
+}
+
+func (e *InfectedError) Error() string {
+	return fmt.Sprintf("The file is infected with %s.", e.Signature)
+}
+
+// scanFile checks the temp file for viruses before it is stored, rewinding it ready to be stored if it
+// is clean. Infected files are quarantined, and files that couldn't be scanned are rejected unless the
+// service fails open, and in both cases a file rejected event is sent and an error returned.
+func (s *UploadService) scanFile(ctx context.Context, file *os.File, manifest Manifest, record *audit.Record) error {
+	context := manifest.Context
+	started := time.Now()
+
+	ctx, span := tracing.Start(ctx, "upload.scan", trace.WithSpanKind(trace.SpanKindClient))
+	result, err := s.Scanner.Scan(ctx, file)
+	span.RecordError(err)
+	span.SetAttribute("scan.infected", result.Infected)
+	span.End()
+
+	switch {
+	case err != nil && s.ScanFailOpen:
+		log.ErrorC(context, err, log.Data{"message": "Unable to scan file, storing it unscanned", "filename": manifest.Filename})
+		record.Scan = audit.ScanFailed
+
+	case err != nil:
+		log.ErrorC(context, err, log.Data{"message": ScanFailed, "filename": manifest.Filename})
+		metrics.Uploads.WithLabelValues(metrics.OutcomeScanFailed).Inc()
+		record.Scan = audit.ScanFailed
+		record.Outcome, record.Reason = metrics.OutcomeScanFailed, err.Error()
+		s.sendRejection(manifest, ScanFailed, "")
+		return err
+
+	case result.Infected:
+		log.DebugC(context, "Rejecting infected file", log.Data{"filename": manifest.Filename, "signature": result.Signature})
+		metrics.Uploads.WithLabelValues(metrics.OutcomeInfected).Inc()
+		record.Scan = audit.ScanInfected
+		record.Outcome, record.Reason = metrics.OutcomeInfected, result.Signature
+		s.quarantine(file.Name(), manifest)
+		infected := &InfectedError{Signature: result.Signature}
+		s.sendRejection(manifest, infected.Error(), result.Signature)
+		return infected
+
+	default:
+		metrics.StageDuration.WithLabelValues(metrics.StageScan).Observe(time.Since(started).Seconds())
+		record.Scan = audit.ScanClean
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	return err
+}
+
+// quarantine moves an infected temp file to the quarantine directory, with its manifest alongside it
+// saying who uploaded it and when. The file is left to be deleted if it can't be moved.
+func (s *UploadService) quarantine(path string, manifest Manifest) {
+	if len(s.QuarantineDir) == 0 {
+		return
+	}
+
+	dest := filepath.Join(s.QuarantineDir, filepath.Base(path))
+	data := log.Data{"file": dest, "filename": manifest.Filename}
+
+	err := os.MkdirAll(s.QuarantineDir, 0700)
+	if err == nil {
+		err = moveFile(path, dest)
+	}
+	if err == nil {
+		err = writeManifest(dest, manifest)
+	}
+	if err != nil {
+		log.ErrorC(manifest.Context, err, log.Data{"message": "Unable to quarantine infected file", "filename": manifest.Filename})
+		return
+	}
+	log.DebugC(manifest.Context, "Quarantined infected file", data)
+}
+
+// moveFile renames a file, copying it if the destination is on another filesystem.
+func moveFile(src, dest string) error {
+	if err := os.Rename(src, dest); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dest)
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// sendRejection announces that an uploaded file was rejected rather than stored.
+func (s *UploadService) sendRejection(manifest Manifest, reason string, signature string) {
+	rejected := event.FileRejected{
+		Time:        time.Now().UTC().Unix(),
+		Filename:    manifest.Filename,
+		Reason:      reason,
+		Signature:   signature,
+		UploadedBy:  manifest.uploader(),
+		RequestID:   manifest.Context,
+		Traceparent: manifest.Traceparent,
+	}
+
+	if err := s.EventProducer.FileRejectedToTopic(s.RejectedTopic, rejected); err != nil {
+		log.ErrorC(manifest.Context, err, log.Data{"message": "Failed to send file rejected event", "topic": s.RejectedTopic})
+	}
+}
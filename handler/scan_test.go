@@ -0,0 +1,93 @@
+package handlers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/audit"
+	"github.com/ONSdigital/dp-dd-file-uploader/scan/scantest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUploadHandlerScanning(t *testing.T) {
+
+	Convey("Given uploads are scanned for viruses", t, func() {
+		dir, _ := ioutil.TempDir("", "quarantine-")
+		defer os.RemoveAll(dir)
+
+		service, fileStore, eventProducer := newUploadService()
+		service.Scanner = scantest.NewDummyScanner()
+		service.QuarantineDir = dir
+		service.RejectedTopic = "file-rejected"
+		service.Audit, _ = audit.Open(filepath.Join(dir, "uploads.audit"), nil)
+		defer service.Audit.Close()
+
+		upload := func(contents string) {
+			body := strings.Replace(exampleMultipartBody, "153223,,Person", contents+",,Person", 1)
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest("POST", "/", strings.NewReader(body))
+			request.Header.Add("Content-Type", "multipart/form-data; boundary=----WebKitFormBoundaryezYpRsrGowIiw0K4")
+			service.Upload(recorder, request)
+			So(recorder.Code, ShouldEqual, 202)
+			So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+		}
+		lastRecord := func() audit.Record {
+			records, _ := service.Audit.Query(audit.Filter{Limit: 1})
+			So(records, ShouldHaveLength, 1)
+			return records[0]
+		}
+
+		Convey("When a clean file is uploaded", func() {
+			upload("153223")
+
+			Convey("Then it is stored", func() {
+				So(fileStore.Invocations, ShouldEqual, 1)
+				So(eventProducer.Rejections, ShouldBeEmpty)
+				So(lastRecord().Scan, ShouldEqual, audit.ScanClean)
+			})
+		})
+
+		Convey("When an infected file is uploaded", func() {
+			upload(scantest.EICAR)
+
+			Convey("Then it is quarantined instead of stored, and a rejection event is sent", func() {
+				So(fileStore.Invocations, ShouldEqual, 0)
+				quarantined, _ := filepath.Glob(filepath.Join(dir, "file-upload-*"))
+				So(quarantined, ShouldHaveLength, 2)
+
+				So(eventProducer.Rejections, ShouldHaveLength, 1)
+				So(eventProducer.Topics, ShouldResemble, []string{"file-rejected"})
+				So(eventProducer.Rejections[0].Filename, ShouldEqual, "AF001EW.csv")
+				So(eventProducer.Rejections[0].Signature, ShouldEqual, scantest.EICARSignature)
+
+				record := lastRecord()
+				So(record.Outcome, ShouldEqual, "infected")
+				So(record.Scan, ShouldEqual, audit.ScanInfected)
+			})
+		})
+
+		Convey("When a file can't be scanned", func() {
+
+			Convey("Then it is rejected if scanning fails closed", func() {
+				upload("ScanError")
+				So(fileStore.Invocations, ShouldEqual, 0)
+				So(eventProducer.Rejections, ShouldHaveLength, 1)
+				So(lastRecord().Outcome, ShouldEqual, "scan_failed")
+			})
+
+			Convey("Then it is stored if scanning fails open", func() {
+				service.ScanFailOpen = true
+				upload("ScanError")
+				So(fileStore.Invocations, ShouldEqual, 1)
+				So(eventProducer.Rejections, ShouldBeEmpty)
+				So(lastRecord().Scan, ShouldEqual, audit.ScanFailed)
+			})
+		})
+	})
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"github.com/ONSdigital/dp-dd-file-uploader/audit"
+	"github.com/ONSdigital/dp-dd-file-uploader/aws"
+	"github.com/ONSdigital/dp-dd-file-uploader/event"
+	"github.com/ONSdigital/dp-dd-file-uploader/file"
+	"github.com/ONSdigital/dp-dd-file-uploader/metadata"
+	"github.com/ONSdigital/dp-dd-file-uploader/policy"
+	"github.com/ONSdigital/dp-dd-file-uploader/ratelimit"
+	"github.com/ONSdigital/dp-dd-file-uploader/render"
+	"github.com/ONSdigital/dp-dd-file-uploader/routing"
+	"github.com/ONSdigital/dp-dd-file-uploader/scan"
+	"github.com/ONSdigital/dp-dd-file-uploader/worker"
+)
+
+// UploadService receives uploads, writes them to a temp dir, then stores them and sends
+// file uploaded events in the background. Its handlers use only the dependencies set here,
+// so several services can run side by side in one process.
+type UploadService struct {
+	FileStore     file.Store
+	EventProducer event.Producer
+	S3Config      *aws.Config
+	Routes        *routing.Table
+	Workers       *worker.Pool
+	Renderer      render.Renderer
+	// Policy restricts who can upload where. If nil, anyone who can reach the service can upload anywhere.
+	Policy *policy.Policy
+	// Audit records every upload attempt. If nil, uploads are not audited.
+	Audit *audit.Log
+	// AuditReaders are the users and groups that can query the audit log. If empty, no one can.
+	AuditReaders []string
+	// Scanner checks files for viruses before they are stored. If nil, files are not scanned.
+	Scanner scan.Scanner
+	// ScanFailOpen stores files that couldn't be scanned, e.g. because the scanner is down, rather than rejecting them.
+	ScanFailOpen bool
+	// QuarantineDir is where infected files are moved to. If empty, they are deleted.
+	QuarantineDir string
+	// RejectedTopic is the topic file rejected events are sent to.
+	RejectedTopic string
+	// Limiter limits the uploads of each user and client IP. If nil, uploads are not limited.
+	Limiter *ratelimit.Limiter
+	// MetadataSchema checks the dataset metadata sent with each file. If nil, any metadata is accepted.
+	MetadataSchema *metadata.Schema
+	// MetadataSidecar stores dataset metadata as a JSON file alongside each upload, rather than as S3 object metadata.
+	MetadataSidecar bool
+
+	// TempDir is the directory uploads are written to before being sent to S3.
+	TempDir string
+	// MaxUploadSize is the largest file in bytes that can be uploaded. Zero means no limit.
+	MaxUploadSize int64
+	// MinFreeSpace is the free space in bytes that must remain in TempDir for an upload to be accepted.
+	MinFreeSpace int64
+	// MaxUncompressedSize is the largest a file in a zip archive can expand to, in bytes. Zero means no limit.
+	MaxUncompressedSize int64
+	// MaxCompressionRatio is the most a file in a zip archive can expand by. Zero means no limit.
+	MaxCompressionRatio int
+
+	// stopped is set once the service starts shutting down, after which new uploads are rejected.
+	stopped int32
+	// jobs are the uploads received by the service, so clients can follow them.
+	jobs jobList
+}
@@ -1,91 +1,349 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"time"
 
-	"archive/zip"
-	"github.com/ONSdigital/dp-dd-file-uploader/aws"
-	"github.com/ONSdigital/dp-dd-file-uploader/config"
+	"github.com/ONSdigital/dp-dd-file-uploader/audit"
+	"github.com/ONSdigital/dp-dd-file-uploader/auth"
+	"github.com/ONSdigital/dp-dd-file-uploader/disk"
 	"github.com/ONSdigital/dp-dd-file-uploader/event"
 	"github.com/ONSdigital/dp-dd-file-uploader/file"
+	"github.com/ONSdigital/dp-dd-file-uploader/metadata"
+	"github.com/ONSdigital/dp-dd-file-uploader/metrics"
+	"github.com/ONSdigital/dp-dd-file-uploader/policy"
 	"github.com/ONSdigital/dp-dd-file-uploader/render"
+	"github.com/ONSdigital/dp-dd-file-uploader/routing"
+	"github.com/ONSdigital/dp-dd-file-uploader/tracing"
+	"github.com/ONSdigital/dp-dd-file-uploader/worker"
 	"github.com/ONSdigital/go-ns/handlers/response"
 	"github.com/ONSdigital/go-ns/log"
+	"go.opentelemetry.io/otel/trace"
 	"io/ioutil"
 	"mime/multipart"
 	"os"
-	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
 )
 
-var FileStore file.Store
-var EventProducer event.Producer
-var S3Config *aws.Config
-
+// Response is the JSON body of an error, or of a message about an upload. Code identifies the error; for
+// uploads that are refused or fail, it is the outcome they are recorded with, e.g. "too_large".
 type Response struct {
+	Code    string `json:"code,omitempty"`
 	Message string `json:"message,omitempty"`
 }
 
 var FailedToReadRequest string = "Failed to read upload file from the request."
 var FailedToSaveFile string = "Failed to save the given file."
 var FailedToSendEvent string = "Failed to send file uploaded event."
+var ShuttingDown string = "The service is shutting down, please try again shortly."
+var TooManyUploads string = "Too many uploads are being processed, please try again shortly."
+var FileTooLarge string = "The file is larger than the maximum upload size."
+var InsufficientSpace string = "There is not enough disk space to accept uploads, please try again shortly."
+var InvalidCSRFToken string = "The upload form has expired, please reload the page and try again."
+var TooManyFiles string = fmt.Sprintf("At most %d files can be uploaded at once.", maxFiles)
+var FormValueTooLong string = fmt.Sprintf("Form fields can be at most %d bytes long.", maxFormValueLength)
+var InvalidFilename string = "The file name can't be . or .., or contain slashes or control characters."
+
+// retryAfter is the number of seconds a client is asked to wait when the service is too busy.
+const retryAfter = "30"
+
+const maxFormValueLength = 4096
+
+// maxFormFields is the number of form fields that can be sent with the files.
+const maxFormFields = 32
+
+// maxFiles is the number of files that can be uploaded in one request.
+const maxFiles = 100
+
+// UploadedByMetadata is the S3 object metadata recording the owner of the identity that uploaded a file.
+const UploadedByMetadata = "uploaded-by"
+
+// Upload receives the files from the upload form, writing each to the temp dir and queueing it to be
+// processed in the background.
+func (s *UploadService) Upload(w http.ResponseWriter, req *http.Request) {
+	results := s.receive(w, req, readMultipartFiles)
+	if results == nil {
+		return
+	}
+	if len(results) == 1 && results[0].refusal != nil {
+		results[0].refusal.write(w, req)
+		return
+	}
 
-var (
-	TooManyFilesInZip = errors.New("More than one file in zip archive")
-	InvalidFileInZip  = errors.New("Non-CSV file in zip archive")
-)
+	var files []render.FileResult
+	for _, result := range results {
+		file := render.FileResult{Filename: result.filename}
+		if result.refusal != nil {
+			file.Error = result.refusal.body.Message
+		} else if result.job != nil {
+			file.ID = result.job.id
+		}
+		files = append(files, file)
+	}
+
+	err := render.HomeResults(s.Renderer, w, req, resultsStatus(results), files)
+	if err != nil {
+		log.Error(err, log.Data{"message": "Failed to render home page"})
+	}
+}
+
+// A fileSource reads the files being uploaded from a request, passing each to receive in turn with the
+// form fields sent before it and its size, or -1 if it isn't known. Reading stops at the first error
+// receive returns, which is returned.
+type fileSource func(req *http.Request, receive func(fields map[string]string, filename string, body io.Reader, size int64) error) error
+
+// requestError is an error reading a request that can be shown to the client as it is.
+type requestError string
+
+func (e requestError) Error() string {
+	return string(e)
+}
+
+// validFilename returns whether a file can be stored under the name: a single path element that isn't
+// . or .., so it can't escape the prefix it is routed to, without control characters.
+func validFilename(name string) bool {
+	return len(name) > 0 && name != "." && name != ".." && !strings.ContainsAny(name, `/\`) &&
+		strings.IndexFunc(name, unicode.IsControl) < 0
+}
+
+// errStopReading stops reading a request once the rest of it can't be received, e.g. because a file is too large.
+var errStopReading = errors.New("Stopped reading the request")
+
+// refusal is why an upload, or one of the files in it, was refused, as returned to the client.
+type refusal struct {
+	status     int
+	retryAfter string
+	body       Response
+	// unread is set if the rest of the file wasn't read, so neither can the rest of the request be.
+	unread bool
+}
+
+func (r *refusal) write(w http.ResponseWriter, req *http.Request) {
+	if len(r.retryAfter) > 0 {
+		w.Header().Set("Retry-After", r.retryAfter)
+	}
+	writeJSONResponse(w, req, r.body, r.status)
+}
+
+// fileResult is the outcome of receiving one of the files in a request: the job processing it, or why it was refused.
+type fileResult struct {
+	filename string
+	job      *job
+	refusal  *refusal
+}
+
+// resultsStatus returns the status of a request for several files: 202 if any of them were queued,
+// otherwise the status the first was refused with.
+func resultsStatus(results []fileResult) int {
+	for _, result := range results {
+		if result.refusal == nil {
+			return http.StatusAccepted
+		}
+	}
+	return results[0].refusal.status
+}
 
-func Upload(w http.ResponseWriter, req *http.Request) {
+// receive reads the files in the request with the source, then checks each may be uploaded, writes it
+// to the temp dir and queues it to be processed in the background, returning the result for each file
+// in the order they were sent. If the whole request is refused, the error response has been written and
+// nil is returned.
+func (s *UploadService) receive(w http.ResponseWriter, req *http.Request, source fileSource) []fileResult {
 
-	if FileStore == nil {
+	if s.FileStore == nil {
 		log.ErrorR(req, errors.New("The FileStore dependency has not been configured"), nil)
-		return
+		return nil
 	}
 
-	if EventProducer == nil {
+	if s.EventProducer == nil {
 		log.ErrorR(req, errors.New("The EventProducer dependency has not been configured"), nil)
-		return
+		return nil
 	}
 
-	multipartReader, err := req.MultipartReader()
-	if err != nil {
-		handleFileReadFailure(w, req, err, nil)
-		return
+	if s.Workers == nil {
+		log.ErrorR(req, errors.New("The Workers dependency has not been configured"), nil)
+		return nil
 	}
 
-	var part *multipart.Part
-	for {
-		part, err = multipartReader.NextPart()
-		if err != nil {
-			handleFileReadFailure(w, req, err, nil)
-			return
+	// Every attempt is audited, once it is rejected here or processed in the background
+	attempt := newAttempt(req)
+	if refused := s.checkRequest(req, &attempt); refused != nil {
+		s.audit(attempt)
+		refused.write(w, req)
+		return nil
+	}
+
+	identity := auth.FromContext(req.Context())
+	var results []fileResult
+
+	_, readSpan := tracing.Start(req.Context(), "upload.read_request")
+	err := source(req, func(fields map[string]string, filename string, body io.Reader, size int64) error {
+		// Browsers must send back the CSRF token from the upload form, so other sites can't upload on their behalf
+		if len(results) == 0 && auth.NeedsCSRFToken(identity) {
+			token := req.Header.Get(auth.CSRFHeader)
+			if len(token) == 0 {
+				token = fields[auth.CSRFField]
+			}
+			if err := auth.VerifyCSRFToken(req, token); err != nil {
+				return errInvalidCSRFToken
+			}
+		}
+
+		result := s.receiveFile(req, identity, fields, filename, body, size)
+		results = append(results, result)
+		if result.refusal != nil && result.refusal.unread {
+			return errStopReading
 		}
-		if part != nil && part.FormName() == "file" {
-			break
+		return nil
+	})
+	readSpan.SetAttribute("upload.files", len(results))
+	readSpan.RecordError(err)
+	readSpan.End()
+
+	switch {
+	case err == nil, err == errStopReading:
+		return results
+	case err == errInvalidCSRFToken:
+		refused := forbidden(req, &attempt, InvalidCSRFToken, nil)
+		s.audit(attempt)
+		refused.write(w, req)
+		return nil
+	case len(results) == 0:
+		refused := fileReadFailure(req, &attempt, err, nil)
+		s.audit(attempt)
+		refused.write(w, req)
+		return nil
+	}
+
+	// The files already received are still processed, with the rest of the request refused
+	refused := fileReadFailure(req, &attempt, err, nil)
+	s.audit(attempt)
+	return append(results, fileResult{refusal: refused})
+}
+
+// errInvalidCSRFToken stops reading a request from a browser without a valid CSRF token.
+var errInvalidCSRFToken = errors.New(InvalidCSRFToken)
+
+// checkRequest checks the service can accept an upload before any of the request is read.
+func (s *UploadService) checkRequest(req *http.Request, attempt *audit.Record) *refusal {
+	if !s.accepting() {
+		log.DebugR(req, "Rejecting upload during shutdown", nil)
+		rejected(attempt, metrics.OutcomeShuttingDown, ShuttingDown)
+		return &refusal{status: http.StatusServiceUnavailable, body: Response{Code: metrics.OutcomeShuttingDown, Message: ShuttingDown}}
+	}
+
+	// Reject early if the queue is full, rather than receiving a file that can't be processed
+	if s.Workers.Full() {
+		return tooManyUploads(req, attempt, s.Workers.Stats(), nil)
+	}
+
+	if !s.enoughFreeSpace(req) {
+		rejected(attempt, metrics.OutcomeInsufficientSpace, InsufficientSpace)
+		return &refusal{status: http.StatusServiceUnavailable, retryAfter: retryAfter, body: Response{Code: metrics.OutcomeInsufficientSpace, Message: InsufficientSpace}}
+	}
+	return nil
+}
+
+// receiveFile checks a file may be uploaded, writes it to the temp dir and queues it to be processed in
+// the background, auditing the attempt if it is refused.
+func (s *UploadService) receiveFile(req *http.Request, identity *auth.Identity, fields map[string]string, filename string, body io.Reader, size int64) fileResult {
+	meta := metadata.FromFields(fields)
+	attempt := newAttempt(req)
+	attempt.Filename, attempt.Dataset = filename, meta.DatasetID
+
+	result := fileResult{filename: filename}
+	result.job, result.refusal = s.receiveFileAttempt(req, &attempt, identity, filename, meta, body, size)
+	if result.refusal != nil {
+		s.audit(attempt)
+	}
+	return result
+}
+
+func (s *UploadService) receiveFileAttempt(req *http.Request, attempt *audit.Record, identity *auth.Identity, filename string, meta metadata.Metadata, body io.Reader, size int64) (*job, *refusal) {
+	if refused := limitFile(req, attempt); refused != nil {
+		return nil, refused
+	}
+
+	if s.MetadataSchema != nil {
+		if err := s.MetadataSchema.Check(meta); err != nil {
+			return nil, invalidMetadata(req, attempt, err)
 		}
 	}
 
-	// NB: we will get an io.EOF error above if the part was not found, so part will not be nil here
-	defer part.Close()
+	// S3 refuses objects with too much metadata, which wouldn't be found out until the file had been received
+	objectMetadata := s.objectMetadata(exampleTraceparent, Manifest{Identity: identity, Metadata: &meta})
+	if metadataSize := metadata.ObjectMetadataSize(objectMetadata); metadataSize > metadata.MaxObjectMetadataSize {
+		err := &metadata.InvalidError{Problems: []string{fmt.Sprintf("The metadata is too large to store with the file, at %d bytes of the %d allowed.", metadataSize, metadata.MaxObjectMetadataSize)}}
+		return nil, invalidMetadata(req, attempt, err)
+	}
+
+	// A file known to be over the limit will always be rejected
+	if s.MaxUploadSize > 0 && size > s.MaxUploadSize {
+		return nil, s.fileTooLarge(req, attempt, size, nil)
+	}
 
-	tempFile, err := ioutil.TempFile(config.UploadTempDir, "file-upload-")
+	// Check the uploader may upload to the destination before receiving the file
+	grant, err := s.authorize(identity, filename, meta.DatasetID)
+	if err == nil {
+		err = grant.CheckSize(size)
+	}
 	if err != nil {
-		handleFileReadFailure(w, req, err, tempFile)
-		return
+		return nil, forbidden(req, attempt, err.Error(), nil)
+	}
+
+	tempFile, err := ioutil.TempFile(s.TempDir, tempFilePrefix)
+	if err != nil {
+		return nil, fileReadFailure(req, attempt, err, tempFile)
 	}
 	log.DebugR(req, "Writing file upload to temporary file", log.Data{
 		"filename": tempFile.Name(),
+		"upload":   filename,
 	})
 
-	bytesWritten, err := io.Copy(tempFile, part)
+	// Read at most one byte more than the limit, to detect files that are too large without storing them
+	reader := body
+	if limit := uploadLimit(s.MaxUploadSize, grant.MaxSize); limit > 0 {
+		reader = io.LimitReader(body, limit+1)
+	}
+
+	receiveStarted := time.Now()
+	_, writeSpan := tracing.Start(req.Context(), "upload.write_temp_file")
+	sha := sha256.New()
+	bytesWritten, err := io.Copy(io.MultiWriter(tempFile, sha), reader)
+	attempt.Size, attempt.SHA256 = bytesWritten, hex.EncodeToString(sha.Sum(nil))
+	limitedUploadFrom(req.Context()).receive(bytesWritten)
+	writeSpan.SetAttribute("upload.filename", filename)
+	writeSpan.SetAttribute("upload.bytes", bytesWritten)
+	writeSpan.RecordError(err)
+	writeSpan.End()
 	if err != nil {
-		handleFileReadFailure(w, req, err, tempFile)
-		return
+		tempFile.Close()
+		return nil, fileReadFailure(req, attempt, err, tempFile)
+	}
+	if s.MaxUploadSize > 0 && bytesWritten > s.MaxUploadSize {
+		tempFile.Close()
+		refused := s.fileTooLarge(req, attempt, bytesWritten, tempFile)
+		refused.unread = true
+		return nil, refused
 	}
+	if err = grant.CheckSize(bytesWritten); err != nil {
+		tempFile.Close()
+		refused := forbidden(req, attempt, err.Error(), tempFile)
+		refused.unread = true
+		return nil, refused
+	}
+	metrics.StageDuration.WithLabelValues(metrics.StageReceive).Observe(time.Since(receiveStarted).Seconds())
+	metrics.BytesReceived.Add(float64(bytesWritten))
 	log.DebugR(req, "Successfully wrote file to temporary storage", log.Data{
 		"size": bytesWritten,
 	})
@@ -93,133 +351,647 @@ func Upload(w http.ResponseWriter, req *http.Request) {
 	// Rewind file to start ready to read and stream to S3
 	_, err = tempFile.Seek(0, io.SeekStart)
 	if err != nil {
-		handleFileReadFailure(w, req, err, tempFile)
-		return
+		tempFile.Close()
+		return nil, fileReadFailure(req, attempt, err, tempFile)
 	}
 
-	// Continue upload to S3 in a separate goroutine
-	go uploadFileToS3(tempFile, part.FileName(), log.Context(req))
+	// Record what the temp file is, so the upload can be recovered if the service stops before it is processed
+	manifest := Manifest{
+		ID:           newUploadID(),
+		Filename:     filename,
+		Dataset:      meta.DatasetID,
+		Context:      log.Context(req),
+		Traceparent:  tracing.FromContext(req.Context()).Traceparent(),
+		Identity:     identity,
+		SourceIP:     attempt.SourceIP,
+		ForwardedFor: attempt.ForwardedFor,
+		Size:         bytesWritten,
+		SHA256:       attempt.SHA256,
+		Created:      time.Now().UTC(),
+	}
+	if !meta.IsZero() {
+		manifest.Metadata = &meta
+	}
+	err = writeManifest(tempFile.Name(), manifest)
+	if err != nil {
+		log.ErrorR(req, err, log.Data{"message": "Failed to write upload manifest, the upload will not be recoverable"})
+	}
 
-	err = render.Home(w)
+	// Continue upload to S3 in the background, once a worker is free
+	j, err := s.submitUpload(tempFile, manifest)
 	if err != nil {
-		log.Error(err, log.Data{"message": "Failed to render home page"})
+		tempFile.Close()
+		return nil, tooManyUploads(req, attempt, s.Workers.Stats(), tempFile)
 	}
+	log.DebugR(req, "Queued file for upload to S3", log.Data{"workers": s.Workers.Stats(), "uploadedBy": manifest.uploadedBy(), "id": manifest.ID})
+	return j, nil
 }
 
-func uploadFileToS3(file *os.File, filename string, context string) {
-	defer (func() {
-		err := file.Close()
+// readMultipartFiles reads the files sent as parts named "file" of a multipart form, with the form fields
+// preceding each. Form fields used for routing or checking a file must precede it, as each file is
+// streamed as it is read, and apply to every file after them.
+func readMultipartFiles(req *http.Request, receive func(fields map[string]string, filename string, body io.Reader, size int64) error) error {
+	multipartReader, err := req.MultipartReader()
+	if err != nil {
+		return err
+	}
+
+	fields := make(map[string]string)
+	files := 0
+	for {
+		part, err := multipartReader.NextPart()
+		if err == io.EOF && files > 0 {
+			return nil
+		}
 		if err != nil {
-			log.ErrorC(context, err, log.Data{"filename": file.Name()})
+			return err
+		}
+
+		if part.FormName() == "file" {
+			if files++; files > maxFiles {
+				return requestError(TooManyFiles)
+			}
+			if !validFilename(part.FileName()) {
+				part.Close()
+				return requestError(InvalidFilename)
+			}
+			err = receive(fields, part.FileName(), part, -1)
+			part.Close()
+			if err != nil {
+				return err
+			}
+			continue
 		}
 
-		err = os.Remove(file.Name())
+		if _, ok := fields[part.FormName()]; !ok && len(fields) == maxFormFields {
+			return fmt.Errorf("More than %d form fields before the file", maxFormFields)
+		}
+		fields[part.FormName()], err = readFormValue(part)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// submitUpload queues a received file to be stored and announced in the background.
+// The background work is traced as part of the request that received the file.
+// The upload can be followed, and cancelled, through the job returned.
+func (s *UploadService) submitUpload(tempFile *os.File, manifest Manifest) (*job, error) {
+	return s.submitUploadWith(s.Workers.Submit, tempFile, manifest)
+}
+
+// submitUploadWith queues a received file like submitUpload, with the given way of submitting to the workers.
+func (s *UploadService) submitUploadWith(submit func(worker.Job) error, tempFile *os.File, manifest Manifest) (*job, error) {
+	if len(manifest.ID) == 0 {
+		manifest.ID = newUploadID()
+	}
+	j := newJob(manifest)
+	queued := time.Now()
+	metrics.BackgroundJobs.Inc()
+
+	err := submit(func() {
+		defer metrics.BackgroundJobs.Dec()
+		metrics.StageDuration.WithLabelValues(metrics.StageQueued).Observe(time.Since(queued).Seconds())
+
+		if !j.start() {
+			s.discardCancelled(tempFile, manifest)
+			return
+		}
+
+		ctx, span := tracing.Start(tracing.ContextWithTraceparent(j.ctx, manifest.Traceparent), "upload.process")
+		defer span.End()
+		span.SetAttribute("upload.id", manifest.ID)
+		span.SetAttribute("upload.filename", manifest.Filename)
+		span.SetAttribute("upload.dataset", manifest.Dataset)
+		span.SetAttribute("request.id", manifest.Context)
+		span.SetAttribute("upload.uploaded_by", manifest.uploadedBy())
+		span.SetAttribute("upload.queued_ms", int64(time.Since(queued)/time.Millisecond))
+
+		span.RecordError(s.uploadFileToS3(ctx, tempFile, manifest, j))
+	})
+	if err != nil {
+		metrics.BackgroundJobs.Dec()
+		j.cancel()
+		return nil, err
+	}
+	s.jobs.add(j)
+	return j, nil
+}
+
+// discardCancelled removes an upload that was cancelled while it was queued.
+func (s *UploadService) discardCancelled(file *os.File, manifest Manifest) {
+	log.DebugC(manifest.Context, "Discarding cancelled upload", log.Data{"filename": manifest.Filename, "id": manifest.ID})
+	if err := file.Close(); err != nil {
+		log.ErrorC(manifest.Context, err, log.Data{"filename": file.Name()})
+	}
+	removeTempFile(file.Name(), manifest.Context)
+
+	record := manifest.auditRecord()
+	metrics.Uploads.WithLabelValues(metrics.OutcomeCancelled).Inc()
+	record.Outcome, record.Reason = metrics.OutcomeCancelled, UploadCancelled
+	s.audit(record)
+}
+
+// uploadFileToS3 sends a received file to S3 and announces it on Kafka, returning any error for the trace.
+// The job, if there is one, is kept up to date with where the file is going and how processing ended.
+func (s *UploadService) uploadFileToS3(ctx context.Context, file *os.File, manifest Manifest, j *job) error {
+	filename, dataset, context := manifest.Filename, manifest.Dataset, manifest.Context
+	record := manifest.auditRecord()
+	defer func() {
+		s.audit(record)
+		j.finish(record)
+	}()
+	defer (func() {
+		err := file.Close()
 		if err != nil {
 			log.ErrorC(context, err, log.Data{"filename": file.Name()})
 		}
+
+		removeTempFile(file.Name(), context)
 	})()
 
+	if s.Scanner != nil {
+		j.update(func(status *UploadStatus) { status.Stage = StageScanning })
+		if err := s.scanFile(ctx, file, manifest, &record); err != nil {
+			return err
+		}
+	}
+
 	log.DebugC(context, "Streaming file to s3", log.Data{"filename": filename})
 
 	var reader io.Reader = file
+	format := routing.Format(filename)
 
-	if filepath.Ext(filename) == ".zip" {
+	var unzipped *zipEntryReader
+	if format == "zip" {
 		log.DebugC(context, "Zip file detected - decompressing during upload", nil)
+		j.update(func(status *UploadStatus) { status.Stage = StageDecompressing })
+		_, span := tracing.Start(ctx, "upload.decompress")
 		var err error
-		reader, filename, err = decompressZipFile(file, context)
+		unzipped, filename, err = s.decompressZipFile(file, context)
+		span.RecordError(err)
+		span.End()
+		if err != nil {
+			s.rejectZip(manifest, &record, err)
+			return err
+		}
+		reader = unzipped
+	}
+
+	route := s.Routes.Match(filename, format, dataset)
+	key := route.Key(filename)
+	log.DebugC(context, "Routing upload", log.Data{"key": key, "topic": route.Topic, "dataset": dataset})
+	record.Key, record.Topic = key, route.Topic
+	j.update(func(status *UploadStatus) {
+		status.Key, status.S3URL = key, s.S3Config.GetS3FileURL(key)
+		status.Stage = StageStoring
+	})
+
+	// A zip file is routed by the file inside it, so may be going somewhere other than was checked when it was received
+	if s.Policy != nil {
+		_, err := s.Policy.Authorize(manifest.Identity, policy.Upload{Prefix: route.Prefix, Topic: route.Topic, Dataset: dataset})
+		if err != nil {
+			log.ErrorC(context, err, log.Data{"message": "Uploader is not allowed to upload to the destination", "key": key, "topic": route.Topic})
+			metrics.Uploads.WithLabelValues(metrics.OutcomeForbidden).Inc()
+			record.Outcome, record.Reason = metrics.OutcomeForbidden, err.Error()
+			return err
+		}
+
+		// The policy says where an uploader can store files, but not whose files they can replace there
+		owner, err := s.owner(key)
 		if err != nil {
-			log.ErrorC(context, err, log.Data{
-				"message": "Unable to decode zip archive",
-			})
+			log.ErrorC(context, err, log.Data{"message": "Failed to check who uploaded the file being replaced", "key": key})
+			metrics.Uploads.WithLabelValues(metrics.OutcomeStoreFailed).Inc()
+			record.Outcome, record.Reason = metrics.OutcomeStoreFailed, err.Error()
+			return err
+		}
+		if owner != nil && *owner != manifest.owner() {
+			err = replaceDenied(key)
+			log.ErrorC(context, err, log.Data{"message": "Uploader is not allowed to replace the file", "key": key, "uploaded_by": *owner})
+			metrics.Uploads.WithLabelValues(metrics.OutcomeForbidden).Inc()
+			record.Outcome, record.Reason = metrics.OutcomeForbidden, err.Error()
+			return err
 		}
 	}
 
-	err := FileStore.SaveFile(CreateValidatingReader(reader, context), filename)
+	storeStarted := time.Now()
+	_, validateSpan := tracing.Start(ctx, "upload.validate")
+	_, storeSpan := tracing.Start(ctx, "s3.upload", trace.WithSpanKind(trace.SpanKindClient))
+	storeSpan.SetAttribute("s3.key", key)
+	validatingReader, validation := newValidatingReader(&contextReader{ctx: ctx, reader: reader}, context, validateSpan, func(rows int64) {
+		j.update(func(status *UploadStatus) { status.Rows = rows })
+	})
+	counter := &countingReader{reader: validatingReader}
+	if j != nil {
+		counter.progress = func(count int64) {
+			j.update(func(status *UploadStatus) { status.BytesStored = count })
+		}
+	}
+	err := s.FileStore.SaveFile(counter, key, s.objectMetadata(storeSpan.Traceparent(), manifest))
+	storeSpan.SetAttribute("s3.bytes", counter.count)
+	storeSpan.RecordError(err)
+	storeSpan.End()
+	if err != nil && ctx.Err() != nil {
+		log.DebugC(context, "Upload cancelled while it was being stored", log.Data{"key": key})
+		metrics.Uploads.WithLabelValues(metrics.OutcomeCancelled).Inc()
+		record.Outcome, record.Reason = metrics.OutcomeCancelled, UploadCancelled
+		return err
+	}
+	record.Validation = validation.status()
 	if err != nil {
+		if unzipped != nil && unzipped.err != nil {
+			s.rejectZip(manifest, &record, unzipped.err)
+			return unzipped.err
+		}
 		log.ErrorC(context, err, log.Data{"message": FailedToSaveFile})
-		return
+		metrics.Uploads.WithLabelValues(metrics.OutcomeStoreFailed).Inc()
+		record.Outcome, record.Reason = metrics.OutcomeStoreFailed, err.Error()
+		return err
+	}
+	if manifest.Metadata != nil && s.MetadataSidecar {
+		if err = s.saveMetadataSidecar(ctx, key, manifest); err != nil {
+			log.ErrorC(context, err, log.Data{"message": "Failed to save the metadata of the file", "key": key + metadata.SidecarSuffix})
+			metrics.Uploads.WithLabelValues(metrics.OutcomeStoreFailed).Inc()
+			record.Outcome, record.Reason = metrics.OutcomeStoreFailed, err.Error()
+			return err
+		}
 	}
+	metrics.StageDuration.WithLabelValues(metrics.StageStore).Observe(time.Since(storeStarted).Seconds())
+	metrics.BytesStored.Add(float64(counter.count))
+
+	eventStarted := time.Now()
+	j.update(func(status *UploadStatus) { status.Stage = StageAnnouncing })
+	_, sendSpan := tracing.Start(ctx, "kafka.send", trace.WithSpanKind(trace.SpanKindProducer))
+	sendSpan.SetAttribute("kafka.topic", route.Topic)
 
 	uploadedEvent := event.FileUploaded{
-		Time:  time.Now().UTC().Unix(),
-		S3URL: S3Config.GetS3FileURL(filename),
+		Time:        time.Now().UTC().Unix(),
+		S3URL:       s.S3Config.GetS3FileURL(key),
+		UploadedBy:  manifest.uploader(),
+		Metadata:    manifest.Metadata,
+		RequestID:   context,
+		Traceparent: sendSpan.Traceparent(),
 	}
 
-	err = EventProducer.FileUploaded(uploadedEvent)
+	receipt, err := s.sendEvent(route.Topic, uploadedEvent)
+	sendSpan.RecordError(err)
+	sendSpan.End()
 	if err != nil {
 		log.ErrorC(context, err, log.Data{"message": FailedToSendEvent})
-		return
+		metrics.Uploads.WithLabelValues(metrics.OutcomeEventFailed).Inc()
+		record.Outcome, record.Reason = metrics.OutcomeEventFailed, err.Error()
+		return err
+	}
+	metrics.StageDuration.WithLabelValues(metrics.StageEvent).Observe(time.Since(eventStarted).Seconds())
+	metrics.Uploads.WithLabelValues(metrics.OutcomeCompleted).Inc()
+	record.Outcome = metrics.OutcomeCompleted
+	if receipt != nil {
+		record.EventPartition, record.EventOffset = &receipt.Partition, &receipt.Offset
+	}
+	return nil
+}
+
+// saveMetadataSidecar stores the dataset metadata of an upload as a JSON file alongside it.
+func (s *UploadService) saveMetadataSidecar(ctx context.Context, key string, manifest Manifest) error {
+	b, err := json.Marshal(manifest.Metadata)
+	if err != nil {
+		return err
+	}
+
+	_, span := tracing.Start(ctx, "s3.upload", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	span.SetAttribute("s3.key", key+metadata.SidecarSuffix)
+	err = s.FileStore.SaveFile(bytes.NewReader(b), key+metadata.SidecarSuffix, map[string]string{
+		tracing.TraceparentHeader: span.Traceparent(),
+		UploadedByMetadata:        manifest.owner(),
+	})
+	span.RecordError(err)
+	return err
+}
+
+// exampleTraceparent is as long as the traceparent stored with each file, for working out the size of its metadata.
+const exampleTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// objectMetadata returns the S3 object metadata a file is stored with: its trace, who uploaded it and,
+// unless it is stored in a sidecar file, its dataset metadata.
+func (s *UploadService) objectMetadata(traceparent string, manifest Manifest) map[string]string {
+	objectMetadata := map[string]string{
+		tracing.TraceparentHeader: traceparent,
+		UploadedByMetadata:        manifest.owner(),
+	}
+	if manifest.Metadata != nil && !s.MetadataSidecar {
+		for name, value := range manifest.Metadata.ObjectMetadata() {
+			objectMetadata[name] = value
+		}
+	}
+	return objectMetadata
+}
+
+// uploadedBy returns the subject of the identity that uploaded the file, or an empty string if
+// authentication is disabled.
+func (m Manifest) uploadedBy() string {
+	if m.Identity == nil {
+		return ""
+	}
+	return m.Identity.Subject
+}
+
+// owner returns the owner of the identity that uploaded the file, as recorded with it and compared
+// against whoever replaces or follows it, or an empty string if authentication is disabled.
+func (m Manifest) owner() string {
+	if m.Identity == nil {
+		return ""
+	}
+	return m.Identity.Owner()
+}
+
+// uploader returns the identity that uploaded the file as it is announced in the event.
+func (m Manifest) uploader() *event.Uploader {
+	if m.Identity == nil {
+		return nil
 	}
+	return &event.Uploader{Subject: m.Identity.Subject, Name: m.Identity.Name, Method: m.Identity.Method}
 }
 
-func handleFileReadFailure(w http.ResponseWriter, req *http.Request, err error, tempFile *os.File) {
+// contextReader stops reading once its context is done, so an upload can be cancelled part way through.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
+
+// byteProgressInterval is how many bytes are read between each report of progress.
+const byteProgressInterval = 1 << 20
+
+// countingReader counts the bytes read through it, passing the count so far to progress, if set, every
+// byteProgressInterval bytes and at the end.
+type countingReader struct {
+	reader   io.Reader
+	count    int64
+	reported int64
+	progress func(count int64)
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	if r.progress != nil && r.count > r.reported && (r.count-r.reported >= byteProgressInterval || err == io.EOF) {
+		r.reported = r.count
+		r.progress(r.count)
+	}
+	return n, err
+}
+
+// fileReadFailure refuses a file that couldn't be read from the request.
+func fileReadFailure(req *http.Request, attempt *audit.Record, err error, tempFile *os.File) *refusal {
 	log.ErrorR(req, err, log.Data{"message": FailedToReadRequest})
-	err = response.WriteJSON(w, Response{Message: FailedToReadRequest}, http.StatusBadRequest)
+	rejected(attempt, metrics.OutcomeBadRequest, err.Error())
+	message := FailedToReadRequest
+	if e, ok := err.(requestError); ok {
+		message = e.Error()
+	}
+
+	if tempFile != nil {
+		removeTempFile(tempFile.Name(), log.Context(req))
+	}
+	return &refusal{status: http.StatusBadRequest, body: Response{Code: metrics.OutcomeBadRequest, Message: message}}
+}
+
+// readFormValue reads the value of a non-file form field, refusing any longer than a sensible maximum length.
+func readFormValue(part *multipart.Part) (string, error) {
+	value, err := ioutil.ReadAll(io.LimitReader(part, maxFormValueLength+1))
 	if err != nil {
-		log.ErrorR(req, err, log.Data{"message": "Failed to write JSON response"})
-		w.WriteHeader(http.StatusBadRequest)
+		return "", err
+	}
+	if len(value) > maxFormValueLength {
+		return "", requestError(FormValueTooLong)
+	}
+	return strings.TrimSpace(string(value)), nil
+}
+
+// enoughFreeSpace checks there will be at least the minimum free space left in the temp dir after
+// receiving the request. Uploads are allowed if the free space can't be determined.
+func (s *UploadService) enoughFreeSpace(req *http.Request) bool {
+	if s.MinFreeSpace <= 0 {
+		return true
+	}
+
+	free, err := disk.FreeSpace(s.TempDir)
+	if err != nil {
+		log.ErrorR(req, err, log.Data{"message": "Unable to determine free space", "dir": s.TempDir})
+		return true
+	}
+
+	required := uint64(s.MinFreeSpace)
+	if req.ContentLength > 0 {
+		required += uint64(req.ContentLength)
 	}
 
+	if free < required {
+		log.DebugR(req, "Rejecting upload as there is not enough free space", log.Data{
+			"dir":      s.TempDir,
+			"free":     free,
+			"required": required,
+		})
+		return false
+	}
+	return true
+}
+
+func (s *UploadService) fileTooLarge(req *http.Request, attempt *audit.Record, size int64, tempFile *os.File) *refusal {
+	log.DebugR(req, "Rejecting upload larger than the maximum size", log.Data{
+		"size":          size,
+		"maxUploadSize": s.MaxUploadSize,
+	})
+	rejected(attempt, metrics.OutcomeTooLarge, FileTooLarge)
+
 	if tempFile != nil {
-		err = os.Remove(tempFile.Name())
-		if err != nil {
-			log.ErrorR(req, err, log.Data{"message": "Unable to remove temporary file", "file": tempFile.Name()})
-		}
+		removeTempFile(tempFile.Name(), log.Context(req))
 	}
+	return &refusal{status: http.StatusRequestEntityTooLarge, body: Response{Code: metrics.OutcomeTooLarge, Message: FileTooLarge}}
 }
 
-func decompressZipFile(file *os.File, context string) (reader io.Reader, filename string, err error) {
-	stat, err := file.Stat()
+// authorize checks the policy allows the identity to upload the file to where it will be routed.
+func (s *UploadService) authorize(identity *auth.Identity, filename string, dataset string) (policy.Grant, error) {
+	if s.Policy == nil {
+		return policy.Grant{}, nil
+	}
+	route := s.Routes.Match(filename, routing.Format(filename), dataset)
+	grant, err := s.Policy.Authorize(identity, policy.Upload{Prefix: route.Prefix, Topic: route.Topic, Dataset: dataset})
 	if err != nil {
-		log.ErrorC(context, err, log.Data{"message": "Unable to determine file size"})
-		return
+		return grant, err
+	}
+
+	// Failing to read the file being replaced is left to be retried when the upload is stored
+	key := route.Key(filename)
+	if owner, err := s.owner(key); err == nil && owner != nil && *owner != (Manifest{Identity: identity}).owner() {
+		return grant, replaceDenied(key)
+	}
+	return grant, nil
+}
+
+// replaceDenied is the error for an uploader trying to replace a file someone else uploaded.
+func replaceDenied(key string) error {
+	return &policy.DeniedError{Reason: fmt.Sprintf("%s was uploaded by someone else, so it can't be replaced.", key)}
+}
+
+// owner returns the owner of the identity that uploaded the file already stored at the key, or nil if there isn't
+// one, it wasn't recorded or the file store can't read it back.
+func (s *UploadService) owner(key string) (*string, error) {
+	reader, ok := s.FileStore.(file.Reader)
+	if !ok {
+		return nil, nil
+	}
+	existing, err := reader.FileMetadata(key)
+	if err == file.ErrNotFound {
+		return nil, nil
 	}
-	zipReader, err := zip.NewReader(file, stat.Size())
 	if err != nil {
-		return
+		return nil, err
+	}
+	owner, ok := existing[UploadedByMetadata]
+	if !ok {
+		return nil, nil
 	}
+	return &owner, nil
+}
 
-	if len(zipReader.File) != 1 {
-		err = TooManyFilesInZip
-		return
+// uploadLimit returns the smaller of two size limits, where zero means no limit.
+func uploadLimit(a int64, b int64) int64 {
+	if a == 0 || (b > 0 && b < a) {
+		return b
 	}
+	return a
+}
 
-	entry := zipReader.File[0]
-	filename = entry.Name
-	if filepath.Ext(filename) != ".csv" {
-		err = InvalidFileInZip
-		return
+func forbidden(req *http.Request, attempt *audit.Record, reason string, tempFile *os.File) *refusal {
+	log.DebugR(req, "Rejecting forbidden upload", log.Data{"reason": reason})
+	rejected(attempt, metrics.OutcomeForbidden, reason)
+
+	if tempFile != nil {
+		removeTempFile(tempFile.Name(), log.Context(req))
 	}
+	return &refusal{status: http.StatusForbidden, body: Response{Code: metrics.OutcomeForbidden, Message: reason}}
+}
 
-	reader, err = entry.Open()
-	return
+func invalidMetadata(req *http.Request, attempt *audit.Record, err error) *refusal {
+	log.DebugR(req, "Rejecting upload with invalid metadata", log.Data{"reason": err.Error()})
+	rejected(attempt, metrics.OutcomeInvalidMetadata, err.Error())
+	return &refusal{status: http.StatusBadRequest, body: Response{Code: metrics.OutcomeInvalidMetadata, Message: err.Error()}}
+}
+
+func tooManyUploads(req *http.Request, attempt *audit.Record, stats worker.Stats, tempFile *os.File) *refusal {
+	log.DebugR(req, "Rejecting upload as the worker queue is full", log.Data{"workers": stats})
+	rejected(attempt, metrics.OutcomeBusy, TooManyUploads)
+
+	if tempFile != nil {
+		removeTempFile(tempFile.Name(), log.Context(req))
+	}
+	return &refusal{status: http.StatusServiceUnavailable, retryAfter: retryAfter, body: Response{Code: metrics.OutcomeBusy, Message: TooManyUploads}}
+}
+
+func writeJSONResponse(w http.ResponseWriter, req *http.Request, body interface{}, status int) {
+	err := response.WriteJSON(w, body, status)
+	if err != nil {
+		log.ErrorR(req, err, log.Data{"message": "Failed to write JSON response"})
+		w.WriteHeader(status)
+	}
 }
 
 // CreateValidatingReader creates a reader that will return an error if the stream being read does not represent a valid csv file.
 func CreateValidatingReader(sourceReader io.Reader, context string) io.Reader {
+	reader, _ := newValidatingReader(sourceReader, context, nil, nil)
+	return reader
+}
+
+// rowProgressInterval is how many rows are validated between each report of progress.
+const rowProgressInterval = 1000
+
+// newValidatingReader creates a validating reader, ending the given span once validation has finished.
+// If progress is set, it is passed the number of valid rows every rowProgressInterval rows and at the end.
+func newValidatingReader(sourceReader io.Reader, context string, span *tracing.Span, progress func(rows int64)) (io.Reader, *validationResult) {
+	if progress == nil {
+		progress = func(int64) {}
+	}
+	result := &validationResult{}
 	pipeReader, pipeWriter := io.Pipe()
 	tee := io.TeeReader(sourceReader, pipeWriter)
 	csvReader := csv.NewReader(tee)
-	// create a goroutine that will read from the csvReader and close the pipe if an error is returned by csvReader, or the number of fields isn't correct
+	// create a goroutine that will read from the csvReader and close the pipe if an error is returned by csvReader, or the number of fields isn't correct,
+	// ending the span first so it has ended by the time the file has been read
 	go func() {
 		rowCount := 0
 		for {
 			rowCount++
 			row, err := csvReader.Read()
 			if err != nil {
+				metrics.RowsValidated.Add(float64(rowCount - 1))
+				span.SetAttribute("csv.rows", rowCount-1)
+				progress(int64(rowCount - 1))
+				if err != io.EOF {
+					span.RecordError(err)
+				}
+				span.End()
+				result.finish(err)
 				pipeWriter.CloseWithError(err)
 				log.DebugC(context, "Finished saving file to S3", log.Data{"rowCount": rowCount, "err": err})
 				return
 			}
 			if len(row)%3 != 0 {
+				metrics.RowsValidated.Add(float64(rowCount - 1))
+				span.SetAttribute("csv.rows", rowCount-1)
+				progress(int64(rowCount - 1))
 				message := fmt.Sprintf("Wrong number of fields in file at row %d - must be a multiple of 3, but was %d", rowCount, len(row))
+				span.RecordError(errors.New(message))
+				span.End()
+				result.finish(errors.New(message))
 				pipeWriter.CloseWithError(errors.New(message))
 				return
 			}
+			if rowCount%rowProgressInterval == 0 {
+				progress(int64(rowCount))
+			}
 			if rowCount%50000 == 0 {
 				log.DebugC(context, "Saving file to S3", log.Data{"rowCount": rowCount})
 			}
 		}
 	}()
-	return pipeReader
+	return pipeReader, result
+}
+
+// validationResult is the outcome of validating a file, once it has been read to the end or found to be invalid.
+type validationResult struct {
+	mutex sync.Mutex
+	done  bool
+	err   error
+}
+
+func (v *validationResult) finish(err error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.done = true
+	if err != io.EOF {
+		v.err = err
+	}
+}
+
+// status returns whether the file passed or failed validation, or an empty string if it wasn't read
+// far enough to tell, e.g. because the upload to S3 failed.
+func (v *validationResult) status() string {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	switch {
+	case !v.done:
+		return ""
+	case v.err != nil:
+		return audit.ValidationFailed
+	default:
+		return audit.ValidationPassed
+	}
 }
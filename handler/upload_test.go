@@ -4,8 +4,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"html/template"
+	"html"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -13,14 +14,21 @@ import (
 	"strings"
 	"testing"
 
-	"github.com/ONSdigital/dp-dd-file-uploader/assets"
+	"github.com/ONSdigital/dp-dd-file-uploader/auth"
 	"github.com/ONSdigital/dp-dd-file-uploader/aws"
+	"github.com/ONSdigital/dp-dd-file-uploader/event"
 	"github.com/ONSdigital/dp-dd-file-uploader/event/eventtest"
 	"github.com/ONSdigital/dp-dd-file-uploader/file/filetest"
 	"github.com/ONSdigital/dp-dd-file-uploader/handler"
+	"github.com/ONSdigital/dp-dd-file-uploader/metadata"
+	"github.com/ONSdigital/dp-dd-file-uploader/policy"
 	"github.com/ONSdigital/dp-dd-file-uploader/render"
+	"github.com/ONSdigital/dp-dd-file-uploader/routing"
+	"github.com/ONSdigital/dp-dd-file-uploader/tracing"
+	"github.com/ONSdigital/dp-dd-file-uploader/tracing/tracingtest"
+	"github.com/ONSdigital/dp-dd-file-uploader/worker"
 	. "github.com/smartystreets/goconvey/convey"
-	unrolled "github.com/unrolled/render"
+	"go.opentelemetry.io/otel/trace"
 	"time"
 )
 
@@ -42,32 +50,34 @@ Upload
 
 `
 
-func TestUploadHandler(t *testing.T) {
-	url, _ := url.Parse("s3://bucket1/dir/test.csv")
-	handlers.S3Config = aws.NewAWSConfig("region1", url)
+// newUploadService creates an upload service with dummy dependencies, writing uploads to the OS temp dir.
+func newUploadService() (*handlers.UploadService, *filetest.DummyFileStore, *eventtest.DummyEventProducer) {
+	url, _ := url.Parse("s3://bucket1/dir")
+	fileStore := filetest.NewDummyFileStore()
+	eventProducer := eventtest.NewDummyEventProducer()
+
+	service := &handlers.UploadService{
+		FileStore:     fileStore,
+		EventProducer: eventProducer,
+		S3Config:      aws.NewAWSConfig("region1", url),
+		Workers:       worker.NewPool(1, 1),
+		Renderer:      render.New(),
+		TempDir:       os.TempDir(),
+	}
+	return service, fileStore, eventProducer
+}
 
-	render.Renderer = unrolled.New(unrolled.Options{
-		Asset:      assets.Asset,
-		AssetNames: assets.AssetNames,
-		Funcs: []template.FuncMap{{
-			"safeHTML": func(s string) template.HTML {
-				return template.HTML(s)
-			},
-		}},
-	})
+func TestUploadHandler(t *testing.T) {
 
 	Convey("Handler returns 400 status code response when request body is empty", t, func() {
-		fileStore := filetest.NewDummyFileStore()
-		handlers.FileStore = fileStore
-		eventProducer := eventtest.NewDummyEventProducer()
-		handlers.EventProducer = eventProducer
+		service, _, _ := newUploadService()
 
 		recorder := httptest.NewRecorder()
 		rdr := bytes.NewReader([]byte(``))
 		request, err := http.NewRequest("POST", "/", rdr)
 		So(err, ShouldBeNil)
 
-		handlers.Upload(recorder, request)
+		service.Upload(recorder, request)
 
 		var response = &handlers.Response{}
 		json.Unmarshal([]byte(recorder.Body.String()), response)
@@ -77,10 +87,7 @@ func TestUploadHandler(t *testing.T) {
 	})
 
 	Convey("Handler returns 202 Accepted status code response when request body is a valid file", t, func() {
-		fileStore := filetest.NewDummyFileStore()
-		handlers.FileStore = fileStore
-		eventProducer := eventtest.NewDummyEventProducer()
-		handlers.EventProducer = eventProducer
+		service, fileStore, _ := newUploadService()
 
 		recorder := httptest.NewRecorder()
 		requestBodyReader := bytes.NewReader([]byte(exampleMultipartBody))
@@ -88,19 +95,564 @@ func TestUploadHandler(t *testing.T) {
 		request.Header.Add("Content-Type", "multipart/form-data; boundary=----WebKitFormBoundaryezYpRsrGowIiw0K4")
 		So(err, ShouldBeNil)
 
-		handlers.Upload(recorder, request)
+		service.Upload(recorder, request)
 
 		var response = &handlers.Response{}
 		json.Unmarshal([]byte(recorder.Body.String()), response)
 
 		fmt.Println(recorder.Body)
 		So(recorder.Code, ShouldEqual, 202)
-		time.Sleep(1 * time.Second)
+		So(service.Workers.Shutdown(time.Second), ShouldBeNil)
 		So(fileStore.Invocations, ShouldEqual, 1)
 	})
 
 }
 
+func TestUploadHandlerQueueFull(t *testing.T) {
+
+	Convey("Given the only worker is busy and there is no room in the queue", t, func() {
+		service, _, _ := newUploadService()
+		release := make(chan struct{})
+		defer close(release)
+		started := make(chan struct{})
+		service.Workers.Submit(func() {
+			close(started)
+			<-release
+		})
+		<-started
+		service.Workers.Submit(func() {})
+
+		Convey("When a file is uploaded", func() {
+			recorder := httptest.NewRecorder()
+			request, err := http.NewRequest("POST", "/", strings.NewReader(exampleMultipartBody))
+			request.Header.Add("Content-Type", "multipart/form-data; boundary=----WebKitFormBoundaryezYpRsrGowIiw0K4")
+			So(err, ShouldBeNil)
+
+			service.Upload(recorder, request)
+
+			Convey("Then a 503 is returned asking the client to retry later", func() {
+				var response = &handlers.Response{}
+				json.Unmarshal([]byte(recorder.Body.String()), response)
+
+				So(recorder.Code, ShouldEqual, 503)
+				So(recorder.Header().Get("Retry-After"), ShouldNotBeBlank)
+				So(response.Message, ShouldEqual, handlers.TooManyUploads)
+			})
+		})
+	})
+}
+
+func TestUploadHandlerDiskGuards(t *testing.T) {
+
+	newRequest := func(contentLength int64) *http.Request {
+		request, _ := http.NewRequest("POST", "/", strings.NewReader(exampleMultipartBody))
+		request.Header.Add("Content-Type", "multipart/form-data; boundary=----WebKitFormBoundaryezYpRsrGowIiw0K4")
+		request.ContentLength = contentLength
+		return request
+	}
+
+	Convey("Given a maximum upload size smaller than the file", t, func() {
+		service, _, _ := newUploadService()
+		service.MaxUploadSize = 100
+
+		Convey("When the file is uploaded without a content length", func() {
+			recorder := httptest.NewRecorder()
+			service.Upload(recorder, newRequest(-1))
+
+			Convey("Then a 413 is returned once the limit is reached while streaming", func() {
+				var response = &handlers.Response{}
+				json.Unmarshal([]byte(recorder.Body.String()), response)
+
+				So(recorder.Code, ShouldEqual, 413)
+				So(response.Message, ShouldEqual, handlers.FileTooLarge)
+			})
+		})
+
+		Convey("When the file is uploaded with a content length over the limit", func() {
+			recorder := httptest.NewRecorder()
+			service.Upload(recorder, newRequest(int64(len(exampleMultipartBody))))
+
+			Convey("Then a 413 is returned", func() {
+				So(recorder.Code, ShouldEqual, 413)
+			})
+		})
+	})
+
+	Convey("Given a minimum free space larger than any disk", t, func() {
+		service, _, _ := newUploadService()
+		service.MinFreeSpace = 1 << 62
+
+		Convey("When a file is uploaded", func() {
+			recorder := httptest.NewRecorder()
+			service.Upload(recorder, newRequest(-1))
+
+			Convey("Then a 503 is returned asking the client to retry later", func() {
+				var response = &handlers.Response{}
+				json.Unmarshal([]byte(recorder.Body.String()), response)
+
+				So(recorder.Code, ShouldEqual, 503)
+				So(recorder.Header().Get("Retry-After"), ShouldNotBeBlank)
+				So(response.Message, ShouldEqual, handlers.InsufficientSpace)
+			})
+		})
+	})
+}
+
+func TestUploadHandlerRouting(t *testing.T) {
+
+	Convey("Given a routing table with a rule for the CPI dataset", t, func() {
+		service, _, eventProducer := newUploadService()
+		service.Routes = routing.NewTable("file-uploaded", routing.Rule{Dataset: "CPI", Topic: "cpi-uploaded", Prefix: "cpi"})
+
+		Convey("When a file is uploaded with the CPI dataset field", func() {
+			body := strings.Replace(exampleMultipartBody, "\n------WebKitFormBoundaryezYpRsrGowIiw0K4\nContent-Disposition: form-data; name=\"file\"",
+				"\n------WebKitFormBoundaryezYpRsrGowIiw0K4\nContent-Disposition: form-data; name=\"dataset\"\n\nCPI\n------WebKitFormBoundaryezYpRsrGowIiw0K4\nContent-Disposition: form-data; name=\"file\"", 1)
+
+			recorder := httptest.NewRecorder()
+			request, err := http.NewRequest("POST", "/", strings.NewReader(body))
+			request.Header.Add("Content-Type", "multipart/form-data; boundary=----WebKitFormBoundaryezYpRsrGowIiw0K4")
+			So(err, ShouldBeNil)
+
+			service.Upload(recorder, request)
+			So(recorder.Code, ShouldEqual, 202)
+			So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+
+			Convey("Then the event is sent to the dataset's topic", func() {
+				So(eventProducer.Topics, ShouldResemble, []string{"cpi-uploaded"})
+			})
+		})
+	})
+}
+
+func TestUploadHandlerIdentity(t *testing.T) {
+
+	Convey("Given an authenticated request", t, func() {
+		service, fileStore, eventProducer := newUploadService()
+		identity := &auth.Identity{Subject: "alice", Name: "Alice", Method: auth.MethodAPIKey}
+
+		Convey("When a file is uploaded", func() {
+			recorder := httptest.NewRecorder()
+			request, err := http.NewRequest("POST", "/", strings.NewReader(exampleMultipartBody))
+			request.Header.Add("Content-Type", "multipart/form-data; boundary=----WebKitFormBoundaryezYpRsrGowIiw0K4")
+			So(err, ShouldBeNil)
+
+			service.Upload(recorder, request.WithContext(auth.WithIdentity(request.Context(), identity)))
+			So(recorder.Code, ShouldEqual, 202)
+			So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+
+			Convey("Then the uploader is stored with the file and included in the event", func() {
+				So(fileStore.Metadata, ShouldHaveLength, 1)
+				So(fileStore.Metadata[0][handlers.UploadedByMetadata], ShouldEqual, "api-key:alice")
+				So(eventProducer.Events, ShouldHaveLength, 1)
+				So(eventProducer.Events[0].UploadedBy, ShouldResemble, &event.Uploader{Subject: "alice", Name: "Alice", Method: auth.MethodAPIKey})
+			})
+		})
+	})
+}
+
+func TestUploadHandlerMetadata(t *testing.T) {
+
+	Convey("Given an upload service checking metadata against the default schema", t, func() {
+		service, fileStore, eventProducer := newUploadService()
+		service.MetadataSchema = metadata.DefaultSchema()
+		expected := &metadata.Metadata{DatasetID: "CPI", Edition: "2017", ReleaseDate: "2017-06-30", Contact: "prices@example.com"}
+
+		Convey("When a file is uploaded with metadata", func() {
+			recorder := httptest.NewRecorder()
+			service.Upload(recorder, multipartFiles("dataset=CPI", "edition=2017", "release_date=2017-06-30", "contact=prices@example.com", "cpi.csv"))
+			So(recorder.Code, ShouldEqual, 202)
+			So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+
+			Convey("Then it is stored as object metadata and included in the event", func() {
+				So(fileStore.Metadata, ShouldHaveLength, 1)
+				So(fileStore.Metadata[0]["dataset-id"], ShouldEqual, "CPI")
+				So(fileStore.Metadata[0]["release-date"], ShouldEqual, "2017-06-30")
+				So(eventProducer.Events, ShouldHaveLength, 1)
+				So(eventProducer.Events[0].Metadata, ShouldResemble, expected)
+			})
+		})
+
+		Convey("When metadata is stored in a sidecar file", func() {
+			service.MetadataSidecar = true
+			recorder := httptest.NewRecorder()
+			service.Upload(recorder, multipartFiles("dataset=CPI", "edition=2017", "release_date=2017-06-30", "contact=prices@example.com", "cpi.csv"))
+			So(recorder.Code, ShouldEqual, 202)
+			So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+
+			Convey("Then it is saved alongside the file rather than as object metadata", func() {
+				So(fileStore.Filenames, ShouldResemble, []string{"cpi.csv", "cpi.csv" + metadata.SidecarSuffix})
+				So(fileStore.Metadata[0], ShouldNotContainKey, "dataset-id")
+				So(eventProducer.Events, ShouldHaveLength, 1)
+				So(eventProducer.Events[0].Metadata, ShouldResemble, expected)
+			})
+		})
+
+		Convey("When a file is uploaded without metadata", func() {
+			recorder := httptest.NewRecorder()
+			service.Upload(recorder, multipartFiles("cpi.csv"))
+			So(recorder.Code, ShouldEqual, 202)
+			So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+
+			Convey("Then the event has no metadata", func() {
+				So(eventProducer.Events, ShouldHaveLength, 1)
+				So(eventProducer.Events[0].Metadata, ShouldBeNil)
+			})
+		})
+
+		Convey("When a file is uploaded with notes too large to store as object metadata once encoded", func() {
+			notes := "notes=" + strings.Repeat("é", 500)
+
+			Convey("Then it is refused without being stored", func() {
+				recorder := httptest.NewRecorder()
+				service.Upload(recorder, multipartFiles("dataset=CPI", notes, "cpi.csv"))
+				So(recorder.Code, ShouldEqual, 400)
+				So(recorder.Body.String(), ShouldContainSubstring, "The metadata is too large to store with the file")
+				So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+				So(fileStore.Invocations, ShouldEqual, 0)
+			})
+
+			Convey("Then it is accepted if metadata is stored in a sidecar file", func() {
+				service.MetadataSidecar = true
+				recorder := httptest.NewRecorder()
+				service.Upload(recorder, multipartFiles("dataset=CPI", notes, "cpi.csv"))
+				So(recorder.Code, ShouldEqual, 202)
+			})
+		})
+
+		Convey("When a file is uploaded with an invalid release date", func() {
+			recorder := httptest.NewRecorder()
+			service.Upload(recorder, multipartFiles("dataset=CPI", "release_date=June", "cpi.csv"))
+
+			Convey("Then it is refused without being stored", func() {
+				So(recorder.Code, ShouldEqual, 400)
+				So(recorder.Body.String(), ShouldContainSubstring, "Release date must be a date")
+				So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+				So(fileStore.Invocations, ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestUploadHandlerPolicy(t *testing.T) {
+
+	Convey("Given a policy only letting the prices team upload the CPI dataset", t, func() {
+		service, fileStore, _ := newUploadService()
+		service.Routes = routing.NewTable("file-uploaded", routing.Rule{Dataset: "CPI", Prefix: "cpi"})
+		service.Policy, _ = policy.New(policy.Rule{Groups: []string{"prices"}, Prefixes: []string{"cpi"}, Datasets: []string{"CPI"}, MaxSize: "1KB"})
+
+		upload := func(identity *auth.Identity, body string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest("POST", "/", strings.NewReader(body))
+			request.Header.Add("Content-Type", "multipart/form-data; boundary=----WebKitFormBoundaryezYpRsrGowIiw0K4")
+			service.Upload(recorder, request.WithContext(auth.WithIdentity(request.Context(), identity)))
+			return recorder
+		}
+		cpiBody := strings.Replace(exampleMultipartBody, "\n------WebKitFormBoundaryezYpRsrGowIiw0K4\nContent-Disposition: form-data; name=\"file\"",
+			"\n------WebKitFormBoundaryezYpRsrGowIiw0K4\nContent-Disposition: form-data; name=\"dataset\"\n\nCPI\n------WebKitFormBoundaryezYpRsrGowIiw0K4\nContent-Disposition: form-data; name=\"file\"", 1)
+
+		Convey("When someone from another team uploads to the CPI dataset", func() {
+			recorder := upload(&auth.Identity{Subject: "alice", Groups: []string{"census"}}, cpiBody)
+
+			Convey("Then the upload is forbidden and not stored", func() {
+				var response = &handlers.Response{}
+				json.Unmarshal(recorder.Body.Bytes(), response)
+				So(recorder.Code, ShouldEqual, 403)
+				So(response.Message, ShouldEqual, "alice is not allowed to upload files.")
+				So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+				So(fileStore.Invocations, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the prices team uploads a file larger than their limit", func() {
+			recorder := upload(&auth.Identity{Subject: "bob", Groups: []string{"prices"}}, cpiBody)
+
+			Convey("Then the upload is forbidden", func() {
+				So(recorder.Code, ShouldEqual, 403)
+				So(recorder.Body.String(), ShouldContainSubstring, "larger than the 1024 bytes")
+			})
+		})
+
+		Convey("When the prices team uploads to the CPI dataset within their limit", func() {
+			service.Policy, _ = policy.New(policy.Rule{Groups: []string{"prices"}, Prefixes: []string{"cpi"}, Datasets: []string{"CPI"}})
+			recorder := upload(&auth.Identity{Subject: "bob", Groups: []string{"prices"}}, cpiBody)
+
+			Convey("Then the upload is accepted", func() {
+				So(recorder.Code, ShouldEqual, 202)
+				So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+				So(fileStore.Invocations, ShouldEqual, 1)
+			})
+		})
+	})
+
+	Convey("Given a policy letting two teams upload different datasets to anywhere", t, func() {
+		service, fileStore, _ := newUploadService()
+		service.Policy, _ = policy.New(
+			policy.Rule{Groups: []string{"prices"}, Datasets: []string{"CPI"}},
+			policy.Rule{Groups: []string{"census"}, Datasets: []string{"POP"}},
+		)
+		fileStore.ObjectMetadata = map[string]map[string]string{"cpi.csv": {handlers.UploadedByMetadata: "jwt:bob"}}
+
+		upload := func(identity *auth.Identity, request *http.Request) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			service.Upload(recorder, request.WithContext(auth.WithIdentity(request.Context(), identity)))
+			return recorder
+		}
+
+		Convey("When one team uploads over a file the other team uploaded", func() {
+			recorder := upload(&auth.Identity{Subject: "alice", Groups: []string{"census"}, Method: auth.MethodJWT}, multipartFiles("dataset=POP", "cpi.csv"))
+
+			Convey("Then the upload is forbidden and the file is not replaced", func() {
+				So(recorder.Code, ShouldEqual, 403)
+				So(recorder.Body.String(), ShouldContainSubstring, "cpi.csv was uploaded by someone else")
+				So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+				So(fileStore.Invocations, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When an API key client with the same name as the uploader uploads over the file", func() {
+			recorder := upload(&auth.Identity{Subject: "bob", Groups: []string{"prices"}, Method: auth.MethodAPIKey}, multipartFiles("dataset=CPI", "cpi.csv"))
+
+			Convey("Then the upload is forbidden and the file is not replaced", func() {
+				So(recorder.Code, ShouldEqual, 403)
+				So(recorder.Body.String(), ShouldContainSubstring, "cpi.csv was uploaded by someone else")
+				So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+				So(fileStore.Invocations, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the uploader of a file replaces it", func() {
+			recorder := upload(&auth.Identity{Subject: "bob", Groups: []string{"prices"}, Method: auth.MethodJWT}, multipartFiles("dataset=CPI", "cpi.csv"))
+
+			Convey("Then the file is replaced", func() {
+				So(recorder.Code, ShouldEqual, 202)
+				So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+				So(fileStore.Invocations, ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func TestUploadHandlerFilenames(t *testing.T) {
+
+	Convey("Given an upload service", t, func() {
+		service, fileStore, _ := newUploadService()
+
+		Convey("When a file is put named ..", func() {
+			recorder := httptest.NewRecorder()
+			service.PutFile(recorder, httptest.NewRequest("PUT", "/api/v1/files/..", strings.NewReader("a,b,c\n1,2,3\n")))
+
+			Convey("Then it is refused rather than stored outside its prefix", func() {
+				So(recorder.Code, ShouldEqual, 400)
+				So(recorder.Body.String(), ShouldContainSubstring, handlers.InvalidFilename)
+				So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+				So(fileStore.Invocations, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When a file is sent named .. by the X-Filename header", func() {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest("POST", "/api/v1/uploads", strings.NewReader("a,b,c\n1,2,3\n"))
+			request.Header.Set(handlers.FilenameHeader, "..")
+			service.CreateUpload(recorder, request)
+
+			Convey("Then it is refused rather than stored outside its prefix", func() {
+				So(recorder.Code, ShouldEqual, 400)
+				So(recorder.Body.String(), ShouldContainSubstring, handlers.InvalidFilename)
+				So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+				So(fileStore.Invocations, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When a file named .. is uploaded from the form after another", func() {
+			recorder := httptest.NewRecorder()
+			service.Upload(recorder, multipartFiles("cpi.csv", ".."))
+
+			Convey("Then only the file before it is stored, and the page says why the rest wasn't", func() {
+				So(recorder.Code, ShouldEqual, 202)
+				So(recorder.Body.String(), ShouldContainSubstring, "<strong>cpi.csv</strong> was uploaded")
+				So(recorder.Body.String(), ShouldContainSubstring, html.EscapeString(handlers.InvalidFilename))
+				So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+				So(fileStore.Invocations, ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+// multipartFiles builds a multipart upload of the given form fields and files, in order. Fields are
+// given as "name=value", and files as their name, with a valid CSV as their content.
+func multipartFiles(parts ...string) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range parts {
+		if field := strings.SplitN(part, "=", 2); len(field) == 2 {
+			writer.WriteField(field[0], field[1])
+			continue
+		}
+		file, _ := writer.CreateFormFile("file", part)
+		file.Write([]byte("a,b,c\n1,2,3\n"))
+	}
+	writer.Close()
+
+	request, _ := http.NewRequest("POST", "/", &body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	return request
+}
+
+func TestUploadHandlerMultipleFiles(t *testing.T) {
+
+	Convey("Given an upload service", t, func() {
+		service, fileStore, eventProducer := newUploadService()
+		service.Workers = worker.NewPool(1, 10)
+
+		Convey("When several files are uploaded from the form at once", func() {
+			recorder := httptest.NewRecorder()
+			service.Upload(recorder, multipartFiles("north.csv", "south.csv", "east.csv"))
+
+			Convey("Then each is accepted and processed on its own", func() {
+				So(recorder.Code, ShouldEqual, 202)
+				for _, filename := range []string{"north.csv", "south.csv", "east.csv"} {
+					So(recorder.Body.String(), ShouldContainSubstring, "<strong>"+filename+"</strong> was uploaded")
+				}
+				So(strings.Count(recorder.Body.String(), "<li data-upload="), ShouldEqual, 3)
+				So(recorder.Body.String(), ShouldContainSubstring, "new EventSource(")
+				So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+				So(fileStore.Invocations, ShouldEqual, 3)
+				So(eventProducer.Invocations, ShouldEqual, 3)
+			})
+		})
+
+		Convey("When the form has no files", func() {
+			recorder := httptest.NewRecorder()
+			service.Upload(recorder, multipartFiles("dataset=CPI"))
+
+			Convey("Then a 400 is returned", func() {
+				So(recorder.Code, ShouldEqual, 400)
+			})
+		})
+	})
+
+	Convey("Given a policy only letting the prices team upload the CPI dataset", t, func() {
+		service, fileStore, _ := newUploadService()
+		service.Routes = routing.NewTable("file-uploaded", routing.Rule{Dataset: "CPI", Prefix: "cpi"})
+		service.Policy, _ = policy.New(policy.Rule{Groups: []string{"prices"}, Prefixes: []string{"cpi"}, Datasets: []string{"CPI"}})
+
+		Convey("When the prices team uploads a file before the dataset field and one after it", func() {
+			recorder := httptest.NewRecorder()
+			request := multipartFiles("unknown.csv", "dataset=CPI", "cpi.csv")
+			identity := &auth.Identity{Subject: "bob", Groups: []string{"prices"}}
+			service.Upload(recorder, request.WithContext(auth.WithIdentity(request.Context(), identity)))
+
+			Convey("Then only the file for the CPI dataset is accepted, and the page says why the other wasn't", func() {
+				So(recorder.Code, ShouldEqual, 202)
+				So(recorder.Body.String(), ShouldContainSubstring, "<strong>unknown.csv</strong> was not uploaded: bob is not allowed")
+				So(recorder.Body.String(), ShouldContainSubstring, "<strong>cpi.csv</strong> was uploaded")
+				So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+				So(fileStore.Invocations, ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func TestUploadHandlerCSRF(t *testing.T) {
+
+	Convey("Given a browser that has loaded the upload form", t, func() {
+		service, fileStore, _ := newUploadService()
+		page := httptest.NewRecorder()
+		service.Home(page, httptest.NewRequest("GET", "/", nil))
+		cookies := page.Result().Cookies()
+		So(cookies, ShouldHaveLength, 1)
+		So(cookies[0].Name, ShouldEqual, auth.CSRFCookie)
+		So(page.Body.String(), ShouldContainSubstring, `name="csrf_token" value="`+cookies[0].Value+`"`)
+
+		upload := func(identity *auth.Identity, token string) *httptest.ResponseRecorder {
+			body := strings.Replace(exampleMultipartBody, "\n------WebKitFormBoundaryezYpRsrGowIiw0K4\nContent-Disposition: form-data; name=\"file\"",
+				"\n------WebKitFormBoundaryezYpRsrGowIiw0K4\nContent-Disposition: form-data; name=\"csrf_token\"\n\n"+token+"\n------WebKitFormBoundaryezYpRsrGowIiw0K4\nContent-Disposition: form-data; name=\"file\"", 1)
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest("POST", "/", strings.NewReader(body))
+			request.Header.Add("Content-Type", "multipart/form-data; boundary=----WebKitFormBoundaryezYpRsrGowIiw0K4")
+			request.AddCookie(cookies[0])
+			service.Upload(recorder, request.WithContext(auth.WithIdentity(request.Context(), identity)))
+			return recorder
+		}
+		session := &auth.Identity{Subject: "alice", Method: auth.MethodSession}
+
+		Convey("When the logged in browser submits the form with its token", func() {
+			recorder := upload(session, cookies[0].Value)
+
+			Convey("Then the upload is accepted", func() {
+				So(recorder.Code, ShouldEqual, 202)
+				So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+				So(fileStore.Invocations, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When another site submits a form for the logged in browser without the token", func() {
+			recorder := upload(session, "guessed")
+
+			Convey("Then the upload is forbidden", func() {
+				var response = &handlers.Response{}
+				json.Unmarshal(recorder.Body.Bytes(), response)
+				So(recorder.Code, ShouldEqual, 403)
+				So(response.Message, ShouldEqual, handlers.InvalidCSRFToken)
+			})
+		})
+
+		Convey("When a client authenticated with an API key uploads without a token", func() {
+			recorder := upload(&auth.Identity{Subject: "ingest-bot", Method: auth.MethodAPIKey}, "")
+
+			Convey("Then the upload is accepted", func() {
+				So(recorder.Code, ShouldEqual, 202)
+				So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestUploadHandlerTracing(t *testing.T) {
+
+	Convey("Given spans are being recorded", t, func() {
+		spans := tracingtest.NewRecorder()
+		defer spans.Close()
+
+		service, fileStore, _ := newUploadService()
+		fileStore.ReadFiles = true
+
+		Convey("When a file is uploaded as part of an existing trace", func() {
+			recorder := httptest.NewRecorder()
+			request := multipartFiles("cpi.csv")
+			request.Header.Add("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+			tracing.Handler(http.HandlerFunc(service.Upload)).ServeHTTP(recorder, request)
+			So(recorder.Code, ShouldEqual, 202)
+			So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+
+			Convey("Then the request and background processing are traced in the caller's trace", func() {
+				requestSpan := spans.Named("HTTP POST /")
+				processSpan := spans.Named("upload.process")
+				So(requestSpan, ShouldNotBeNil)
+				So(processSpan, ShouldNotBeNil)
+				So(requestSpan.SpanContext().TraceID().String(), ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+				So(processSpan.Parent().SpanID(), ShouldEqual, requestSpan.SpanContext().SpanID())
+				So(processSpan.SpanContext().TraceID(), ShouldEqual, requestSpan.SpanContext().TraceID())
+
+				for _, name := range []string{"upload.read_request", "upload.write_temp_file"} {
+					So(spans.Named(name).Parent().SpanID(), ShouldEqual, requestSpan.SpanContext().SpanID())
+				}
+				for _, name := range []string{"upload.validate", "s3.upload", "kafka.send"} {
+					So(spans.Named(name).Parent().SpanID(), ShouldEqual, processSpan.SpanContext().SpanID())
+				}
+				So(spans.Named("s3.upload").SpanKind(), ShouldEqual, trace.SpanKindClient)
+				So(spans.Named("kafka.send").SpanKind(), ShouldEqual, trace.SpanKindProducer)
+			})
+
+			Convey("And the trace context is stored with the file", func() {
+				So(fileStore.Metadata, ShouldHaveLength, 1)
+				So(fileStore.Metadata[0]["traceparent"], ShouldEqual, tracingtest.Traceparent(spans.Named("s3.upload")))
+			})
+		})
+	})
+}
+
 func TestValidatingReader(t *testing.T) {
 
 	Convey("validatingReader panics when we're given an invalid csv file with too few fields", t, func() {
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/audit"
+	"github.com/ONSdigital/dp-dd-file-uploader/metrics"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+var (
+	TooManyFilesInZip  = errors.New("More than one file in zip archive")
+	InvalidFileInZip   = errors.New("Non-CSV file in zip archive")
+	EncryptedFileInZip = errors.New("Encrypted file in zip archive")
+	InvalidNameInZip   = errors.New("Invalid file name in zip archive")
+	ZipTooLarge        = errors.New("Zip archive expands to more than the maximum uncompressed size")
+	ZipRatioTooHigh    = errors.New("Zip archive expands by more than the maximum compression ratio")
+)
+
+// The uncompressed size below which the compression ratio isn't checked, as small files of repeated
+// values can legitimately compress very well.
+const zipRatioAllowance = 1 << 20
+
+// zipEntryReader streams the file in a zip archive, failing once it has expanded beyond the maximum
+// size or compression ratio, whatever sizes the archive declares.
+type zipEntryReader struct {
+	reader     io.Reader
+	compressed int64
+	maxSize    int64
+	maxRatio   int64
+	read       int64
+	err        error
+}
+
+func (r *zipEntryReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	if r.err = r.check(r.read); r.err != nil {
+		return 0, r.err
+	}
+	return n, err
+}
+
+// check returns an error if a file expanding to size would be too large or too highly compressed.
+func (r *zipEntryReader) check(size int64) error {
+	if r.maxSize > 0 && size > r.maxSize {
+		return ZipTooLarge
+	}
+	compressed := r.compressed
+	if compressed < 1 {
+		compressed = 1
+	}
+	if r.maxRatio > 0 && size > zipRatioAllowance && size/compressed >= r.maxRatio {
+		return ZipRatioTooHigh
+	}
+	return nil
+}
+
+// decompressZipFile opens the single CSV file in a zip archive, returning a reader of its contents
+// and its name, stripped of any directories.
+func (s *UploadService) decompressZipFile(file *os.File, context string) (*zipEntryReader, string, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		log.ErrorC(context, err, log.Data{"message": "Unable to determine file size"})
+		return nil, "", err
+	}
+	zipReader, err := zip.NewReader(file, stat.Size())
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(zipReader.File) != 1 {
+		return nil, "", TooManyFilesInZip
+	}
+
+	entry := zipReader.File[0]
+	filename, err := safeZipName(entry.Name)
+	if err != nil {
+		return nil, "", err
+	}
+	if filepath.Ext(filename) != ".csv" {
+		return nil, "", InvalidFileInZip
+	}
+	if entry.Flags&0x1 != 0 {
+		return nil, "", EncryptedFileInZip
+	}
+
+	reader := &zipEntryReader{
+		compressed: clampSize(entry.CompressedSize64),
+		maxSize:    s.MaxUncompressedSize,
+		maxRatio:   int64(s.MaxCompressionRatio),
+	}
+	// Reject archives declaring a size that is too large straight away, rather than part way through.
+	if err = reader.check(clampSize(entry.UncompressedSize64)); err != nil {
+		return nil, "", err
+	}
+
+	if reader.reader, err = entry.Open(); err != nil {
+		return nil, "", err
+	}
+	return reader, filename, nil
+}
+
+// safeZipName returns the base name of a file in a zip archive, so names such as ../../census.csv
+// or /census.csv can't escape the prefix the file is stored under.
+func safeZipName(name string) (string, error) {
+	name = path.Base(strings.Replace(name, `\`, "/", -1))
+	if !validFilename(name) {
+		return "", InvalidNameInZip
+	}
+	return name, nil
+}
+
+func clampSize(size uint64) int64 {
+	if size > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(size)
+}
+
+// rejectZip records that a zip archive couldn't be decompressed, and announces it was rejected.
+func (s *UploadService) rejectZip(manifest Manifest, record *audit.Record, err error) {
+	log.ErrorC(manifest.Context, err, log.Data{"message": "Unable to decompress zip archive", "filename": manifest.Filename})
+	metrics.Uploads.WithLabelValues(metrics.OutcomeInvalidZip).Inc()
+	record.Outcome, record.Reason = metrics.OutcomeInvalidZip, err.Error()
+	s.sendRejection(manifest, err.Error(), "")
+}
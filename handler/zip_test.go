@@ -0,0 +1,108 @@
+package handlers_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/handler"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// zipUpload creates a multipart request uploading a zip archive containing a single file.
+func zipUpload(header *zip.FileHeader, contents string) *http.Request {
+	var archive bytes.Buffer
+	zipWriter := zip.NewWriter(&archive)
+	w, _ := zipWriter.CreateHeader(header)
+	w.Write([]byte(contents))
+	zipWriter.Close()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "upload.zip")
+	part.Write(archive.Bytes())
+	form.Close()
+
+	request, _ := http.NewRequest("POST", "/", &body)
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	return request
+}
+
+func TestUploadHandlerZip(t *testing.T) {
+
+	Convey("Given an upload service accepting zip archives", t, func() {
+		service, fileStore, eventProducer := newUploadService()
+		service.MaxUncompressedSize = 4 << 20
+		service.MaxCompressionRatio = 100
+
+		upload := func(header *zip.FileHeader, contents string) {
+			recorder := httptest.NewRecorder()
+			service.Upload(recorder, zipUpload(header, contents))
+			So(recorder.Code, ShouldEqual, 202)
+			So(service.Workers.Shutdown(time.Second), ShouldBeNil)
+		}
+		rejection := func() string {
+			So(fileStore.Invocations, ShouldEqual, 0)
+			So(eventProducer.Rejections, ShouldHaveLength, 1)
+			return eventProducer.Rejections[0].Reason
+		}
+
+		Convey("When the file in the archive has a path escaping its directory", func() {
+			upload(&zip.FileHeader{Name: "../../census/AF001EW.csv", Method: zip.Deflate}, "1,2,3\n")
+
+			Convey("Then it is stored under its base name", func() {
+				So(fileStore.Invocations, ShouldEqual, 1)
+				So(eventProducer.Events, ShouldHaveLength, 1)
+				So(eventProducer.Events[0].S3URL, ShouldEndWith, "/AF001EW.csv")
+				So(eventProducer.Events[0].S3URL, ShouldNotContainSubstring, "..")
+			})
+		})
+
+		Convey("When the file in the archive has an absolute Windows path", func() {
+			upload(&zip.FileHeader{Name: `C:\census\AF001EW.csv`, Method: zip.Deflate}, "1,2,3\n")
+
+			Convey("Then it is stored under its base name", func() {
+				So(eventProducer.Events, ShouldHaveLength, 1)
+				So(eventProducer.Events[0].S3URL, ShouldEndWith, "/AF001EW.csv")
+			})
+		})
+
+		Convey("When the file in the archive has no name", func() {
+			upload(&zip.FileHeader{Name: "../", Method: zip.Deflate}, "1,2,3\n")
+
+			Convey("Then it is rejected", func() {
+				So(rejection(), ShouldEqual, handlers.InvalidNameInZip.Error())
+			})
+		})
+
+		Convey("When the file in the archive expands beyond the maximum size", func() {
+			service.MaxCompressionRatio = 0
+			upload(&zip.FileHeader{Name: "AF001EW.csv", Method: zip.Deflate}, strings.Repeat("1,2,3\n", 1<<20))
+
+			Convey("Then it is rejected", func() {
+				So(rejection(), ShouldEqual, handlers.ZipTooLarge.Error())
+			})
+		})
+
+		Convey("When the file in the archive is compressed more than the maximum ratio", func() {
+			upload(&zip.FileHeader{Name: "AF001EW.csv", Method: zip.Deflate}, strings.Repeat("1,2,3\n", 500000))
+
+			Convey("Then it is rejected", func() {
+				So(rejection(), ShouldEqual, handlers.ZipRatioTooHigh.Error())
+			})
+		})
+
+		Convey("When the file in the archive is encrypted", func() {
+			upload(&zip.FileHeader{Name: "AF001EW.csv", Method: zip.Store, Flags: 0x1}, "1,2,3\n")
+
+			Convey("Then it is rejected", func() {
+				So(rejection(), ShouldEqual, handlers.EncryptedFileInZip.Error())
+			})
+		})
+	})
+}
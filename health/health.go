@@ -0,0 +1,163 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ONSdigital/go-ns/handlers/response"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// The status of a check or of the service as a whole.
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+)
+
+// ErrTimeout is reported for a check that does not complete within the check timeout.
+var ErrTimeout = errors.New("Timed out waiting for check to complete")
+
+// CheckFunc checks a dependency of the service, returning an error if it is unavailable.
+type CheckFunc func(ctx context.Context) error
+
+// Result is the outcome of a single check.
+type Result struct {
+	Name      string        `json:"name"`
+	Status    string        `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"durationNanos"`
+	CheckedAt time.Time     `json:"checkedAt"`
+}
+
+// Report is the outcome of all of the checks.
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Checker runs a set of named checks, caching each result so that frequent polling
+// of the readiness endpoint doesn't put load on the dependencies.
+type Checker struct {
+	timeout  time.Duration
+	cacheTTL time.Duration
+	checks   map[string]CheckFunc
+	mutex    sync.Mutex
+	results  map[string]Result
+}
+
+// NewChecker creates a checker that allows each check the given timeout and caches results for cacheTTL.
+func NewChecker(timeout time.Duration, cacheTTL time.Duration) *Checker {
+	return &Checker{
+		timeout:  timeout,
+		cacheTTL: cacheTTL,
+		checks:   make(map[string]CheckFunc),
+		results:  make(map[string]Result),
+	}
+}
+
+// Add registers a named check.
+func (c *Checker) Add(name string, check CheckFunc) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.checks[name] = check
+}
+
+// Run runs any checks whose cached result has expired, concurrently, and reports on all of them.
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mutex.Lock()
+	names := make([]string, 0, len(c.checks))
+	for name := range c.checks {
+		names = append(names, name)
+	}
+	c.mutex.Unlock()
+	sort.Strings(names)
+
+	results := make([]Result, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			results[i] = c.result(ctx, name)
+		}(i, name)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: results}
+	for _, result := range results {
+		if result.Status != StatusOK {
+			report.Status = StatusFailed
+		}
+	}
+	return report
+}
+
+func (c *Checker) result(ctx context.Context, name string) Result {
+	c.mutex.Lock()
+	cached, ok := c.results[name]
+	check := c.checks[name]
+	c.mutex.Unlock()
+
+	if ok && time.Since(cached.CheckedAt) < c.cacheTTL {
+		return cached
+	}
+
+	result := Result{Name: name, Status: StatusOK, CheckedAt: time.Now().UTC()}
+	if err := c.runWithTimeout(ctx, check); err != nil {
+		result.Status = StatusFailed
+		result.Error = err.Error()
+	}
+	result.Duration = time.Since(result.CheckedAt)
+
+	c.mutex.Lock()
+	c.results[name] = result
+	c.mutex.Unlock()
+
+	return result
+}
+
+// runWithTimeout runs a check, giving up on it if it does not return within the timeout.
+func (c *Checker) runWithTimeout(ctx context.Context, check CheckFunc) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ErrTimeout
+	}
+}
+
+// ReadyHandler returns 200 if every check passes, otherwise 503, with the result of each check as JSON.
+func (c *Checker) ReadyHandler(w http.ResponseWriter, req *http.Request) {
+	report := c.Run(req.Context())
+
+	status := http.StatusOK
+	if report.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+		log.DebugR(req, "Readiness check failed", log.Data{"report": report})
+	}
+
+	err := response.WriteJSON(w, report, status)
+	if err != nil {
+		log.ErrorR(req, err, log.Data{"message": "Failed to write JSON response"})
+	}
+}
+
+// LiveHandler returns 200 while the service is running and able to handle requests.
+func LiveHandler(w http.ResponseWriter, req *http.Request) {
+	err := response.WriteJSON(w, Report{Status: StatusOK, Checks: []Result{}}, http.StatusOK)
+	if err != nil {
+		log.ErrorR(req, err, log.Data{"message": "Failed to write JSON response"})
+	}
+}
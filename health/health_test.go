@@ -0,0 +1,63 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/health"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestChecker(t *testing.T) {
+
+	Convey("Given a checker with a passing check", t, func() {
+		checker := health.NewChecker(50*time.Millisecond, time.Hour)
+		calls := 0
+		checker.Add("passing", func(ctx context.Context) error {
+			calls++
+			return nil
+		})
+
+		Convey("When the checks are run twice", func() {
+			first := checker.Run(context.Background())
+			second := checker.Run(context.Background())
+
+			Convey("Then the service is ok and the cached result is reused", func() {
+				So(first.Status, ShouldEqual, health.StatusOK)
+				So(second.Checks[0].CheckedAt, ShouldResemble, first.Checks[0].CheckedAt)
+				So(calls, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When a failing check and a slow check are added", func() {
+			checker.Add("failing", func(ctx context.Context) error {
+				return errors.New("broken")
+			})
+			checker.Add("slow", func(ctx context.Context) error {
+				time.Sleep(time.Second)
+				return nil
+			})
+
+			Convey("Then the readiness endpoint returns 503 with the result of each check", func() {
+				recorder := httptest.NewRecorder()
+				request, _ := http.NewRequest("GET", "/health/ready", nil)
+				checker.ReadyHandler(recorder, request)
+
+				var report health.Report
+				So(json.Unmarshal(recorder.Body.Bytes(), &report), ShouldBeNil)
+				So(recorder.Code, ShouldEqual, 503)
+				So(report.Status, ShouldEqual, health.StatusFailed)
+				So(report.Checks, ShouldHaveLength, 3)
+				So(report.Checks[0].Name, ShouldEqual, "failing")
+				So(report.Checks[0].Error, ShouldEqual, "broken")
+				So(report.Checks[1].Status, ShouldEqual, health.StatusOK)
+				So(report.Checks[2].Error, ShouldEqual, health.ErrTimeout.Error())
+			})
+		})
+	})
+}
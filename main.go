@@ -1,74 +1,354 @@
 package main
 
 import (
-	"github.com/ONSdigital/dp-dd-file-uploader/assets"
+	"context"
+	"flag"
+	"github.com/ONSdigital/dp-dd-file-uploader/audit"
+	"github.com/ONSdigital/dp-dd-file-uploader/auth"
 	"github.com/ONSdigital/dp-dd-file-uploader/aws"
 	"github.com/ONSdigital/dp-dd-file-uploader/config"
+	"github.com/ONSdigital/dp-dd-file-uploader/disk"
 	"github.com/ONSdigital/dp-dd-file-uploader/event/kafka"
 	"github.com/ONSdigital/dp-dd-file-uploader/file/s3"
 	"github.com/ONSdigital/dp-dd-file-uploader/handler"
+	"github.com/ONSdigital/dp-dd-file-uploader/health"
+	"github.com/ONSdigital/dp-dd-file-uploader/metadata"
+	"github.com/ONSdigital/dp-dd-file-uploader/metrics"
+	"github.com/ONSdigital/dp-dd-file-uploader/policy"
+	"github.com/ONSdigital/dp-dd-file-uploader/ratelimit"
 	"github.com/ONSdigital/dp-dd-file-uploader/render"
-	"github.com/ONSdigital/go-ns/handlers/healthcheck"
-	"github.com/ONSdigital/go-ns/handlers/requestID"
-	"github.com/ONSdigital/go-ns/handlers/timeout"
+	"github.com/ONSdigital/dp-dd-file-uploader/routing"
+	"github.com/ONSdigital/dp-dd-file-uploader/scan"
+	"github.com/ONSdigital/dp-dd-file-uploader/server"
+	"github.com/ONSdigital/dp-dd-file-uploader/tracing"
+	"github.com/ONSdigital/dp-dd-file-uploader/worker"
 	"github.com/ONSdigital/go-ns/log"
-	"github.com/gorilla/pat"
-	"github.com/justinas/alice"
-	unrolled "github.com/unrolled/render"
-	"html/template"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
-
-	config.Load()
-	s3Config := aws.NewAWSConfig(config.AWSRegion, config.S3URL)
 	log.Namespace = "dp-dd-file-uploader"
 
-	var err error
-	render.Renderer = unrolled.New()
-	handlers.FileStore = s3.NewFileStore(s3Config)
-
-	render.Renderer = unrolled.New(unrolled.Options{
-		Asset:      assets.Asset,
-		AssetNames: assets.AssetNames,
-		Funcs: []template.FuncMap{{
-			"safeHTML": func(s string) template.HTML {
-				return template.HTML(s)
-			},
-		}},
-	})
+	flags := flag.NewFlagSet("dp-dd-file-uploader", flag.ExitOnError)
+	configFile := flags.String("config", "", "YAML or JSON config file, overridden by environment variables (default $"+config.ConfigFileKey+")")
+	printConfig := flags.Bool("print-config", false, "print the effective configuration, with secrets redacted, and exit")
+	flags.Parse(os.Args[1:])
+
+	cfg, err := config.Load(*configFile)
 
-	handlers.EventProducer, err = kafka.NewProducer(config.KafkaAddr, config.TopicName)
-	handlers.S3Config = s3Config
+	// The audit log can be verified without the rest of the service being configured
+	if flags.Arg(0) == "audit" {
+		if err := auditCommand(flags.Args()[1:], cfg); err != nil {
+			log.Error(err, nil)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *printConfig {
+		cfg.Print(os.Stdout)
+	}
 	if err != nil {
 		log.Error(err, nil)
 		os.Exit(1)
 	}
+	if *printConfig {
+		return
+	}
+	cfg.Log()
+	s3Config := aws.NewAWSConfig(cfg.AWSRegion, cfg.S3URL)
 
-	router := pat.New()
-	alice := alice.New(
-		timeout.Handler(config.UploadTimeout),
-		log.Handler,
-		requestID.Handler(16),
-	).Then(router)
+	if flags.Arg(0) == "replay" {
+		if err := replayCommand(flags.Args()[1:], cfg, s3Config); err != nil {
+			log.Error(err, nil)
+			os.Exit(1)
+		}
+		return
+	}
 
-	router.Get("/healthcheck", healthcheck.Handler)
-	router.Get("/", handlers.Home)
-	router.Post("/", handlers.Upload)
+	tracerProvider, err := tracing.NewProvider(cfg.TracingExporter, cfg.OTLPEndpoint, cfg.ServiceName)
+	if err != nil {
+		log.Error(err, nil)
+		os.Exit(1)
+	}
 
-	log.Debug("Starting server", log.Data{"bind_addr": config.BindAddr})
+	fileStore := s3.NewFileStore(s3Config)
+	producer, err := kafka.NewProducer(cfg.KafkaAddr, cfg.TopicName, cfg.EventEncoding, cfg.EventSource)
+	if err != nil {
+		log.Error(err, nil)
+		os.Exit(1)
+	}
 
-	server := &http.Server{
-		Addr:         config.BindAddr,
-		Handler:      alice,
-		ReadTimeout:  config.UploadTimeout,
-		WriteTimeout: config.UploadTimeout,
+	routes, err := loadRoutes(cfg)
+	if err != nil {
+		log.Error(err, log.Data{"routing_file": cfg.RoutingFile})
+		os.Exit(1)
 	}
 
-	if err := server.ListenAndServe(); err != nil {
+	authentication, err := newAuthentication(cfg)
+	if err != nil {
 		log.Error(err, nil)
 		os.Exit(1)
 	}
+
+	uploadPolicy, err := loadPolicy(cfg)
+	if err != nil {
+		log.Error(err, log.Data{"policy_file": cfg.PolicyFile})
+		os.Exit(1)
+	}
+
+	metadataSchema, err := loadMetadataSchema(cfg)
+	if err != nil {
+		log.Error(err, log.Data{"metadata_schema_file": cfg.MetadataSchemaFile})
+		os.Exit(1)
+	}
+
+	var scanner *scan.Clamd
+	if len(cfg.ClamdAddress) > 0 {
+		if scanner, err = scan.NewClamd(cfg.ClamdAddress, cfg.ScanTimeout); err != nil {
+			log.Error(err, nil)
+			os.Exit(1)
+		}
+	} else {
+		log.Debug("No clamd address is configured, files will not be scanned for viruses", nil)
+	}
+
+	var auditLog *audit.Log
+	if len(cfg.AuditLog) > 0 {
+		if auditLog, err = audit.Open(cfg.AuditLog, []byte(cfg.AuditKey)); err != nil {
+			log.Error(err, log.Data{"audit_log": cfg.AuditLog})
+			os.Exit(1)
+		}
+		if len(cfg.AuditReaders) == 0 {
+			log.Debug("No audit readers are configured, the audit log can't be queried", nil)
+		}
+		if len(cfg.AuditKey) == 0 {
+			log.Debug("No audit key is configured, keep the last hash of the audit log elsewhere to detect it being rewritten", nil)
+		}
+	}
+
+	uploads := &handlers.UploadService{
+		FileStore:           fileStore,
+		EventProducer:       producer,
+		S3Config:            s3Config,
+		Routes:              routes,
+		Workers:             worker.NewPool(cfg.WorkerPoolSize, cfg.WorkerQueueSize),
+		Renderer:            render.New(),
+		Policy:              uploadPolicy,
+		Audit:               auditLog,
+		AuditReaders:        splitList(cfg.AuditReaders),
+		ScanFailOpen:        cfg.ScanFailureMode == "open",
+		QuarantineDir:       cfg.QuarantineDir,
+		RejectedTopic:       cfg.RejectedTopic,
+		TempDir:             cfg.UploadTempDir,
+		MaxUploadSize:       cfg.MaxUploadSize,
+		MinFreeSpace:        cfg.MinFreeSpace,
+		MaxUncompressedSize: cfg.MaxUncompressedSize,
+		MaxCompressionRatio: cfg.MaxCompressionRatio,
+		Limiter:             newLimiter(cfg),
+		MetadataSchema:      metadataSchema,
+		MetadataSidecar:     cfg.MetadataStorage == "sidecar",
+	}
+
+	if scanner != nil {
+		uploads.Scanner = scanner
+	}
+
+	// The temp dir must be checked before uploads are received, as recovery can't tell a file still
+	// being received from one left behind
+	if err = uploads.RecoverUploads(cfg.RecoveryPolicy, cfg.RecoveryMaxAge); err != nil {
+		log.Error(err, log.Data{"message": "Failed to recover uploads left by a previous run"})
+	}
+
+	metrics.NewGaugeFunc("dp_dd_file_uploader_temp_dir_upload_bytes",
+		"Bytes used by uploads in the temp dir.", func() float64 {
+			return float64(handlers.TempDirUsage(cfg.UploadTempDir))
+		})
+	metrics.NewGaugeFunc("dp_dd_file_uploader_temp_dir_free_bytes",
+		"Bytes free on the filesystem holding the temp dir.", func() float64 {
+			free, _ := disk.FreeSpace(cfg.UploadTempDir)
+			return float64(free)
+		})
+
+	checker := health.NewChecker(cfg.HealthCheckTimeout, cfg.HealthCacheTTL)
+	checker.Add("s3", fileStore.Check)
+	// Invalid zip archives are rejected whether or not files are scanned
+	checker.Add("kafka", func(ctx context.Context) error {
+		return producer.Check(ctx, append(routes.Topics(), cfg.RejectedTopic)...)
+	})
+	checker.Add("tempDir", func(ctx context.Context) error {
+		return disk.CheckDir(cfg.UploadTempDir, cfg.MinFreeSpace)
+	})
+	if scanner != nil {
+		checker.Add("clamd", scanner.Check)
+	}
+	checker.Add("workers", uploads.Workers.Check)
+	checker.Add("accepting", uploads.CheckAccepting)
+
+	srv := server.New(cfg.BindAddr, cfg.UploadTimeout, uploads, checker, authentication)
+
+	serverErrors := make(chan error, 1)
+	go func() {
+		serverErrors <- srv.ListenAndServe()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErrors:
+		log.Error(err, nil)
+		os.Exit(1)
+	case sig := <-signals:
+		log.Debug("Shutting down", log.Data{"signal": sig.String(), "timeout": cfg.ShutdownTimeout})
+	}
+
+	if err := shutdown(srv, producer, tracerProvider, cfg.ShutdownTimeout); err != nil {
+		log.Error(err, nil)
+		os.Exit(1)
+	}
+	log.Debug("Shutdown complete", nil)
+}
+
+// shutdown stops the server within the timeout, then closes the Kafka producer
+// and sends any remaining spans.
+func shutdown(srv *server.Server, producer *kafka.Producer, tracerProvider *sdktrace.TracerProvider, timeout time.Duration) error {
+	waitErr := srv.Shutdown(timeout)
+	if waitErr != nil {
+		log.Error(waitErr, nil)
+	}
+
+	if err := producer.Close(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		log.Error(err, log.Data{"message": "Failed to export remaining spans"})
+	}
+
+	return waitErr
+}
+
+// loadRoutes loads the routing table from the configured file, or returns a table
+// that sends everything to the configured topic if there isn't one.
+func loadRoutes(cfg *config.Config) (*routing.Table, error) {
+	if len(cfg.RoutingFile) == 0 {
+		return routing.NewTable(cfg.TopicName), nil
+	}
+	return routing.Load(cfg.RoutingFile, cfg.TopicName)
+}
+
+// loadPolicy loads the upload policy from the configured file, or returns nil if there isn't one.
+func loadPolicy(cfg *config.Config) (*policy.Policy, error) {
+	if len(cfg.PolicyFile) == 0 {
+		return nil, nil
+	}
+	return policy.Load(cfg.PolicyFile)
+}
+
+// loadMetadataSchema loads the rules for dataset metadata from the configured file, or returns the
+// default rules if there isn't one.
+func loadMetadataSchema(cfg *config.Config) (*metadata.Schema, error) {
+	if len(cfg.MetadataSchemaFile) == 0 {
+		return metadata.DefaultSchema(), nil
+	}
+	return metadata.Load(cfg.MetadataSchemaFile)
+}
+
+// newLimiter returns a limiter applying the configured limits to each user and client IP, counting
+// uploads in memory.
+func newLimiter(cfg *config.Config) *ratelimit.Limiter {
+	user := ratelimit.Limits{
+		Concurrent:     cfg.UserMaxConcurrentUploads,
+		UploadsPerHour: cfg.UserMaxUploadsPerHour,
+		BytesPerDay:    cfg.UserMaxBytesPerDay,
+	}
+	ip := ratelimit.Limits{
+		Concurrent:     cfg.IPMaxConcurrentUploads,
+		UploadsPerHour: cfg.IPMaxUploadsPerHour,
+		BytesPerDay:    cfg.IPMaxBytesPerDay,
+	}
+	return ratelimit.New(ratelimit.NewMemoryStore(), user, ip)
+}
+
+// newAuthentication returns the middleware authenticating requests with each of the configured
+// methods, or nil if none is configured and uploads are open to anyone.
+func newAuthentication(cfg *config.Config) (*auth.Middleware, error) {
+	if !cfg.AuthEnabled() {
+		log.Debug("No authentication is configured, anyone who can reach the service can upload files", nil)
+		return nil, nil
+	}
+	middleware := &auth.Middleware{Public: server.PublicPaths}
+
+	if len(cfg.OIDCIssuerURL) > 0 {
+		if len(cfg.SessionKey) == 0 {
+			log.Debug("No session key is configured, browsers will need to log in again after a restart", nil)
+		}
+		sessions, err := auth.NewSessions([]byte(cfg.SessionKey), cfg.SessionTTL, strings.HasPrefix(cfg.OIDCRedirectURL, "https://"))
+		if err != nil {
+			return nil, err
+		}
+		oidc, err := auth.NewOIDC(cfg.OIDCIssuerURL, cfg.OIDCClientID, cfg.OIDCClientSecret, cfg.OIDCRedirectURL, sessions)
+		if err != nil {
+			return nil, err
+		}
+		oidc.GroupsClaim = cfg.GroupsClaim
+		middleware.OIDC = oidc
+		middleware.Authenticators = append(middleware.Authenticators, oidc)
+	}
+
+	if len(cfg.APIKeys) > 0 {
+		keys, err := auth.ParseCredentials(cfg.APIKeys)
+		if err != nil {
+			return nil, err
+		}
+		middleware.Authenticators = append(middleware.Authenticators, auth.NewAPIKeys(keys))
+	}
+
+	if len(cfg.JWTIssuer) > 0 || len(cfg.JWTSecret) > 0 {
+		verifier := &auth.Verifier{Issuer: cfg.JWTIssuer, Audience: cfg.JWTAudience, Secret: []byte(cfg.JWTSecret)}
+		// A provider that stops responding mustn't hold up every request with a token
+		client := &http.Client{Timeout: 10 * time.Second}
+		switch {
+		case len(cfg.JWTJWKSURL) > 0:
+			verifier.KeySet = &auth.KeySet{URL: cfg.JWTJWKSURL, Client: client}
+		case len(cfg.JWTIssuer) > 0:
+			_, _, keys, err := auth.Discover(client, cfg.JWTIssuer)
+			if err != nil {
+				return nil, err
+			}
+			verifier.KeySet = keys
+		}
+		middleware.Authenticators = append(middleware.Authenticators, &auth.BearerTokens{Verifier: verifier, GroupsClaim: cfg.GroupsClaim})
+	}
+
+	if len(cfg.StaticUsers) > 0 {
+		users, err := auth.ParseCredentials(cfg.StaticUsers)
+		if err != nil {
+			return nil, err
+		}
+		log.Debug("Static users are configured, these are intended for local development only", log.Data{"users": len(users)})
+		middleware.Authenticators = append(middleware.Authenticators, auth.NewStaticUsers("dp-dd-file-uploader", users))
+	}
+
+	return middleware, nil
+}
+
+// splitList splits a comma separated list, ignoring empty entries.
+func splitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); len(entry) > 0 {
+			list = append(list, entry)
+		}
+	}
+	return list
 }
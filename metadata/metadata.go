@@ -0,0 +1,149 @@
+// Package metadata describes the dataset an uploaded file belongs to, and checks it against a schema
+// before the file is accepted.
+package metadata
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// The names of the metadata fields, as sent in the upload form.
+const (
+	DatasetID   = "dataset"
+	Edition     = "edition"
+	Version     = "version"
+	ReleaseDate = "release_date"
+	Contact     = "contact"
+	Notes       = "notes"
+)
+
+// MaxObjectMetadataSize is the most S3 object metadata an object can have, in bytes, counted as the
+// UTF-8 bytes of every key and value.
+const MaxObjectMetadataSize = 2 << 10
+
+// SidecarSuffix is added to the key of an upload to name the JSON file its metadata is stored in, when
+// it isn't stored as S3 object metadata.
+const SidecarSuffix = ".metadata.json"
+
+// Metadata describes the dataset an uploaded file belongs to, so it doesn't have to be guessed from
+// the filename. Every field is optional unless the schema requires it.
+type Metadata struct {
+	DatasetID   string `json:"datasetId,omitempty"`
+	Edition     string `json:"edition,omitempty"`
+	Version     string `json:"version,omitempty"`
+	ReleaseDate string `json:"releaseDate,omitempty"`
+	Contact     string `json:"contact,omitempty"`
+	Notes       string `json:"notes,omitempty"`
+}
+
+// field is how a metadata field is named in each of the places it is read from and written to.
+type field struct {
+	name   string
+	label  string
+	header string
+	key    string
+	value  func(m *Metadata) *string
+}
+
+// fields lists the metadata fields in the order they are shown.
+var fields = []field{
+	{DatasetID, "Dataset ID", "X-Dataset", "dataset-id", func(m *Metadata) *string { return &m.DatasetID }},
+	{Edition, "Edition", "X-Edition", "edition", func(m *Metadata) *string { return &m.Edition }},
+	{Version, "Version", "X-Version", "version", func(m *Metadata) *string { return &m.Version }},
+	{ReleaseDate, "Release date", "X-Release-Date", "release-date", func(m *Metadata) *string { return &m.ReleaseDate }},
+	{Contact, "Contact", "X-Contact", "contact", func(m *Metadata) *string { return &m.Contact }},
+	{Notes, "Notes", "X-Notes", "notes", func(m *Metadata) *string { return &m.Notes }},
+}
+
+func lookup(name string) (field, bool) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+// FromFields reads the metadata from form fields, keyed by the names of the metadata fields. Other
+// fields are ignored.
+func FromFields(values map[string]string) Metadata {
+	var m Metadata
+	for _, f := range fields {
+		*f.value(&m) = strings.TrimSpace(values[f.name])
+	}
+	return m
+}
+
+// HeaderFields reads the metadata sent as request headers, e.g. X-Release-Date, returning them as form
+// fields keyed by the names of the metadata fields. RFC 2047 encoded values are decoded.
+func HeaderFields(header http.Header) map[string]string {
+	values := make(map[string]string, len(fields))
+	decoder := new(mime.WordDecoder)
+	for _, f := range fields {
+		value := header.Get(f.header)
+		if decoded, err := decoder.DecodeHeader(value); err == nil {
+			value = decoded
+		}
+		if value = strings.TrimSpace(value); len(value) > 0 {
+			values[f.name] = value
+		}
+	}
+	return values
+}
+
+// SetHeaders sets the request headers the fields that are set are sent in, RFC 2047 encoding any values
+// that aren't printable ASCII.
+func (m Metadata) SetHeaders(header http.Header) {
+	for _, f := range fields {
+		if value := *f.value(&m); len(value) > 0 {
+			header.Set(f.header, mime.QEncoding.Encode("utf-8", value))
+		}
+	}
+}
+
+// FromObjectMetadata reads the metadata from the S3 object metadata it was stored as, decoding any RFC
+// 2047 encoded values. Keys are matched regardless of case, as S3 returns them as HTTP headers.
+func FromObjectMetadata(metadata map[string]string) Metadata {
+	lower := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		lower[strings.ToLower(key)] = value
+	}
+
+	var m Metadata
+	decoder := new(mime.WordDecoder)
+	for _, f := range fields {
+		value := lower[f.key]
+		if decoded, err := decoder.DecodeHeader(value); err == nil {
+			value = decoded
+		}
+		*f.value(&m) = strings.TrimSpace(value)
+	}
+	return m
+}
+
+// IsZero returns whether none of the metadata fields are set.
+func (m Metadata) IsZero() bool {
+	return m == Metadata{}
+}
+
+// ObjectMetadataSize returns the size S3 counts object metadata as, the bytes of every key and value.
+func ObjectMetadataSize(metadata map[string]string) int {
+	size := 0
+	for key, value := range metadata {
+		size += len(key) + len(value)
+	}
+	return size
+}
+
+// ObjectMetadata returns the fields that are set as S3 object metadata, e.g. release-date. Values that
+// aren't printable ASCII are RFC 2047 encoded, as object metadata is sent as HTTP headers.
+func (m Metadata) ObjectMetadata() map[string]string {
+	metadata := make(map[string]string)
+	for _, f := range fields {
+		if value := *f.value(&m); len(value) > 0 {
+			metadata[f.key] = mime.QEncoding.Encode("utf-8", value)
+		}
+	}
+	return metadata
+}
@@ -0,0 +1,169 @@
+package metadata_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/metadata"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCheck(t *testing.T) {
+
+	Convey("Given the default schema", t, func() {
+		schema := metadata.DefaultSchema()
+
+		Convey("Then metadata without any fields is valid", func() {
+			So(schema.Check(metadata.Metadata{}), ShouldBeNil)
+		})
+
+		Convey("Then complete metadata is valid", func() {
+			So(schema.Check(metadata.Metadata{
+				DatasetID:   "CPI",
+				Edition:     "2017",
+				Version:     "3",
+				ReleaseDate: "2017-06-30",
+				Contact:     "prices@example.com",
+				Notes:       "Revised figures for May.",
+			}), ShouldBeNil)
+		})
+
+		Convey("Then every invalid field is described", func() {
+			err := schema.Check(metadata.Metadata{ReleaseDate: "30/06/2017", Contact: "Prices <prices@example.com>"})
+			So(err, ShouldHaveSameTypeAs, &metadata.InvalidError{})
+			So(err.Error(), ShouldEqual, "Release date must be a date, e.g. 2017-06-30. Contact must be an email address.")
+		})
+	})
+
+	Convey("Given a schema requiring a dataset ID from a list and a numeric version", t, func() {
+		schema, err := metadata.New(map[string]metadata.Rule{
+			metadata.DatasetID: {Required: true, Values: []string{"CPI", "CENSUS-2021"}},
+			metadata.Version:   {Pattern: "[0-9]+", MaxLength: 3},
+		})
+		So(err, ShouldBeNil)
+
+		Convey("Then a missing dataset ID is invalid", func() {
+			So(schema.Check(metadata.Metadata{Version: "1"}).Error(), ShouldEqual, "Dataset ID must be given.")
+		})
+
+		Convey("Then a dataset ID must be one of the values", func() {
+			So(schema.Check(metadata.Metadata{DatasetID: "RPI"}).Error(), ShouldEqual, "Dataset ID must be one of CENSUS-2021, CPI.")
+		})
+
+		Convey("Then the whole version must match the pattern", func() {
+			So(schema.Check(metadata.Metadata{DatasetID: "CPI", Version: "12"}), ShouldBeNil)
+			So(schema.Check(metadata.Metadata{DatasetID: "CPI", Version: "v12"}).Error(), ShouldEqual, "Version is not in the expected form.")
+			So(schema.Check(metadata.Metadata{DatasetID: "CPI", Version: "1234"}).Error(), ShouldEqual, "Version must be at most 3 characters.")
+		})
+	})
+
+	Convey("Given rules that can't be used", t, func() {
+		Convey("Then a rule for an unknown field is an error", func() {
+			_, err := metadata.New(map[string]metadata.Rule{"publisher": {Required: true}})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then an unknown format is an error", func() {
+			_, err := metadata.New(map[string]metadata.Rule{metadata.Contact: {Format: "phone"}})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then an invalid pattern is an error", func() {
+			_, err := metadata.New(map[string]metadata.Rule{metadata.Version: {Pattern: "[0-9"}})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestFields(t *testing.T) {
+
+	Convey("Given metadata sent as request headers", t, func() {
+		header := http.Header{}
+		header.Set("X-Dataset", "CPI")
+		header.Set("X-Release-Date", " 2017-06-30 ")
+		header.Set("X-Notes", "=?utf-8?q?R=C3=A9vis=C3=A9?=")
+
+		Convey("When it is read as form fields", func() {
+			m := metadata.FromFields(metadata.HeaderFields(header))
+
+			Convey("Then each header is read into its field", func() {
+				So(m, ShouldResemble, metadata.Metadata{DatasetID: "CPI", ReleaseDate: "2017-06-30", Notes: "Révisé"})
+				So(m.IsZero(), ShouldBeFalse)
+			})
+
+			Convey("Then the fields that are set are given as S3 object metadata, encoding any that aren't ASCII", func() {
+				So(m.ObjectMetadata(), ShouldResemble, map[string]string{
+					"dataset-id":   "CPI",
+					"release-date": "2017-06-30",
+					"notes":        "=?utf-8?q?R=C3=A9vis=C3=A9?=",
+				})
+			})
+
+			Convey("Then its size is counted as S3 does, in the bytes of every key and value", func() {
+				So(metadata.ObjectMetadataSize(m.ObjectMetadata()), ShouldEqual, len("dataset-idCPIrelease-date2017-06-30notes=?utf-8?q?R=C3=A9vis=C3=A9?="))
+			})
+
+			Convey("Then the same headers are set to send it again", func() {
+				sent := http.Header{}
+				m.SetHeaders(sent)
+				So(sent, ShouldResemble, http.Header{
+					"X-Dataset":      {"CPI"},
+					"X-Release-Date": {"2017-06-30"},
+					"X-Notes":        {"=?utf-8?q?R=C3=A9vis=C3=A9?="},
+				})
+			})
+		})
+	})
+}
+
+func TestFromObjectMetadata(t *testing.T) {
+
+	Convey("Given metadata returned by S3, with its keys in any case", t, func() {
+		objectMetadata := map[string]string{
+			"Dataset-Id":  "CPI",
+			"notes":       "=?utf-8?q?R=C3=A9vis=C3=A9?=",
+			"uploaded-by": "alice",
+		}
+
+		Convey("Then the metadata fields are read from it, decoding encoded values", func() {
+			So(metadata.FromObjectMetadata(objectMetadata), ShouldResemble, metadata.Metadata{DatasetID: "CPI", Notes: "Révisé"})
+		})
+	})
+}
+
+func TestLoad(t *testing.T) {
+
+	Convey("Given a schema file with an unknown property", t, func() {
+		file, _ := ioutil.TempFile("", "metadata-schema-")
+		defer os.Remove(file.Name())
+		file.WriteString(`{"fields": {"dataset": {"mandatory": true}}}`)
+		file.Close()
+
+		Convey("When the file is loaded", func() {
+			_, err := metadata.Load(file.Name())
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given a valid schema file", t, func() {
+		file, _ := ioutil.TempFile("", "metadata-schema-")
+		defer os.Remove(file.Name())
+		file.WriteString(`{"fields": {"dataset": {"required": true}, "edition": {"pattern": "[0-9]{4}"}}}`)
+		file.Close()
+
+		Convey("When the file is loaded", func() {
+			schema, err := metadata.Load(file.Name())
+
+			Convey("Then its rules are used to check metadata", func() {
+				So(err, ShouldBeNil)
+				So(schema.Check(metadata.Metadata{DatasetID: "CPI", Edition: "2017"}), ShouldBeNil)
+				So(schema.Check(metadata.Metadata{Edition: "May"}).Error(), ShouldEqual, "Dataset ID must be given. Edition is not in the expected form.")
+			})
+		})
+	})
+}
@@ -0,0 +1,185 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The formats a field can be required to have.
+const (
+	// FormatDate is a calendar date, e.g. 2017-06-30.
+	FormatDate = "date"
+	// FormatEmail is a single email address.
+	FormatEmail = "email"
+)
+
+const dateLayout = "2006-01-02"
+
+// Rule constrains the value of a metadata field. The other constraints only apply to fields that are set.
+type Rule struct {
+	// Required fields must be set for every upload.
+	Required bool `json:"required,omitempty"`
+	// MaxLength is the most characters the value can have. Zero means no limit.
+	MaxLength int `json:"maxLength,omitempty"`
+	// Pattern is a regular expression the whole value must match.
+	Pattern string `json:"pattern,omitempty"`
+	// Format is the kind of value the field must hold: date or email.
+	Format string `json:"format,omitempty"`
+	// Values, if given, are the only values the field can have.
+	Values []string `json:"values,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Schema is the rules metadata must follow, keyed by the names of the metadata fields. Fields without
+// a rule can have any value.
+type Schema struct {
+	Fields map[string]Rule `json:"fields"`
+}
+
+// InvalidError is returned when metadata doesn't follow the schema.
+type InvalidError struct {
+	// Problems are a sentence describing each way the metadata is invalid.
+	Problems []string
+}
+
+func (e *InvalidError) Error() string {
+	return strings.Join(e.Problems, " ")
+}
+
+// DefaultSchema returns the rules used if no schema is configured: every field is optional, the
+// release date must be a date and the contact an email address, and no value can be unreasonably long.
+func DefaultSchema() *Schema {
+	schema, _ := New(map[string]Rule{
+		DatasetID:   {MaxLength: 100},
+		Edition:     {MaxLength: 100},
+		Version:     {MaxLength: 100},
+		ReleaseDate: {Format: FormatDate},
+		Contact:     {MaxLength: 254, Format: FormatEmail},
+		Notes:       {MaxLength: 1000},
+	})
+	return schema
+}
+
+// New creates a schema with the given rules.
+func New(rules map[string]Rule) (*Schema, error) {
+	schema := &Schema{Fields: rules}
+	if err := schema.Validate(); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// Load reads a schema from the given JSON file.
+func Load(filename string) (*Schema, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	schema := &Schema{}
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(schema); err != nil {
+		return nil, fmt.Errorf("Failed to parse metadata schema file %s: %v", filename, err)
+	}
+
+	if err = schema.Validate(); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// Validate checks each of the rules in the schema is usable.
+func (s *Schema) Validate() error {
+	for name, rule := range s.Fields {
+		if _, ok := lookup(name); !ok {
+			return fmt.Errorf("Metadata schema has a rule for an unknown field %q", name)
+		}
+		if rule.MaxLength < 0 {
+			return fmt.Errorf("Metadata schema rule for %s has a negative maxLength", name)
+		}
+		switch rule.Format {
+		case "", FormatDate, FormatEmail:
+		default:
+			return fmt.Errorf("Metadata schema rule for %s has an unknown format %q", name, rule.Format)
+		}
+		if len(rule.Pattern) > 0 {
+			pattern, err := regexp.Compile("^(?:" + rule.Pattern + ")$")
+			if err != nil {
+				return fmt.Errorf("Metadata schema rule for %s has an invalid pattern: %v", name, err)
+			}
+			rule.pattern = pattern
+		}
+		s.Fields[name] = rule
+	}
+	return nil
+}
+
+// Check returns an InvalidError describing every way the metadata doesn't follow the schema, or nil if it does.
+func (s *Schema) Check(m Metadata) error {
+	var problems []string
+	for _, f := range fields {
+		rule, ok := s.Fields[f.name]
+		if !ok {
+			continue
+		}
+		if problem := rule.check(f.label, *f.value(&m)); len(problem) > 0 {
+			problems = append(problems, problem)
+		}
+	}
+	if len(problems) > 0 {
+		return &InvalidError{Problems: problems}
+	}
+	return nil
+}
+
+// check returns why the value doesn't follow the rule, or an empty string if it does.
+func (r Rule) check(label string, value string) string {
+	if len(value) == 0 {
+		if r.Required {
+			return label + " must be given."
+		}
+		return ""
+	}
+
+	if r.MaxLength > 0 && utf8.RuneCountInString(value) > r.MaxLength {
+		return fmt.Sprintf("%s must be at most %d characters.", label, r.MaxLength)
+	}
+	switch r.Format {
+	case FormatDate:
+		if _, err := time.Parse(dateLayout, value); err != nil {
+			return label + " must be a date, e.g. 2017-06-30."
+		}
+	case FormatEmail:
+		if address, err := mail.ParseAddress(value); err != nil || address.Address != value {
+			return label + " must be an email address."
+		}
+	}
+	if r.pattern != nil && !r.pattern.MatchString(value) {
+		return label + " is not in the expected form."
+	}
+	if len(r.Values) > 0 && !contains(r.Values, value) {
+		values := append([]string(nil), r.Values...)
+		sort.Strings(values)
+		return label + " must be one of " + strings.Join(values, ", ") + "."
+	}
+	return ""
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ONSdigital/go-ns/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultBuckets are histogram buckets suited to durations in seconds, from 5ms to 10 minutes.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+// DefaultRegistry is the registry the service's metrics are added to.
+var DefaultRegistry = prometheus.NewRegistry()
+
+// Handler returns a handler for the metrics in the default registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(DefaultRegistry, promhttp.HandlerOpts{ErrorLog: errorLogger{}})
+}
+
+// errorLogger logs the errors met while gathering or writing metrics.
+type errorLogger struct{}
+
+func (errorLogger) Println(v ...interface{}) {
+	log.Error(errors.New(fmt.Sprint(v...)), log.Data{"message": "Failed to write metrics"})
+}
+
+func newCounterVec(name string, help string, labelNames ...string) *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labelNames)
+	DefaultRegistry.MustRegister(c)
+	return c
+}
+
+func newCounter(name string, help string) prometheus.Counter {
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: name, Help: help})
+	DefaultRegistry.MustRegister(c)
+	return c
+}
+
+func newGauge(name string, help string) prometheus.Gauge {
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: help})
+	DefaultRegistry.MustRegister(g)
+	return g
+}
+
+func newHistogramVec(name string, help string, labelNames ...string) *prometheus.HistogramVec {
+	h := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: DefaultBuckets}, labelNames)
+	DefaultRegistry.MustRegister(h)
+	return h
+}
+
+// NewGaugeFunc adds a gauge to the default registry whose value is read from fn each time the metrics
+// are requested.
+func NewGaugeFunc(name string, help string, fn func() float64) {
+	DefaultRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help}, fn))
+}
@@ -0,0 +1,37 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/metrics"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHandler(t *testing.T) {
+
+	Convey("Given an upload has been counted and a stage timed", t, func() {
+		metrics.Uploads.WithLabelValues(metrics.OutcomeCompleted).Inc()
+		metrics.StageDuration.WithLabelValues(metrics.StageStore).Observe(0.05)
+
+		Convey("When the metrics are requested", func() {
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest("GET", "/metrics", nil)
+			metrics.Handler().ServeHTTP(recorder, request)
+			body := recorder.Body.String()
+
+			Convey("Then they are written in the Prometheus text format", func() {
+				So(recorder.Code, ShouldEqual, http.StatusOK)
+				So(recorder.Header().Get("Content-Type"), ShouldStartWith, "text/plain; version=0.0.4")
+				So(body, ShouldContainSubstring, "# HELP dp_dd_file_uploader_uploads_total Uploads by their final outcome.\n")
+				So(body, ShouldContainSubstring, "# TYPE dp_dd_file_uploader_uploads_total counter\n")
+				So(body, ShouldContainSubstring, `dp_dd_file_uploader_uploads_total{outcome="completed"}`)
+				So(body, ShouldContainSubstring, "# TYPE dp_dd_file_uploader_stage_duration_seconds histogram\n")
+				So(body, ShouldContainSubstring, `dp_dd_file_uploader_stage_duration_seconds_bucket{stage="store",le="0.1"}`)
+				So(body, ShouldContainSubstring, `dp_dd_file_uploader_stage_duration_seconds_count{stage="store"}`)
+				So(body, ShouldContainSubstring, "# TYPE dp_dd_file_uploader_background_jobs gauge\n")
+			})
+		})
+	})
+}
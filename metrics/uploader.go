@@ -0,0 +1,59 @@
+package metrics
+
+// The outcomes of an upload, recorded by the Uploads counter.
+const (
+	OutcomeCompleted         = "completed"
+	OutcomeBadRequest        = "bad_request"
+	OutcomeTooLarge          = "too_large"
+	OutcomeBusy              = "busy"
+	OutcomeInsufficientSpace = "insufficient_space"
+	OutcomeShuttingDown      = "shutting_down"
+	OutcomeStoreFailed       = "store_failed"
+	OutcomeEventFailed       = "event_failed"
+	OutcomeForbidden         = "forbidden"
+	OutcomeInfected          = "infected"
+	OutcomeScanFailed        = "scan_failed"
+	OutcomeInvalidZip        = "invalid_zip"
+	OutcomeRateLimited       = "rate_limited"
+	OutcomeCancelled         = "cancelled"
+	OutcomeInvalidMetadata   = "invalid_metadata"
+)
+
+// The stages of the upload pipeline, recorded by the StageDuration histogram.
+const (
+	StageReceive = "receive"
+	StageQueued  = "queued"
+	StageScan    = "scan"
+	StageStore   = "store"
+	StageEvent   = "event"
+)
+
+// The metrics reported by the service.
+var (
+	Uploads = newCounterVec("dp_dd_file_uploader_uploads_total",
+		"Uploads by their final outcome.", "outcome")
+
+	BytesReceived = newCounter("dp_dd_file_uploader_received_bytes_total",
+		"Bytes of uploaded files written to the temp dir.")
+
+	BytesStored = newCounter("dp_dd_file_uploader_stored_bytes_total",
+		"Bytes of files successfully stored in S3, after any decompression.")
+
+	RowsValidated = newCounter("dp_dd_file_uploader_validated_rows_total",
+		"CSV rows that passed validation.")
+
+	StageDuration = newHistogramVec("dp_dd_file_uploader_stage_duration_seconds",
+		"Time spent in each stage of the upload pipeline.", "stage")
+
+	S3UploadDuration = newHistogramVec("dp_dd_file_uploader_s3_upload_duration_seconds",
+		"Time taken by S3 uploads, by result.", "result")
+
+	KafkaSendDuration = newHistogramVec("dp_dd_file_uploader_kafka_send_duration_seconds",
+		"Time taken to send events to Kafka, by topic.", "topic")
+
+	KafkaSendErrors = newCounterVec("dp_dd_file_uploader_kafka_send_errors_total",
+		"Events that could not be sent to Kafka, by topic.", "topic")
+
+	BackgroundJobs = newGauge("dp_dd_file_uploader_background_jobs",
+		"Uploads queued or being processed in the background.")
+)
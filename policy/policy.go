@@ -0,0 +1,217 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/auth"
+	"github.com/ONSdigital/dp-dd-file-uploader/size"
+)
+
+// Anyone is the user name that applies a rule to every identity, including anonymous uploads.
+const Anyone = "*"
+
+// Rule allows the users and groups it names to upload to its destinations. A destination list
+// that is empty allows any destination of that kind.
+type Rule struct {
+	// Users are the subjects of the identities the rule applies to.
+	Users []string `json:"users,omitempty"`
+	// Groups are the groups of the identities the rule applies to.
+	Groups []string `json:"groups,omitempty"`
+	// Prefixes are the S3 prefixes files can be stored under, including any prefix beneath them.
+	// "/" allows files to be stored without a prefix.
+	Prefixes []string `json:"prefixes,omitempty"`
+	// Topics are the Kafka topics events can be sent to.
+	Topics []string `json:"topics,omitempty"`
+	// Datasets are the values of the dataset form field that can be uploaded.
+	Datasets []string `json:"datasets,omitempty"`
+	// MaxSize is the largest file that can be uploaded, in bytes or with a KB, MB or GB suffix.
+	MaxSize string `json:"maxSize,omitempty"`
+
+	maxSize int64
+}
+
+// Policy is the set of rules saying who can upload where. An upload is allowed if any rule
+// that applies to the uploader allows it.
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Upload is the destination of an upload to be authorised.
+type Upload struct {
+	Prefix  string
+	Topic   string
+	Dataset string
+}
+
+// Grant is what an uploader is allowed to do with an upload.
+type Grant struct {
+	// MaxSize is the largest file the uploader can upload to the destination. Zero means no limit.
+	MaxSize int64
+}
+
+// DeniedError is returned when an uploader is not allowed to make an upload.
+type DeniedError struct {
+	Reason string
+}
+
+func (e *DeniedError) Error() string {
+	return e.Reason
+}
+
+// New creates a policy with the given rules.
+func New(rules ...Rule) (*Policy, error) {
+	policy := &Policy{Rules: rules}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// Load reads a policy from the given JSON file.
+func Load(filename string) (*Policy, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	policy := &Policy{}
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(policy); err != nil {
+		return nil, fmt.Errorf("Failed to parse policy file %s: %v", filename, err)
+	}
+
+	if err = policy.Validate(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// Validate checks each of the rules in the policy is usable.
+func (p *Policy) Validate() error {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if len(rule.Users) == 0 && len(rule.Groups) == 0 {
+			return fmt.Errorf("Policy rule %d has no users or groups to apply to", i)
+		}
+		for _, prefix := range rule.Prefixes {
+			if strings.Contains(prefix, "..") {
+				return fmt.Errorf("Policy rule %d has a prefix containing '..'", i)
+			}
+		}
+		if len(rule.MaxSize) > 0 {
+			maxSize, err := size.Parse(rule.MaxSize)
+			if err != nil || maxSize < 0 {
+				return fmt.Errorf("Policy rule %d has an invalid maxSize %q", i, rule.MaxSize)
+			}
+			rule.maxSize = maxSize
+		}
+	}
+	return nil
+}
+
+// Authorize returns what the identity is allowed to do with the upload, or a DeniedError if it
+// isn't allowed to make it at all. A nil identity is an anonymous upload, which only rules for
+// Anyone apply to. If more than one rule allows the upload, the most generous size limit applies.
+func (p *Policy) Authorize(id *auth.Identity, upload Upload) (Grant, error) {
+	name := "anonymous"
+	if id != nil {
+		name = id.Subject
+	}
+
+	applies := false
+	allowed := false
+	var grant Grant
+	for _, rule := range p.Rules {
+		if !rule.appliesTo(id) {
+			continue
+		}
+		applies = true
+		if !rule.allows(upload) {
+			continue
+		}
+		if !allowed || rule.maxSize == 0 || (grant.MaxSize > 0 && rule.maxSize > grant.MaxSize) {
+			grant.MaxSize = rule.maxSize
+		}
+		allowed = true
+	}
+
+	switch {
+	case !applies:
+		return Grant{}, &DeniedError{Reason: fmt.Sprintf("%s is not allowed to upload files.", name)}
+	case !allowed:
+		return Grant{}, &DeniedError{Reason: fmt.Sprintf("%s is not allowed to upload to %s.", name, describe(upload))}
+	}
+	return grant, nil
+}
+
+// CheckSize returns a DeniedError if a file of the given size is larger than the grant allows.
+func (g Grant) CheckSize(size int64) error {
+	if g.MaxSize > 0 && size > g.MaxSize {
+		return &DeniedError{Reason: fmt.Sprintf("The file is larger than the %d bytes you are allowed to upload to this destination.", g.MaxSize)}
+	}
+	return nil
+}
+
+func describe(upload Upload) string {
+	destination := fmt.Sprintf("topic %s", upload.Topic)
+	if len(upload.Prefix) > 0 {
+		destination = fmt.Sprintf("prefix %s and %s", upload.Prefix, destination)
+	}
+	if len(upload.Dataset) > 0 {
+		destination = fmt.Sprintf("dataset %s at %s", upload.Dataset, destination)
+	}
+	return destination
+}
+
+func (rule Rule) appliesTo(id *auth.Identity) bool {
+	for _, user := range rule.Users {
+		if user == Anyone || (id != nil && user == id.Subject) {
+			return true
+		}
+	}
+	if id == nil {
+		return false
+	}
+	for _, group := range rule.Groups {
+		for _, member := range id.Groups {
+			if group == member {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (rule Rule) allows(upload Upload) bool {
+	return rule.allowsPrefix(upload.Prefix) && listAllows(rule.Topics, upload.Topic) && listAllows(rule.Datasets, upload.Dataset)
+}
+
+func (rule Rule) allowsPrefix(prefix string) bool {
+	if len(rule.Prefixes) == 0 {
+		return true
+	}
+	for _, allowed := range rule.Prefixes {
+		allowed = strings.Trim(allowed, "/")
+		if prefix == allowed || (len(allowed) > 0 && strings.HasPrefix(prefix, allowed+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+func listAllows(allowed []string, value string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if a == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package policy_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/auth"
+	"github.com/ONSdigital/dp-dd-file-uploader/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAuthorize(t *testing.T) {
+
+	Convey("Given a policy giving the census and prices teams their own datasets", t, func() {
+		p, err := policy.New(
+			policy.Rule{Groups: []string{"census"}, Prefixes: []string{"census"}, Topics: []string{"census-uploaded"}, Datasets: []string{"CENSUS-2021"}, MaxSize: "1GB"},
+			policy.Rule{Groups: []string{"prices"}, Prefixes: []string{"cpi"}, Datasets: []string{"CPI"}, MaxSize: "10MB"},
+			policy.Rule{Users: []string{"admin"}},
+		)
+		So(err, ShouldBeNil)
+
+		census := &auth.Identity{Subject: "alice", Groups: []string{"census"}}
+		prices := &auth.Identity{Subject: "bob", Groups: []string{"prices"}}
+
+		Convey("Then a team can upload its own dataset, within its size limit", func() {
+			grant, err := p.Authorize(census, policy.Upload{Prefix: "census/2021", Topic: "census-uploaded", Dataset: "CENSUS-2021"})
+			So(err, ShouldBeNil)
+			So(grant.MaxSize, ShouldEqual, 1<<30)
+			So(grant.CheckSize(1<<30), ShouldBeNil)
+			So(grant.CheckSize(1<<30+1), ShouldHaveSameTypeAs, &policy.DeniedError{})
+		})
+
+		Convey("Then a team can't upload over another team's dataset", func() {
+			_, err := p.Authorize(prices, policy.Upload{Prefix: "census", Topic: "census-uploaded", Dataset: "CENSUS-2021"})
+			So(err, ShouldHaveSameTypeAs, &policy.DeniedError{})
+			So(err.Error(), ShouldEqual, "bob is not allowed to upload to dataset CENSUS-2021 at prefix census and topic census-uploaded.")
+		})
+
+		Convey("Then a prefix only allows prefixes beneath it, not others starting with the same name", func() {
+			_, err := p.Authorize(prices, policy.Upload{Prefix: "cpi-archive", Topic: "file-uploaded", Dataset: "CPI"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then a user named by a rule without destinations can upload anywhere, without a size limit", func() {
+			grant, err := p.Authorize(&auth.Identity{Subject: "admin"}, policy.Upload{Prefix: "cpi", Topic: "file-uploaded"})
+			So(err, ShouldBeNil)
+			So(grant.MaxSize, ShouldEqual, 0)
+		})
+
+		Convey("Then users no rule applies to can't upload at all", func() {
+			_, err := p.Authorize(&auth.Identity{Subject: "mallory"}, policy.Upload{Topic: "file-uploaded"})
+			So(err.Error(), ShouldEqual, "mallory is not allowed to upload files.")
+
+			_, err = p.Authorize(nil, policy.Upload{Topic: "file-uploaded"})
+			So(err.Error(), ShouldEqual, "anonymous is not allowed to upload files.")
+		})
+	})
+
+	Convey("Given a policy letting anyone upload small files without a prefix", t, func() {
+		p, err := policy.New(policy.Rule{Users: []string{policy.Anyone}, Prefixes: []string{"/"}, MaxSize: "1MB"})
+		So(err, ShouldBeNil)
+
+		Convey("Then anonymous uploads without a prefix are allowed", func() {
+			grant, err := p.Authorize(nil, policy.Upload{Topic: "file-uploaded"})
+			So(err, ShouldBeNil)
+			So(grant.MaxSize, ShouldEqual, 1<<20)
+		})
+
+		Convey("Then uploads with a prefix are not", func() {
+			_, err := p.Authorize(nil, policy.Upload{Prefix: "census", Topic: "file-uploaded"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestLoad(t *testing.T) {
+
+	Convey("Given a policy file containing a rule that applies to no one", t, func() {
+		file, _ := ioutil.TempFile("", "policy-")
+		defer os.Remove(file.Name())
+		file.WriteString(`{"rules": [{"prefixes": ["census"]}]}`)
+		file.Close()
+
+		Convey("When the file is loaded", func() {
+			_, err := policy.Load(file.Name())
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given a policy file with an invalid size", t, func() {
+		file, _ := ioutil.TempFile("", "policy-")
+		defer os.Remove(file.Name())
+		file.WriteString(`{"rules": [{"groups": ["census"], "maxSize": "lots"}]}`)
+		file.Close()
+
+		Convey("When the file is loaded", func() {
+			_, err := policy.Load(file.Name())
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given a valid policy file", t, func() {
+		file, _ := ioutil.TempFile("", "policy-")
+		defer os.Remove(file.Name())
+		file.WriteString(`{"rules": [{"groups": ["census"], "datasets": ["CENSUS-2021"], "maxSize": "500MB"}]}`)
+		file.Close()
+
+		Convey("When the file is loaded", func() {
+			p, err := policy.Load(file.Name())
+
+			Convey("Then its rules are used to authorise uploads", func() {
+				So(err, ShouldBeNil)
+				grant, err := p.Authorize(&auth.Identity{Subject: "alice", Groups: []string{"census"}}, policy.Upload{Dataset: "CENSUS-2021"})
+				So(err, ShouldBeNil)
+				So(grant.MaxSize, ShouldEqual, 500<<20)
+			})
+		})
+	})
+}
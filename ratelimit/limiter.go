@@ -0,0 +1,225 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Limits are the most a single user, or client IP, can upload. Zero means no limit.
+type Limits struct {
+	// Concurrent is the number of uploads that can be received at the same time.
+	Concurrent int
+	// UploadsPerHour is the number of uploads that can be started in each clock hour.
+	UploadsPerHour int
+	// BytesPerDay is the number of bytes that can be uploaded in each UTC day.
+	BytesPerDay int64
+}
+
+func (l Limits) enabled() bool {
+	return l.Concurrent > 0 || l.UploadsPerHour > 0 || l.BytesPerDay > 0
+}
+
+// concurrentTTL is when a count of concurrent uploads expires, so uploads that never end, e.g.
+// because an instance sharing the store crashed, aren't counted forever.
+const concurrentTTL = 24 * time.Hour
+
+// concurrentRetryAfter is how long a client is asked to wait for another upload to finish.
+const concurrentRetryAfter = 30 * time.Second
+
+// LimitError is returned when an upload would take a user or client IP over one of its limits.
+type LimitError struct {
+	// Who is the user or client IP that reached the limit.
+	Who string
+	// Limit describes the limit reached, e.g. "100 uploads an hour".
+	Limit string
+	// RetryAfter is how long until an upload could be allowed.
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s has reached the limit of %s, please try again in %s.", e.Who, e.Limit, describeWait(e.RetryAfter))
+}
+
+// Limiter limits the uploads of each user and client IP, counting them in a store.
+type Limiter struct {
+	Store Store
+	User  Limits
+	IP    Limits
+
+	now func() time.Time
+}
+
+// New creates a limiter applying the user limits to each authenticated user and the IP limits to
+// each client IP, counting uploads in the store.
+func New(store Store, user Limits, ip Limits) *Limiter {
+	return &Limiter{Store: store, User: user, IP: ip, now: time.Now}
+}
+
+// subject is a user or client IP that uploads are limited for.
+type subject struct {
+	key    string
+	who    string
+	limits Limits
+}
+
+// Upload is an upload that has been allowed to start, and must be ended once it has been received.
+type Upload struct {
+	limiter *Limiter
+	started []subject
+}
+
+// Begin checks an upload of size bytes by the user, if there is one, from the client IP is within
+// their limits, returning a LimitError if it isn't. Other errors are from the store.
+func (l *Limiter) Begin(user string, ip string, size int64) (*Upload, error) {
+	var subjects []subject
+	if len(user) > 0 && l.User.enabled() {
+		subjects = append(subjects, subject{key: "user:" + user, who: user, limits: l.User})
+	}
+	if len(ip) > 0 && l.IP.enabled() {
+		subjects = append(subjects, subject{key: "ip:" + ip, who: ip, limits: l.IP})
+	}
+
+	upload := &Upload{limiter: l}
+	for _, s := range subjects {
+		if err := upload.begin(s, size); err != nil {
+			upload.End(0)
+			return nil, err
+		}
+	}
+	return upload, nil
+}
+
+func (u *Upload) begin(s subject, size int64) error {
+	store, now := u.limiter.Store, u.limiter.now()
+
+	if s.limits.Concurrent > 0 {
+		key := s.key + ":concurrent"
+		n, err := store.Add(key, 1, concurrentTTL)
+		if err != nil {
+			return err
+		}
+		if n > int64(s.limits.Concurrent) {
+			store.Add(key, -1, concurrentTTL)
+			return &LimitError{Who: s.who, Limit: plural(s.limits.Concurrent, "upload") + " at a time", RetryAfter: concurrentRetryAfter}
+		}
+	}
+	u.started = append(u.started, s)
+
+	if err := u.countHourly(s, now); err != nil {
+		return err
+	}
+
+	if s.limits.BytesPerDay > 0 {
+		n, err := store.Add(s.key+":day:"+window(now, 24*time.Hour), 0, 24*time.Hour)
+		if err != nil {
+			return err
+		}
+		if n+size > s.limits.BytesPerDay {
+			return &LimitError{Who: s.who, Limit: formatSize(s.limits.BytesPerDay) + " a day", RetryAfter: untilNext(now, 24*time.Hour)}
+		}
+	}
+	return nil
+}
+
+// countHourly counts an upload against the subject's hourly limit.
+func (u *Upload) countHourly(s subject, now time.Time) error {
+	if s.limits.UploadsPerHour == 0 {
+		return nil
+	}
+	store := u.limiter.Store
+	key := s.key + ":hour:" + window(now, time.Hour)
+	n, err := store.Add(key, 1, time.Hour)
+	if err != nil {
+		return err
+	}
+	if n > int64(s.limits.UploadsPerHour) {
+		store.Add(key, -1, time.Hour)
+		return &LimitError{Who: s.who, Limit: plural(s.limits.UploadsPerHour, "upload") + " an hour", RetryAfter: untilNext(now, time.Hour)}
+	}
+	return nil
+}
+
+// Next counts another file of the upload, e.g. in a multipart request, against the hourly limits,
+// returning a LimitError if it would take the user or client IP over one. The first file was counted
+// when the upload began.
+func (u *Upload) Next() error {
+	if u == nil {
+		return nil
+	}
+	now := u.limiter.now()
+	for _, s := range u.started {
+		if err := u.countHourly(s, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// End counts the bytes received against the daily limits, and lets the next upload start.
+func (u *Upload) End(received int64) error {
+	if u == nil {
+		return nil
+	}
+
+	var err error
+	store, now := u.limiter.Store, u.limiter.now()
+	keep := func(e error) {
+		if err == nil {
+			err = e
+		}
+	}
+
+	for _, s := range u.started {
+		if s.limits.Concurrent > 0 {
+			_, e := store.Add(s.key+":concurrent", -1, concurrentTTL)
+			keep(e)
+		}
+		if s.limits.BytesPerDay > 0 && received > 0 {
+			_, e := store.Add(s.key+":day:"+window(now, 24*time.Hour), received, 24*time.Hour)
+			keep(e)
+		}
+	}
+	u.started = nil
+	return err
+}
+
+// window names the fixed window of the given length that t falls in.
+func window(t time.Time, length time.Duration) string {
+	return strconv.FormatInt(t.Unix()/int64(length/time.Second), 10)
+}
+
+// untilNext returns the time from t until the start of the next window of the given length.
+func untilNext(t time.Time, length time.Duration) time.Duration {
+	return t.Truncate(length).Add(length).Sub(t)
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return strconv.Itoa(n) + " " + noun + "s"
+}
+
+func describeWait(d time.Duration) string {
+	switch minutes := int((d + time.Minute - 1) / time.Minute); {
+	case minutes <= 1:
+		return "a minute"
+	case minutes < 120:
+		return strconv.Itoa(minutes) + " minutes"
+	default:
+		return strconv.Itoa(minutes/60) + " hours"
+	}
+}
+
+func formatSize(bytes int64) string {
+	for _, unit := range []struct {
+		size   int64
+		suffix string
+	}{{1 << 30, "GB"}, {1 << 20, "MB"}, {1 << 10, "KB"}} {
+		if bytes >= unit.size && bytes%unit.size == 0 {
+			return strconv.FormatInt(bytes/unit.size, 10) + unit.suffix
+		}
+	}
+	return plural(int(bytes), "byte")
+}
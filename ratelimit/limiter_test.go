@@ -0,0 +1,111 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/ratelimit"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLimiter(t *testing.T) {
+
+	Convey("Given a limit of one upload at a time per user", t, func() {
+		limiter := ratelimit.New(ratelimit.NewMemoryStore(), ratelimit.Limits{Concurrent: 1}, ratelimit.Limits{})
+
+		upload, err := limiter.Begin("alice", "192.0.2.1", 100)
+		So(err, ShouldBeNil)
+
+		Convey("Then the user can't start another upload until the first ends", func() {
+			_, err := limiter.Begin("alice", "192.0.2.1", 100)
+			So(err, ShouldHaveSameTypeAs, &ratelimit.LimitError{})
+			So(err.Error(), ShouldEqual, "alice has reached the limit of 1 upload at a time, please try again in a minute.")
+
+			So(upload.End(100), ShouldBeNil)
+			_, err = limiter.Begin("alice", "192.0.2.1", 100)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Then other users can upload at the same time", func() {
+			_, err := limiter.Begin("bob", "192.0.2.1", 100)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Given a limit of two uploads an hour per client IP", t, func() {
+		limiter := ratelimit.New(ratelimit.NewMemoryStore(), ratelimit.Limits{}, ratelimit.Limits{UploadsPerHour: 2})
+
+		for i := 0; i < 2; i++ {
+			upload, err := limiter.Begin("", "192.0.2.1", 100)
+			So(err, ShouldBeNil)
+			upload.End(100)
+		}
+
+		Convey("Then a third upload from the same IP is refused until the next hour", func() {
+			_, err := limiter.Begin("alice", "192.0.2.1", 100)
+			So(err, ShouldNotBeNil)
+			limit := err.(*ratelimit.LimitError)
+			So(limit.Who, ShouldEqual, "192.0.2.1")
+			So(limit.Limit, ShouldEqual, "2 uploads an hour")
+			So(limit.RetryAfter, ShouldBeLessThanOrEqualTo, time.Hour)
+		})
+
+		Convey("Then uploads from other IPs are allowed", func() {
+			_, err := limiter.Begin("", "192.0.2.2", 100)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Given a limit of two uploads an hour per user", t, func() {
+		limiter := ratelimit.New(ratelimit.NewMemoryStore(), ratelimit.Limits{UploadsPerHour: 2}, ratelimit.Limits{})
+
+		upload, err := limiter.Begin("alice", "192.0.2.1", 100)
+		So(err, ShouldBeNil)
+
+		Convey("Then each file of an upload is counted against the limit", func() {
+			So(upload.Next(), ShouldBeNil)
+			err := upload.Next()
+			So(err, ShouldHaveSameTypeAs, &ratelimit.LimitError{})
+			So(err.(*ratelimit.LimitError).Limit, ShouldEqual, "2 uploads an hour")
+
+			_, err = limiter.Begin("alice", "192.0.2.1", 100)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a limit of 1KB a day per user", t, func() {
+		limiter := ratelimit.New(ratelimit.NewMemoryStore(), ratelimit.Limits{BytesPerDay: 1024}, ratelimit.Limits{})
+
+		upload, err := limiter.Begin("alice", "192.0.2.1", 600)
+		So(err, ShouldBeNil)
+		upload.End(600)
+
+		Convey("Then an upload that would take the user over the limit is refused", func() {
+			_, err := limiter.Begin("alice", "192.0.2.1", 600)
+			So(err, ShouldNotBeNil)
+			So(err.(*ratelimit.LimitError).Limit, ShouldEqual, "1KB a day")
+		})
+
+		Convey("Then an upload within the rest of the limit is allowed", func() {
+			_, err := limiter.Begin("alice", "192.0.2.1", 424)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Given a user limited to one upload at a time, from an IP limited to one upload an hour", t, func() {
+		limiter := ratelimit.New(ratelimit.NewMemoryStore(), ratelimit.Limits{Concurrent: 1}, ratelimit.Limits{UploadsPerHour: 1})
+
+		upload, _ := limiter.Begin("alice", "192.0.2.1", 100)
+		upload.End(100)
+
+		Convey("When an upload is refused by the IP limit", func() {
+			_, err := limiter.Begin("alice", "192.0.2.1", 100)
+			So(err, ShouldNotBeNil)
+
+			Convey("Then it doesn't count against the user's concurrent uploads", func() {
+				_, err := limiter.Begin("alice", "192.0.2.2", 100)
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
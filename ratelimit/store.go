@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Store holds the counters limits are checked against. The in-memory store limits each instance of
+// the service separately; a store shared between instances, e.g. backed by Redis, limits them together.
+type Store interface {
+	// Add adds delta to the counter for key, creating it to expire after ttl if it doesn't exist,
+	// and returns its new value.
+	Add(key string, delta int64, ttl time.Duration) (int64, error)
+}
+
+// MemoryStore is a Store holding counters in memory.
+type MemoryStore struct {
+	mutex    sync.Mutex
+	counters map[string]*counter
+	now      func() time.Time
+	swept    time.Time
+}
+
+type counter struct {
+	value   int64
+	expires time.Time
+}
+
+// sweepInterval is how often expired counters are removed from memory.
+const sweepInterval = time.Minute
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counters: make(map[string]*counter), now: time.Now}
+}
+
+// Add implements Store. Counters that fall to zero or below are removed.
+func (s *MemoryStore) Add(key string, delta int64, ttl time.Duration) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	if now.Sub(s.swept) > sweepInterval {
+		for k, c := range s.counters {
+			if !now.Before(c.expires) {
+				delete(s.counters, k)
+			}
+		}
+		s.swept = now
+	}
+
+	c, ok := s.counters[key]
+	if !ok || !now.Before(c.expires) {
+		c = &counter{expires: now.Add(ttl)}
+		s.counters[key] = c
+	}
+	c.value += delta
+	if c.value <= 0 {
+		delete(s.counters, key)
+	}
+	return c.value, nil
+}
@@ -1,17 +1,65 @@
 package render
 
 import (
-	"github.com/unrolled/render"
+	"html/template"
 	"io"
 	"net/http"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/assets"
+	"github.com/ONSdigital/dp-dd-file-uploader/auth"
+	"github.com/unrolled/render"
 )
 
-type renderer interface {
+// Renderer renders the HTML templates.
+type Renderer interface {
 	HTML(w io.Writer, status int, name string, binding interface{}, htmlOpt ...render.HTMLOptions) error
 }
 
-var Renderer renderer
+// New creates a renderer for the templates embedded in the assets package.
+func New() Renderer {
+	return render.New(render.Options{
+		Asset:      assets.Asset,
+		AssetNames: assets.AssetNames,
+		Funcs: []template.FuncMap{{
+			"safeHTML": func(s string) template.HTML {
+				return template.HTML(s)
+			},
+		}},
+	})
+}
+
+// HomePage is the data the upload form is rendered with.
+type HomePage struct {
+	// CSRFToken is sent back with the form, to show it was submitted from this page.
+	CSRFToken string
+	// Message is shown above the form, e.g. to say why an upload was refused.
+	Message string
+	// Results are the files just uploaded, and whether each was accepted.
+	Results []FileResult
+}
+
+// FileResult is the outcome of one of the files uploaded from the form.
+type FileResult struct {
+	Filename string
+	// ID is the ID of the upload the file was queued as, so the page can follow its progress.
+	ID string
+	// Error is why the file was refused, if it was.
+	Error string
+}
+
+// Home renders the upload form, with a CSRF token for the browser.
+func Home(renderer Renderer, w http.ResponseWriter, req *http.Request) error {
+	return HomeMessage(renderer, w, req, http.StatusAccepted, "")
+}
+
+// HomeMessage renders the upload form with the given status and a message shown above it.
+func HomeMessage(renderer Renderer, w http.ResponseWriter, req *http.Request, status int, message string) error {
+	page := HomePage{CSRFToken: auth.CSRFToken(w, req), Message: message}
+	return renderer.HTML(w, status, "index", page)
+}
 
-func Home(w io.Writer) error {
-	return Renderer.HTML(w, http.StatusAccepted, "index", "")
+// HomeResults renders the upload form with the given status and the outcome of each file just uploaded.
+func HomeResults(renderer Renderer, w http.ResponseWriter, req *http.Request, status int, results []FileResult) error {
+	page := HomePage{CSRFToken: auth.CSRFToken(w, req), Results: results}
+	return renderer.HTML(w, status, "index", page)
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/aws"
+	"github.com/ONSdigital/dp-dd-file-uploader/config"
+	"github.com/ONSdigital/dp-dd-file-uploader/event/kafka"
+	"github.com/ONSdigital/dp-dd-file-uploader/file/s3"
+	"github.com/ONSdigital/dp-dd-file-uploader/replay"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// replayCommand re-emits file uploaded events for files already stored in S3, e.g.
+//
+//	dp-dd-file-uploader replay --prefix census --since 2017-01-02 --rate 10
+func replayCommand(args []string, cfg *config.Config, s3Config *aws.Config) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	prefix := flags.String("prefix", "", "only replay files under this prefix, relative to S3_URL, matching whole path segments")
+	since := flags.String("since", "", "only replay files stored at or after this date (2006-01-02) or time (RFC3339)")
+	topic := flags.String("topic", "", "send every event to this topic instead of using the routing rules")
+	dryRun := flags.Bool("dry-run", false, "log the events that would be sent without sending them")
+	rate := flags.Float64("rate", 0, "maximum events to send per second, 0 for no limit")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	options := replay.Options{
+		Prefix: *prefix,
+		Topic:  *topic,
+		DryRun: *dryRun,
+		Rate:   *rate,
+	}
+	if len(*since) > 0 {
+		var err error
+		if options.Since, err = parseSince(*since); err != nil {
+			return err
+		}
+	}
+	if options.Rate < 0 {
+		return errors.New("The replay rate must not be negative")
+	}
+
+	routes, err := loadRoutes(cfg)
+	if err != nil {
+		return err
+	}
+
+	store := s3.NewFileStore(s3Config)
+	replayer := &replay.Replayer{
+		Lister:   store,
+		Reader:   store,
+		S3Config: s3Config,
+		Routes:   routes,
+	}
+
+	// A dry run never sends events, so doesn't need a connection to Kafka.
+	if !options.DryRun {
+		producer, err := kafka.NewProducer(cfg.KafkaAddr, cfg.TopicName, cfg.EventEncoding, cfg.EventSource)
+		if err != nil {
+			return err
+		}
+		defer producer.Close()
+		replayer.EventProducer = producer
+	}
+
+	sent, err := replayer.Run(options)
+	log.Debug("Replay finished", log.Data{"sent": sent})
+	return err
+}
+
+func parseSince(value string) (time.Time, error) {
+	if since, err := time.Parse("2006-01-02", value); err == nil {
+		return since, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
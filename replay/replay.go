@@ -0,0 +1,147 @@
+package replay
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/aws"
+	"github.com/ONSdigital/dp-dd-file-uploader/event"
+	"github.com/ONSdigital/dp-dd-file-uploader/file"
+	"github.com/ONSdigital/dp-dd-file-uploader/metadata"
+	"github.com/ONSdigital/dp-dd-file-uploader/routing"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// Options control which stored files are replayed and how.
+type Options struct {
+	// Prefix limits the replay to files under this prefix, relative to the configured S3 path. It
+	// matches whole path segments, so 2017 matches 2017/a.csv but not 2017-old/a.csv.
+	Prefix string
+	// Since limits the replay to files last modified at or after this time.
+	Since time.Time
+	// Topic overrides the topic from the routing table if set.
+	Topic string
+	// DryRun logs the events that would be sent without sending them.
+	DryRun bool
+	// Rate is the maximum number of events to send per second. Zero means no limit.
+	Rate float64
+}
+
+// Replayer re-emits file uploaded events for files that are already stored.
+type Replayer struct {
+	Lister file.Lister
+	// Reader, if set, reads the dataset metadata each file was uploaded with, so it is routed by its
+	// dataset as it was when it was uploaded.
+	Reader        file.Reader
+	EventProducer event.Producer
+	S3Config      *aws.Config
+	Routes        *routing.Table
+}
+
+// Run sends a file uploaded event for each matching stored file, returning the number of events sent.
+func (r *Replayer) Run(options Options) (int, error) {
+	context := "replay"
+
+	prefix := strings.Trim(options.Prefix, "/")
+	if len(prefix) > 0 {
+		prefix += "/"
+	}
+	files, err := r.Lister.ListFiles(prefix, options.Since)
+	if err != nil {
+		return 0, err
+	}
+	sidecars := make(map[string]bool)
+	for _, info := range files {
+		if strings.HasSuffix(info.Filename, metadata.SidecarSuffix) {
+			sidecars[strings.TrimSuffix(info.Filename, metadata.SidecarSuffix)] = true
+		}
+	}
+	log.DebugC(context, "Replaying file uploaded events", log.Data{
+		"prefix": options.Prefix,
+		"since":  options.Since,
+		"files":  len(files),
+		"dryRun": options.DryRun,
+	})
+
+	var throttle <-chan time.Time
+	if options.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / options.Rate))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	sent := 0
+	for _, info := range files {
+		// Metadata stored alongside an upload isn't an upload itself
+		if strings.HasSuffix(info.Filename, metadata.SidecarSuffix) {
+			continue
+		}
+
+		meta, err := r.metadata(info.Filename, sidecars[info.Filename])
+		if err != nil {
+			log.ErrorC(context, err, log.Data{"message": "Failed to read the metadata of the file", "filename": info.Filename})
+			return sent, err
+		}
+
+		topic := options.Topic
+		if len(topic) == 0 {
+			topic = r.Routes.Match(path.Base(info.Filename), routing.Format(info.Filename), meta.DatasetID).Topic
+		}
+
+		uploadedEvent := event.FileUploaded{
+			Time:      info.LastModified.UTC().Unix(),
+			S3URL:     r.S3Config.GetS3FileURL(info.Filename),
+			RequestID: context,
+		}
+		if !meta.IsZero() {
+			uploadedEvent.Metadata = &meta
+		}
+
+		data := log.Data{"s3URL": uploadedEvent.S3URL, "topic": topic, "dataset": meta.DatasetID}
+		if options.DryRun {
+			log.DebugC(context, "Dry run - not sending event", data)
+			continue
+		}
+
+		if throttle != nil {
+			<-throttle
+		}
+
+		if err = r.EventProducer.FileUploadedToTopic(topic, uploadedEvent); err != nil {
+			log.ErrorC(context, err, data)
+			return sent, err
+		}
+		log.DebugC(context, "Sent event", data)
+		sent++
+	}
+
+	return sent, nil
+}
+
+// metadata returns the dataset metadata a file was uploaded with, from its sidecar file if it has one
+// and otherwise from its object metadata.
+func (r *Replayer) metadata(filename string, hasSidecar bool) (metadata.Metadata, error) {
+	if r.Reader == nil {
+		return metadata.Metadata{}, nil
+	}
+
+	if hasSidecar {
+		body, err := r.Reader.ReadFile(filename + metadata.SidecarSuffix)
+		if err != nil {
+			return metadata.Metadata{}, err
+		}
+		defer body.Close()
+
+		var meta metadata.Metadata
+		err = json.NewDecoder(body).Decode(&meta)
+		return meta, err
+	}
+
+	objectMetadata, err := r.Reader.FileMetadata(filename)
+	if err != nil {
+		return metadata.Metadata{}, err
+	}
+	return metadata.FromObjectMetadata(objectMetadata), nil
+}
@@ -0,0 +1,121 @@
+package replay_test
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/aws"
+	"github.com/ONSdigital/dp-dd-file-uploader/event/eventtest"
+	"github.com/ONSdigital/dp-dd-file-uploader/file"
+	"github.com/ONSdigital/dp-dd-file-uploader/file/filetest"
+	"github.com/ONSdigital/dp-dd-file-uploader/metadata"
+	"github.com/ONSdigital/dp-dd-file-uploader/replay"
+	"github.com/ONSdigital/dp-dd-file-uploader/routing"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRun(t *testing.T) {
+
+	s3URL, _ := url.Parse("s3://bucket1/dir")
+	day := time.Date(2017, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	Convey("Given a store containing files from before and after the replay date, and the metadata of one", t, func() {
+		fileStore := filetest.NewDummyFileStore()
+		fileStore.Files = []file.Info{
+			{Filename: "old.csv", LastModified: day.Add(-time.Hour)},
+			{Filename: "new.csv", LastModified: day.Add(time.Hour)},
+			{Filename: "census/census-2011.csv", LastModified: day.Add(2 * time.Hour)},
+			{Filename: "census/census-2011.csv.metadata.json", LastModified: day.Add(2 * time.Hour)},
+		}
+		eventProducer := eventtest.NewDummyEventProducer()
+
+		replayer := &replay.Replayer{
+			Lister:        fileStore,
+			EventProducer: eventProducer,
+			S3Config:      aws.NewAWSConfig("region1", s3URL),
+			Routes:        routing.NewTable("file-uploaded", routing.Rule{Filename: "census-*", Topic: "census-file-uploaded"}),
+		}
+
+		Convey("When the replay is run", func() {
+			sent, err := replayer.Run(replay.Options{Since: day})
+
+			Convey("Then an event is sent for each file since the replay date to its routed topic", func() {
+				So(err, ShouldBeNil)
+				So(sent, ShouldEqual, 2)
+				So(eventProducer.Topics, ShouldResemble, []string{"file-uploaded", "census-file-uploaded"})
+			})
+		})
+
+		Convey("When the replay is run with a topic override", func() {
+			sent, err := replayer.Run(replay.Options{Since: day, Topic: "replayed"})
+
+			Convey("Then every event is sent to the given topic", func() {
+				So(err, ShouldBeNil)
+				So(sent, ShouldEqual, 2)
+				So(eventProducer.Topics, ShouldResemble, []string{"replayed", "replayed"})
+			})
+		})
+
+		Convey("When the replay is run with a prefix", func() {
+			fileStore.Files = append(fileStore.Files, file.Info{Filename: "census-old/census-2001.csv", LastModified: day.Add(time.Hour)})
+			sent, err := replayer.Run(replay.Options{Prefix: "census", Since: day})
+
+			Convey("Then only files under the whole prefix are replayed", func() {
+				So(err, ShouldBeNil)
+				So(sent, ShouldEqual, 1)
+				So(eventProducer.Topics, ShouldResemble, []string{"census-file-uploaded"})
+			})
+		})
+
+		Convey("When a dry run is run", func() {
+			sent, err := replayer.Run(replay.Options{Since: day, DryRun: true})
+
+			Convey("Then no events are sent", func() {
+				So(err, ShouldBeNil)
+				So(sent, ShouldEqual, 0)
+				So(eventProducer.Invocations, ShouldEqual, 0)
+			})
+		})
+	})
+
+	Convey("Given stored files uploaded with datasets, in object metadata and in a metadata file", t, func() {
+		fileStore := filetest.NewDummyFileStore()
+		fileStore.Files = []file.Info{
+			{Filename: "cpi.csv", LastModified: day},
+			{Filename: "gdp.csv", LastModified: day},
+			{Filename: "gdp.csv.metadata.json", LastModified: day},
+			{Filename: "other.csv", LastModified: day},
+		}
+		fileStore.ObjectMetadata = map[string]map[string]string{
+			"cpi.csv": {"dataset-id": "CPI", "release-date": "2017-06-30"},
+		}
+		fileStore.Contents = map[string]string{
+			"gdp.csv.metadata.json": `{"datasetId": "GDP"}`,
+		}
+		eventProducer := eventtest.NewDummyEventProducer()
+
+		replayer := &replay.Replayer{
+			Lister:        fileStore,
+			Reader:        fileStore,
+			EventProducer: eventProducer,
+			S3Config:      aws.NewAWSConfig("region1", s3URL),
+			Routes: routing.NewTable("file-uploaded",
+				routing.Rule{Dataset: "CPI", Topic: "prices-file-uploaded"},
+				routing.Rule{Dataset: "GDP", Topic: "gdp-file-uploaded"}),
+		}
+
+		Convey("When the replay is run", func() {
+			sent, err := replayer.Run(replay.Options{})
+
+			Convey("Then each file is routed by its dataset, and its event has its metadata", func() {
+				So(err, ShouldBeNil)
+				So(sent, ShouldEqual, 3)
+				So(eventProducer.Topics, ShouldResemble, []string{"prices-file-uploaded", "gdp-file-uploaded", "file-uploaded"})
+				So(eventProducer.Events[0].Metadata, ShouldResemble, &metadata.Metadata{DatasetID: "CPI", ReleaseDate: "2017-06-30"})
+				So(eventProducer.Events[1].Metadata, ShouldResemble, &metadata.Metadata{DatasetID: "GDP"})
+				So(eventProducer.Events[2].Metadata, ShouldBeNil)
+			})
+		})
+	})
+}
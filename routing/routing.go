@@ -0,0 +1,169 @@
+package routing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// Rule routes uploads matching all of its non-empty criteria to a topic and S3 prefix.
+type Rule struct {
+	// Filename is a glob pattern, as used by path.Match, matched against the stored filename.
+	Filename string `json:"filename,omitempty"`
+	// Format is the detected format of the uploaded file, e.g. csv or zip.
+	Format string `json:"format,omitempty"`
+	// Dataset is matched against the dataset form field of the upload.
+	Dataset string `json:"dataset,omitempty"`
+
+	// Topic is the Kafka topic to send the event to. The default topic is used if empty.
+	Topic string `json:"topic,omitempty"`
+	// Prefix is prepended to the filename to give the S3 key, relative to the configured S3 path.
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// Route is the destination of an upload.
+type Route struct {
+	Topic  string
+	Prefix string
+}
+
+// Table is an ordered list of rules. The first matching rule wins.
+type Table struct {
+	DefaultTopic string `json:"-"`
+	Rules        []Rule `json:"rules"`
+}
+
+// NewTable creates a routing table with the given default topic and rules.
+func NewTable(defaultTopic string, rules ...Rule) *Table {
+	return &Table{DefaultTopic: defaultTopic, Rules: rules}
+}
+
+// Load reads a routing table from the given JSON file.
+func Load(filename string, defaultTopic string) (*Table, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	table := NewTable(defaultTopic)
+	decoder := json.NewDecoder(f)
+	if err = decoder.Decode(table); err != nil {
+		return nil, fmt.Errorf("Failed to parse routing file %s: %v", filename, err)
+	}
+
+	if err = table.Validate(); err != nil {
+		return nil, err
+	}
+
+	return table, nil
+}
+
+// Validate checks each of the rules in the table is usable.
+func (t *Table) Validate() error {
+	for i, rule := range t.Rules {
+		if len(rule.Filename) == 0 && len(rule.Format) == 0 && len(rule.Dataset) == 0 {
+			return fmt.Errorf("Routing rule %d has no filename, format or dataset to match on", i)
+		}
+		if len(rule.Topic) == 0 && len(rule.Prefix) == 0 {
+			return fmt.Errorf("Routing rule %d has no topic or prefix", i)
+		}
+		if _, err := path.Match(rule.Filename, ""); err != nil {
+			return fmt.Errorf("Routing rule %d has an invalid filename pattern %q", i, rule.Filename)
+		}
+		if err := validatePrefix(rule.Prefix); err != nil {
+			return fmt.Errorf("Routing rule %d has an invalid prefix %q: %v", i, rule.Prefix, err)
+		}
+	}
+	return nil
+}
+
+// validatePrefix checks a prefix is a relative S3 path that can't escape S3_URL. A trailing '/' is allowed.
+func validatePrefix(prefix string) error {
+	if len(prefix) == 0 {
+		return nil
+	}
+	switch {
+	case strings.HasPrefix(prefix, "/"):
+		return errors.New("it must not start with '/'")
+	case strings.Contains(prefix, ".."):
+		return errors.New("it must not contain '..'")
+	case strings.IndexFunc(prefix, unicode.IsControl) >= 0:
+		return errors.New("it must not contain control characters")
+	}
+	for _, segment := range strings.Split(strings.TrimSuffix(prefix, "/"), "/") {
+		if len(segment) == 0 || segment == "." {
+			return errors.New("it must not contain empty or '.' segments")
+		}
+	}
+	return nil
+}
+
+// Match returns the route for an upload, falling back to the default topic with no prefix.
+func (t *Table) Match(filename string, format string, dataset string) Route {
+	if t == nil {
+		return Route{}
+	}
+
+	for _, rule := range t.Rules {
+		if rule.matches(filename, format, dataset) {
+			route := Route{Topic: rule.Topic, Prefix: strings.Trim(rule.Prefix, "/")}
+			if len(route.Topic) == 0 {
+				route.Topic = t.DefaultTopic
+			}
+			return route
+		}
+	}
+
+	return Route{Topic: t.DefaultTopic}
+}
+
+func (rule Rule) matches(filename string, format string, dataset string) bool {
+	if len(rule.Filename) > 0 {
+		if matched, _ := path.Match(rule.Filename, filename); !matched {
+			return false
+		}
+	}
+	if len(rule.Format) > 0 && !strings.EqualFold(rule.Format, format) {
+		return false
+	}
+	if len(rule.Dataset) > 0 && rule.Dataset != dataset {
+		return false
+	}
+	return true
+}
+
+// Topics returns each of the distinct topics that uploads can be routed to.
+func (t *Table) Topics() []string {
+	if t == nil {
+		return nil
+	}
+
+	topics := []string{t.DefaultTopic}
+	seen := map[string]bool{t.DefaultTopic: true}
+	for _, rule := range t.Rules {
+		if len(rule.Topic) > 0 && !seen[rule.Topic] {
+			seen[rule.Topic] = true
+			topics = append(topics, rule.Topic)
+		}
+	}
+	return topics
+}
+
+// Key returns the filename to store the upload under, including the route prefix.
+func (r Route) Key(filename string) string {
+	if len(r.Prefix) == 0 {
+		return filename
+	}
+	return r.Prefix + "/" + filename
+}
+
+// Format returns the format of an uploaded file, detected from its filename.
+func Format(filename string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+}
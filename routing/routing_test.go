@@ -0,0 +1,109 @@
+package routing_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/routing"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMatch(t *testing.T) {
+
+	Convey("Given a routing table with census and dataset rules", t, func() {
+		table := routing.NewTable("file-uploaded",
+			routing.Rule{Filename: "census-*", Format: "csv", Topic: "census-file-uploaded", Prefix: "census/"},
+			routing.Rule{Dataset: "CPI", Prefix: "cpi"},
+		)
+
+		Convey("When a census csv is matched", func() {
+			route := table.Match("census-2011.csv", "csv", "")
+
+			Convey("Then it is routed to the census topic and prefix", func() {
+				So(route.Topic, ShouldEqual, "census-file-uploaded")
+				So(route.Key("census-2011.csv"), ShouldEqual, "census/census-2011.csv")
+			})
+		})
+
+		Convey("When a census file in another format is matched", func() {
+			route := table.Match("census-2011.csv", "zip", "")
+
+			Convey("Then it is routed to the default topic", func() {
+				So(route.Topic, ShouldEqual, "file-uploaded")
+				So(route.Key("census-2011.csv"), ShouldEqual, "census-2011.csv")
+			})
+		})
+
+		Convey("When a file for a routed dataset is matched", func() {
+			route := table.Match("prices.csv", "csv", "CPI")
+
+			Convey("Then the default topic is used with the rule's prefix", func() {
+				So(route.Topic, ShouldEqual, "file-uploaded")
+				So(route.Key("prices.csv"), ShouldEqual, "cpi/prices.csv")
+			})
+		})
+	})
+
+	Convey("Given a nil routing table", t, func() {
+		var table *routing.Table
+
+		Convey("Then matching returns an empty route", func() {
+			route := table.Match("file.csv", "csv", "")
+			So(route.Topic, ShouldBeBlank)
+			So(route.Key("file.csv"), ShouldEqual, "file.csv")
+		})
+	})
+}
+
+func TestLoad(t *testing.T) {
+
+	Convey("Given a routing file containing a rule with no criteria", t, func() {
+		file, _ := ioutil.TempFile("", "routes-")
+		defer os.Remove(file.Name())
+		file.WriteString(`{"rules": [{"topic": "everything"}]}`)
+		file.Close()
+
+		Convey("When the file is loaded", func() {
+			_, err := routing.Load(file.Name(), "file-uploaded")
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given routing files with prefixes that could escape S3_URL", t, func() {
+		for _, prefix := range []string{"/census", "census//2017", "census/../cpi", "./census", "census\n"} {
+			file, _ := ioutil.TempFile("", "routes-")
+			defer os.Remove(file.Name())
+			rule, _ := json.Marshal(routing.Rule{Dataset: "CENSUS", Prefix: prefix})
+			file.WriteString(`{"rules": [` + string(rule) + `]}`)
+			file.Close()
+
+			Convey("Then loading the file with the prefix "+strconv.Quote(prefix)+" returns an error", func() {
+				_, err := routing.Load(file.Name(), "file-uploaded")
+				So(err, ShouldNotBeNil)
+			})
+		}
+	})
+
+	Convey("Given a valid routing file", t, func() {
+		file, _ := ioutil.TempFile("", "routes-")
+		defer os.Remove(file.Name())
+		file.WriteString(`{"rules": [{"filename": "*.csv", "topic": "csv-uploaded"}]}`)
+		file.Close()
+
+		Convey("When the file is loaded", func() {
+			table, err := routing.Load(file.Name(), "file-uploaded")
+
+			Convey("Then the rules are used to route uploads", func() {
+				So(err, ShouldBeNil)
+				So(table.Match("a.csv", "csv", "").Topic, ShouldEqual, "csv-uploaded")
+				So(table.Match("a.txt", "txt", "").Topic, ShouldEqual, "file-uploaded")
+			})
+		})
+	})
+}
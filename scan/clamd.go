@@ -0,0 +1,187 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The default size of the chunks files are streamed to clamd in. clamd rejects streams larger than
+// its StreamMaxLength setting, whatever the chunk size.
+const defaultChunkSize = 64 * 1024
+
+// Clamd scans files with a clamd daemon, or anything else speaking its protocol, over TCP or a unix socket.
+type Clamd struct {
+	// Network is "tcp" or "unix".
+	Network string
+	Address string
+	// Timeout is the time allowed for a whole scan, including connecting and streaming the file.
+	Timeout   time.Duration
+	ChunkSize int
+}
+
+// NewClamd creates a scanner for the daemon at address, either tcp://host:port or unix:///path/to/socket.
+func NewClamd(address string, timeout time.Duration) (*Clamd, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case u.Scheme == "tcp" && len(u.Host) > 0:
+		return &Clamd{Network: "tcp", Address: u.Host, Timeout: timeout, ChunkSize: defaultChunkSize}, nil
+	case u.Scheme == "unix" && len(u.Path) > 0:
+		return &Clamd{Network: "unix", Address: u.Path, Timeout: timeout, ChunkSize: defaultChunkSize}, nil
+	}
+	return nil, fmt.Errorf("Unsupported clamd address %q, expected tcp://host:port or unix:///path", address)
+}
+
+// Scan streams r to clamd with the INSTREAM command and returns its verdict.
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Result{}, err
+	}
+
+	chunkSize := c.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	chunk := make([]byte, 4+chunkSize)
+	for {
+		n, readErr := io.ReadFull(r, chunk[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(chunk[:4], uint32(n))
+			if _, err = conn.Write(chunk[:4+n]); err != nil {
+				// clamd closes the connection once a stream is too large, but replies first saying so.
+				if reply, replyErr := readReply(conn); replyErr == nil {
+					return parseReply(reply)
+				}
+				return Result{}, err
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return Result{}, readErr
+		}
+	}
+
+	if _, err = conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return Result{}, err
+	}
+
+	reply, err := readReply(conn)
+	if err != nil {
+		return Result{}, err
+	}
+	return parseReply(reply)
+}
+
+// Check returns an error if clamd doesn't answer a PING.
+func (c *Clamd) Check(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte("zPING\x00")); err != nil {
+		return err
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("Unexpected reply from clamd: %q", reply)
+	}
+	return nil
+}
+
+// dial connects to clamd, with a deadline of the scan timeout or the context's deadline, whichever is sooner.
+// The connection is closed as soon as the context is cancelled, so a scan doesn't wait for the deadline.
+func (c *Clamd) dial(ctx context.Context) (net.Conn, error) {
+	cancel := func() {}
+	if c.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	return &contextConn{Conn: conn, ctx: ctx, stop: func() { stop(); cancel() }}, nil
+}
+
+// contextConn is a connection to clamd closed when its context is done, which reads and writes fail
+// with the context's error once it has been.
+type contextConn struct {
+	net.Conn
+	ctx  context.Context
+	stop func()
+}
+
+func (c *contextConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	return n, c.contextErr(err)
+}
+
+func (c *contextConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	return n, c.contextErr(err)
+}
+
+func (c *contextConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
+
+func (c *contextConn) contextErr(err error) error {
+	if err != nil && c.ctx.Err() != nil {
+		return c.ctx.Err()
+	}
+	return err
+}
+
+// readReply reads a null terminated reply from clamd.
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && (err != io.EOF || len(reply) == 0) {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimSuffix(reply, "\x00")), nil
+}
+
+// parseReply interprets clamd's reply to INSTREAM, e.g. "stream: OK" or "stream: Eicar-Signature FOUND".
+func parseReply(reply string) (Result, error) {
+	verdict := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case verdict == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(verdict, " FOUND")}, nil
+	case strings.HasSuffix(verdict, " ERROR"):
+		return Result{}, errors.New("clamd: " + strings.TrimSuffix(verdict, " ERROR"))
+	}
+	return Result{}, fmt.Errorf("Unexpected reply from clamd: %q", reply)
+}
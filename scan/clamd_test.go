@@ -0,0 +1,151 @@
+package scan_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/scan"
+	"github.com/ONSdigital/dp-dd-file-uploader/scan/scantest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeClamd answers PING and INSTREAM like clamd, finding EICAR in streams and rejecting streams longer than maxLength.
+func fakeClamd(listener net.Listener, maxLength int) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			command, err := r.ReadString(0)
+			if err != nil {
+				return
+			}
+			switch command {
+			case "zPING\x00":
+				conn.Write([]byte("PONG\x00"))
+			case "zINSTREAM\x00":
+				var stream bytes.Buffer
+				for {
+					var size uint32
+					if binary.Read(r, binary.BigEndian, &size) != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					if stream.Len()+int(size) > maxLength {
+						conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+						io.Copy(ioutil.Discard, r)
+						return
+					}
+					io.CopyN(&stream, r, int64(size))
+				}
+				if strings.Contains(stream.String(), scantest.EICAR) {
+					conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+				} else {
+					conn.Write([]byte("stream: OK\x00"))
+				}
+			}
+		}(conn)
+	}
+}
+
+func TestClamd(t *testing.T) {
+
+	Convey("Given a clamd daemon", t, func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer listener.Close()
+		go fakeClamd(listener, 1024)
+
+		scanner, err := scan.NewClamd("tcp://"+listener.Addr().String(), 5*time.Second)
+		So(err, ShouldBeNil)
+		scanner.ChunkSize = 16
+
+		Convey("Then it answers health checks", func() {
+			So(scanner.Check(context.Background()), ShouldBeNil)
+		})
+
+		Convey("Then a clean file streamed over several chunks is clean", func() {
+			result, err := scanner.Scan(context.Background(), strings.NewReader(strings.Repeat("observation,", 20)))
+			So(err, ShouldBeNil)
+			So(result.Infected, ShouldBeFalse)
+		})
+
+		Convey("Then an infected file is reported with its signature", func() {
+			result, err := scanner.Scan(context.Background(), strings.NewReader("observation\n"+scantest.EICAR+"\n"))
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, scan.Result{Infected: true, Signature: "Eicar-Test-Signature"})
+		})
+
+		Convey("Then a file larger than the daemon accepts is an error", func() {
+			_, err := scanner.Scan(context.Background(), strings.NewReader(strings.Repeat("x", 2048)))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "size limit exceeded")
+		})
+	})
+
+	Convey("Given a clamd daemon that never replies", t, func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer listener.Close()
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					io.Copy(ioutil.Discard, conn)
+				}()
+			}
+		}()
+
+		scanner, _ := scan.NewClamd("tcp://"+listener.Addr().String(), 5*time.Second)
+
+		Convey("When the scan is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+			started := time.Now()
+			_, err := scanner.Scan(ctx, strings.NewReader("observation"))
+
+			Convey("Then it is aborted without waiting for the timeout", func() {
+				So(err, ShouldEqual, context.Canceled)
+				So(time.Since(started), ShouldBeLessThan, time.Second)
+			})
+		})
+	})
+
+	Convey("Given no clamd daemon is listening", t, func() {
+		listener, _ := net.Listen("tcp", "127.0.0.1:0")
+		address := listener.Addr().String()
+		listener.Close()
+
+		scanner, _ := scan.NewClamd("tcp://"+address, time.Second)
+
+		Convey("Then scanning fails", func() {
+			_, err := scanner.Scan(context.Background(), strings.NewReader("observation"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given an address that isn't tcp or unix", t, func() {
+		_, err := scan.NewClamd("http://localhost:3310", time.Second)
+
+		Convey("Then an error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,19 @@
+package scan
+
+import (
+	"context"
+	"io"
+)
+
+// Result is the outcome of scanning a file.
+type Result struct {
+	Infected bool
+	// Signature is the name of the virus found, if the file is infected.
+	Signature string
+}
+
+// Scanner checks files for viruses.
+type Scanner interface {
+	// Scan reads r to the end, returning whether it is infected, or an error if it couldn't be scanned.
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
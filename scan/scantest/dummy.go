@@ -0,0 +1,45 @@
+package scantest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/scan"
+)
+
+// EICAR is the standard antivirus test file, which every scanner reports as infected.
+const EICAR = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// EICARSignature is the signature reported for files containing EICAR.
+const EICARSignature = "Eicar-Test-Signature"
+
+func NewDummyScanner() *DummyScanner {
+	return &DummyScanner{}
+}
+
+// DummyScanner reports files containing EICAR as infected, and fails to scan files containing "ScanError".
+type DummyScanner struct {
+	Invocations int
+}
+
+func (scanner *DummyScanner) Scan(ctx context.Context, r io.Reader) (scan.Result, error) {
+
+	scanner.Invocations++
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return scan.Result{}, err
+	}
+
+	if bytes.Contains(b, []byte("ScanError")) {
+		return scan.Result{}, errors.New("Error scanning file")
+	}
+	if bytes.Contains(b, []byte(EICAR)) {
+		return scan.Result{Infected: true, Signature: EICARSignature}, nil
+	}
+
+	return scan.Result{}, nil
+}
@@ -3,5 +3,6 @@
 CONTAINER_ID=$(docker ps | grep dp-dd-file-uploader | awk '{print $1}')
 
 if [[ -n $CONTAINER_ID ]]; then
-  docker stop $CONTAINER_ID
+  # Allow longer than SHUTDOWN_TIMEOUT for in-flight uploads to finish
+  docker stop --time=60 $CONTAINER_ID
 fi
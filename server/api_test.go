@@ -0,0 +1,396 @@
+package server_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/auth"
+	"github.com/ONSdigital/dp-dd-file-uploader/handler"
+	"github.com/ONSdigital/dp-dd-file-uploader/metadata"
+	"github.com/ONSdigital/dp-dd-file-uploader/ratelimit"
+	"github.com/ONSdigital/dp-dd-file-uploader/server"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// pathParameter matches the parameters in a documented path.
+var pathParameter = regexp.MustCompile(`\{[^}]+\}`)
+
+// serve sends a request with the given headers to the handler.
+func serve(handler http.Handler, method string, path string, body io.Reader, headers map[string]string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, body)
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func uploadMultipart(handler http.Handler, headers map[string]string) *httptest.ResponseRecorder {
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	headers["Content-Type"] = "multipart/form-data; boundary=boundary"
+	return serve(handler, "POST", "/api/v1/uploads", strings.NewReader(multipartBody), headers)
+}
+
+func decodeUpload(recorder *httptest.ResponseRecorder) handlers.UploadStatus {
+	var upload handlers.UploadStatus
+	json.Unmarshal(recorder.Body.Bytes(), &upload)
+	return upload
+}
+
+// waitForUpload polls the status of an upload until it has finished.
+func waitForUpload(handler http.Handler, location string, headers map[string]string) handlers.UploadStatus {
+	upload := decodeUpload(serve(handler, "GET", location, nil, headers))
+	for i := 0; i < 200 && !upload.Finished(); i++ {
+		time.Sleep(10 * time.Millisecond)
+		upload = decodeUpload(serve(handler, "GET", location, nil, headers))
+	}
+	return upload
+}
+
+// readEvents decodes the status events in an event stream.
+func readEvents(recorder *httptest.ResponseRecorder) []handlers.UploadStatus {
+	var events []handlers.UploadStatus
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		if strings.HasPrefix(line, "data: ") {
+			var upload handlers.UploadStatus
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &upload)
+			events = append(events, upload)
+		}
+	}
+	return events
+}
+
+func TestAPI(t *testing.T) {
+	api, err := loadSpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Given a server", t, func() {
+		srv, fileStore := newServer()
+		handler := srv.HTTPServer.Handler
+
+		Convey("When a file is uploaded as a multipart form", func() {
+			recorder := uploadMultipart(handler, nil)
+			upload := decodeUpload(recorder)
+
+			Convey("Then the queued upload is returned as documented", func() {
+				So(recorder.Code, ShouldEqual, http.StatusAccepted)
+				So(api.check("POST", "/uploads", recorder), ShouldBeEmpty)
+				So(upload.Filename, ShouldEqual, "test.csv")
+				So(upload.SHA256, ShouldHaveLength, 64)
+				So(recorder.Header().Get("Location"), ShouldEqual, "/api/v1/uploads/"+upload.ID)
+			})
+
+			Convey("Then its status can be followed until it has been stored", func() {
+				upload = waitForUpload(handler, recorder.Header().Get("Location"), nil)
+				So(upload.Status, ShouldEqual, handlers.StatusCompleted)
+				So(upload.Key, ShouldNotBeBlank)
+				So(fileStore.Invocations, ShouldEqual, 1)
+				So(api.check("GET", "/uploads/{id}", serve(handler, "GET", recorder.Header().Get("Location"), nil, nil)), ShouldBeEmpty)
+
+				Convey("And it is listed with the other completed uploads", func() {
+					list := serve(handler, "GET", "/api/v1/uploads?status=completed", nil, nil)
+					So(list.Code, ShouldEqual, http.StatusOK)
+					So(api.check("GET", "/uploads", list), ShouldBeEmpty)
+
+					var uploads handlers.UploadList
+					So(json.Unmarshal(list.Body.Bytes(), &uploads), ShouldBeNil)
+					So(uploads.Uploads, ShouldHaveLength, 1)
+					So(uploads.Uploads[0].ID, ShouldEqual, upload.ID)
+				})
+
+				Convey("And it can't be cancelled", func() {
+					cancel := serve(handler, "DELETE", recorder.Header().Get("Location"), nil, nil)
+					So(cancel.Code, ShouldEqual, http.StatusConflict)
+					So(api.check("DELETE", "/uploads/{id}", cancel), ShouldBeEmpty)
+					So(cancel.Body.String(), ShouldContainSubstring, handlers.CodeNotCancellable)
+				})
+			})
+		})
+
+		Convey("When a file is uploaded as the request body", func() {
+			recorder := serve(handler, "POST", "/api/v1/uploads", strings.NewReader("a,b,c\n1,2,3\n"), map[string]string{
+				handlers.FilenameHeader: "data/raw.csv",
+				handlers.DatasetHeader:  "census",
+			})
+
+			Convey("Then it is named by the filename header", func() {
+				So(recorder.Code, ShouldEqual, http.StatusAccepted)
+				So(api.check("POST", "/uploads", recorder), ShouldBeEmpty)
+				upload := decodeUpload(recorder)
+				So(upload.Filename, ShouldEqual, "raw.csv")
+				So(upload.Dataset, ShouldEqual, "census")
+				So(upload.Size, ShouldEqual, 12)
+			})
+		})
+
+		Convey("When a file is uploaded as the request body without a filename", func() {
+			recorder := serve(handler, "POST", "/api/v1/uploads", strings.NewReader("a,b,c\n"), nil)
+
+			Convey("Then a documented error says the filename is missing", func() {
+				So(recorder.Code, ShouldEqual, http.StatusBadRequest)
+				So(api.check("POST", "/uploads", recorder), ShouldBeEmpty)
+				So(recorder.Body.String(), ShouldContainSubstring, `"code":"bad_request"`)
+				So(recorder.Body.String(), ShouldContainSubstring, handlers.FilenameHeader)
+			})
+		})
+
+		Convey("When several files are uploaded in one multipart form", func() {
+			body := strings.Replace(multipartBody, "--boundary--", "--boundary\r\n"+
+				"Content-Disposition: form-data; name=\"file\"; filename=\"second.csv\"\r\n\r\n"+
+				"d,e,f\r\n--boundary--", 1)
+			recorder := serve(handler, "POST", "/api/v1/uploads", strings.NewReader(body), map[string]string{
+				"Content-Type": "multipart/form-data; boundary=boundary",
+			})
+
+			Convey("Then the result for each is listed as documented", func() {
+				So(recorder.Code, ShouldEqual, http.StatusMultiStatus)
+				So(api.check("POST", "/uploads", recorder), ShouldBeEmpty)
+
+				var results handlers.FileResults
+				So(json.Unmarshal(recorder.Body.Bytes(), &results), ShouldBeNil)
+				So(results.Results, ShouldHaveLength, 2)
+				So(results.Results[0].Filename, ShouldEqual, "test.csv")
+				So(results.Results[1].Filename, ShouldEqual, "second.csv")
+				So(results.Results[1].Status, ShouldEqual, http.StatusAccepted)
+				So(waitForUpload(handler, "/api/v1/uploads/"+results.Results[1].Upload.ID, nil).Status, ShouldEqual, handlers.StatusCompleted)
+			})
+		})
+
+		Convey("When a file is put as the request body", func() {
+			recorder := serve(handler, "PUT", "/api/v1/files/put.csv", strings.NewReader("a,b,c\n1,2,3\n"), map[string]string{
+				handlers.DatasetHeader: "census",
+			})
+
+			Convey("Then it is named by the path and processed like any other upload", func() {
+				So(recorder.Code, ShouldEqual, http.StatusAccepted)
+				So(api.check("PUT", "/files/{filename}", recorder), ShouldBeEmpty)
+				upload := decodeUpload(recorder)
+				So(upload.Filename, ShouldEqual, "put.csv")
+				So(upload.Dataset, ShouldEqual, "census")
+				So(waitForUpload(handler, recorder.Header().Get("Location"), nil).Status, ShouldEqual, handlers.StatusCompleted)
+				So(fileStore.Invocations, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When a file is put with dataset metadata", func() {
+			recorder := serve(handler, "PUT", "/api/v1/files/cpi.csv", strings.NewReader("a,b,c\n1,2,3\n"), map[string]string{
+				handlers.DatasetHeader: "CPI",
+				"X-Edition":            "2017",
+				"X-Release-Date":       "2017-06-30",
+			})
+
+			Convey("Then the metadata is returned with the upload as documented", func() {
+				So(recorder.Code, ShouldEqual, http.StatusAccepted)
+				So(api.check("PUT", "/files/{filename}", recorder), ShouldBeEmpty)
+				So(decodeUpload(recorder).Metadata, ShouldResemble, &metadata.Metadata{DatasetID: "CPI", Edition: "2017", ReleaseDate: "2017-06-30"})
+			})
+		})
+
+		Convey("When a file is put with invalid dataset metadata", func() {
+			recorder := serve(handler, "PUT", "/api/v1/files/cpi.csv", strings.NewReader("a,b,c\n1,2,3\n"), map[string]string{
+				"X-Contact": "the prices team",
+			})
+
+			Convey("Then a documented error says what is wrong with it", func() {
+				So(recorder.Code, ShouldEqual, http.StatusBadRequest)
+				So(api.check("PUT", "/files/{filename}", recorder), ShouldBeEmpty)
+				So(recorder.Body.String(), ShouldContainSubstring, `"code":"invalid_metadata"`)
+				So(recorder.Body.String(), ShouldContainSubstring, "Contact must be an email address.")
+			})
+		})
+
+		Convey("When a gzipped file is put", func() {
+			var compressed bytes.Buffer
+			writer := gzip.NewWriter(&compressed)
+			writer.Write([]byte("a,b,c\n1,2,3\n"))
+			writer.Close()
+			recorder := serve(handler, "PUT", "/api/v1/files/put.csv", &compressed, map[string]string{"Content-Encoding": "gzip"})
+
+			Convey("Then it is decompressed as it is received", func() {
+				So(recorder.Code, ShouldEqual, http.StatusAccepted)
+				upload := decodeUpload(recorder)
+				So(upload.Size, ShouldEqual, 12)
+				So(upload.SHA256, ShouldEqual, "9284ed4fd7fe1346904656f329db6cc49c0e7ae5b8279bff37f96bc6eb59baad")
+			})
+		})
+
+		Convey("When a file is put with an unsupported or invalid encoding", func() {
+			unsupported := serve(handler, "PUT", "/api/v1/files/put.csv", strings.NewReader("a,b,c\n"), map[string]string{"Content-Encoding": "br"})
+			invalid := serve(handler, "PUT", "/api/v1/files/put.csv", strings.NewReader("a,b,c\n"), map[string]string{"Content-Encoding": "gzip"})
+
+			Convey("Then documented errors are returned", func() {
+				So(unsupported.Code, ShouldEqual, http.StatusBadRequest)
+				So(api.check("PUT", "/files/{filename}", unsupported), ShouldBeEmpty)
+				So(unsupported.Body.String(), ShouldContainSubstring, handlers.UnsupportedEncoding)
+				So(invalid.Code, ShouldEqual, http.StatusBadRequest)
+				So(api.check("PUT", "/files/{filename}", invalid), ShouldBeEmpty)
+				So(fileStore.Invocations, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the events of an upload are streamed", func() {
+			fileStore.ReadFiles = true
+			location := uploadMultipart(handler, nil).Header().Get("Location")
+			recorder := serve(handler, "GET", location+"/events", nil, nil)
+			events := readEvents(recorder)
+
+			Convey("Then its status is sent as it changes until it has been stored, with the rows and bytes stored", func() {
+				So(recorder.Code, ShouldEqual, http.StatusOK)
+				So(api.check("GET", "/uploads/{id}/events", recorder), ShouldBeEmpty)
+				So(recorder.Header().Get("Content-Type"), ShouldEqual, "text/event-stream")
+				So(recorder.Body.String(), ShouldStartWith, "retry: ")
+				So(recorder.Body.String(), ShouldContainSubstring, "event: status\n")
+				So(len(events), ShouldBeGreaterThan, 0)
+
+				last := events[len(events)-1]
+				So(last.Status, ShouldEqual, handlers.StatusCompleted)
+				So(last.Stage, ShouldBeBlank)
+				So(last.Rows, ShouldEqual, 2)
+				So(last.BytesStored, ShouldEqual, 12)
+				So(waitForUpload(handler, location, nil), ShouldResemble, last)
+			})
+		})
+
+		Convey("When the events of a queued upload are streamed as the server shuts down", func() {
+			release := make(chan struct{})
+			So(srv.Uploads.Workers.Submit(func() { <-release }), ShouldBeNil)
+			location := uploadMultipart(handler, nil).Header().Get("Location")
+
+			streamed := make(chan *httptest.ResponseRecorder)
+			go func() {
+				streamed <- serve(handler, "GET", location+"/events", nil, nil)
+			}()
+			srv.Uploads.StopAccepting()
+			recorder := <-streamed
+			close(release)
+
+			Convey("Then the stream ends without waiting for the upload", func() {
+				So(recorder.Code, ShouldEqual, http.StatusOK)
+				events := readEvents(recorder)
+				So(events, ShouldNotBeEmpty)
+				So(events[len(events)-1].Finished(), ShouldBeFalse)
+			})
+		})
+
+		Convey("When an upload that doesn't exist is requested, followed or cancelled", func() {
+			get := serve(handler, "GET", "/api/v1/uploads/unknown", nil, nil)
+			events := serve(handler, "GET", "/api/v1/uploads/unknown/events", nil, nil)
+			cancel := serve(handler, "DELETE", "/api/v1/uploads/unknown", nil, nil)
+
+			Convey("Then documented not found errors are returned", func() {
+				So(get.Code, ShouldEqual, http.StatusNotFound)
+				So(api.check("GET", "/uploads/{id}", get), ShouldBeEmpty)
+				So(events.Code, ShouldEqual, http.StatusNotFound)
+				So(api.check("GET", "/uploads/{id}/events", events), ShouldBeEmpty)
+				So(cancel.Code, ShouldEqual, http.StatusNotFound)
+				So(api.check("DELETE", "/uploads/{id}", cancel), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When uploads with an unknown status are listed", func() {
+			recorder := serve(handler, "GET", "/api/v1/uploads?status=lost", nil, nil)
+
+			Convey("Then a documented error is returned", func() {
+				So(recorder.Code, ShouldEqual, http.StatusBadRequest)
+				So(api.check("GET", "/uploads", recorder), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When an upload is cancelled while it is queued", func() {
+			release := make(chan struct{})
+			So(srv.Uploads.Workers.Submit(func() { <-release }), ShouldBeNil)
+
+			location := uploadMultipart(handler, nil).Header().Get("Location")
+			cancel := serve(handler, "DELETE", location, nil, nil)
+			close(release)
+
+			Convey("Then it is cancelled without being stored", func() {
+				So(cancel.Code, ShouldEqual, http.StatusAccepted)
+				So(api.check("DELETE", "/uploads/{id}", cancel), ShouldBeEmpty)
+				So(decodeUpload(cancel).Status, ShouldEqual, handlers.StatusCancelled)
+
+				So(srv.Shutdown(time.Second), ShouldBeNil)
+				So(fileStore.Invocations, ShouldEqual, 0)
+				So(waitForUpload(handler, location, nil).Status, ShouldEqual, handlers.StatusCancelled)
+			})
+		})
+
+		Convey("Every documented operation is routed and responds as documented", func() {
+			for path, item := range api.Paths {
+				for method := range item.operations() {
+					recorder := serve(handler, method, "/api/v1"+pathParameter.ReplaceAllString(path, "unknown"), nil, nil)
+					So(api.check(method, path, recorder), ShouldBeEmpty)
+				}
+			}
+		})
+	})
+
+	Convey("Given a server limiting each client IP to one upload an hour", t, func() {
+		srv, _ := newServer()
+		srv.Uploads.Limiter = ratelimit.New(ratelimit.NewMemoryStore(), ratelimit.Limits{}, ratelimit.Limits{UploadsPerHour: 1})
+		uploadMultipart(srv.HTTPServer.Handler, nil)
+
+		Convey("Then a second upload gets a documented rate limit error", func() {
+			recorder := uploadMultipart(srv.HTTPServer.Handler, nil)
+			So(recorder.Code, ShouldEqual, http.StatusTooManyRequests)
+			So(api.check("POST", "/uploads", recorder), ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given a server authenticating API keys", t, func() {
+		plain, _ := newServer()
+		authentication := &auth.Middleware{
+			Authenticators: []auth.Authenticator{
+				auth.NewAPIKeys([]auth.Credential{
+					{Name: "alice", Secret: "alice-key"},
+					{Name: "bob", Secret: "bob-key"},
+				}),
+				auth.NewStaticUsers("uploads", []auth.Credential{{Name: "alice", Secret: "alice-password"}}),
+			},
+			Public: server.PublicPaths,
+		}
+		handler := server.New(":0", time.Minute, plain.Uploads, plain.Health, authentication).HTTPServer.Handler
+		alice := map[string]string{auth.APIKeyHeader: "alice-key"}
+		bob := map[string]string{auth.APIKeyHeader: "bob-key"}
+
+		recorder := uploadMultipart(handler, alice)
+		location := recorder.Header().Get("Location")
+
+		Convey("Then the uploader can follow their upload", func() {
+			So(recorder.Code, ShouldEqual, http.StatusAccepted)
+			So(decodeUpload(recorder).UploadedBy, ShouldEqual, "alice")
+			So(serve(handler, "GET", location, nil, alice).Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("Then other users can't see it", func() {
+			So(serve(handler, "GET", location, nil, bob).Code, ShouldEqual, http.StatusNotFound)
+			So(serve(handler, "DELETE", location, nil, bob).Code, ShouldEqual, http.StatusNotFound)
+			So(serve(handler, "GET", "/api/v1/uploads", nil, bob).Body.String(), ShouldContainSubstring, `"uploads":[]`)
+		})
+
+		Convey("Then a user with the same name signing in another way can't see it", func() {
+			staticAlice := map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:alice-password"))}
+			So(serve(handler, "GET", location, nil, staticAlice).Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Then requests without credentials get a documented error", func() {
+			unauthenticated := serve(handler, "GET", location, nil, nil)
+			So(unauthenticated.Code, ShouldEqual, http.StatusUnauthorized)
+			So(api.check("GET", "/uploads/{id}", unauthenticated), ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,202 @@
+package server_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// specPath is the OpenAPI document describing the API.
+const specPath = "../api/openapi.yaml"
+
+// spec is the part of an OpenAPI 3 document needed to check responses against it.
+type spec struct {
+	Servers []struct {
+		URL string `yaml:"url"`
+	} `yaml:"servers"`
+	Paths      map[string]pathItem `yaml:"paths"`
+	Components struct {
+		Responses map[string]specResponse `yaml:"responses"`
+		Schemas   map[string]*schema      `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+type pathItem struct {
+	Get    *operation `yaml:"get"`
+	Put    *operation `yaml:"put"`
+	Post   *operation `yaml:"post"`
+	Delete *operation `yaml:"delete"`
+}
+
+func (p pathItem) operations() map[string]*operation {
+	operations := make(map[string]*operation)
+	for method, op := range map[string]*operation{"GET": p.Get, "PUT": p.Put, "POST": p.Post, "DELETE": p.Delete} {
+		if op != nil {
+			operations[method] = op
+		}
+	}
+	return operations
+}
+
+type operation struct {
+	Responses map[string]specResponse `yaml:"responses"`
+}
+
+type specResponse struct {
+	Ref     string                 `yaml:"$ref"`
+	Headers map[string]interface{} `yaml:"headers"`
+	Content map[string]struct {
+		Schema *schema `yaml:"schema"`
+	} `yaml:"content"`
+}
+
+type schema struct {
+	Ref                  string             `yaml:"$ref"`
+	Type                 string             `yaml:"type"`
+	Format               string             `yaml:"format"`
+	Enum                 []string           `yaml:"enum"`
+	Required             []string           `yaml:"required"`
+	Properties           map[string]*schema `yaml:"properties"`
+	AdditionalProperties *bool              `yaml:"additionalProperties"`
+	Items                *schema            `yaml:"items"`
+}
+
+func loadSpec() (*spec, error) {
+	b, err := ioutil.ReadFile(specPath)
+	if err != nil {
+		return nil, err
+	}
+	var s spec
+	if err = yaml.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// check returns the ways a response to the request for the documented path differs from the spec.
+func (s *spec) check(method string, path string, recorder *httptest.ResponseRecorder) []string {
+	item, ok := s.Paths[strings.TrimPrefix(path, s.Servers[0].URL)]
+	if !ok {
+		return []string{path + " is not documented"}
+	}
+	op := item.operations()[method]
+	if op == nil {
+		return []string{method + " " + path + " is not documented"}
+	}
+	response, ok := op.Responses[strconv.Itoa(recorder.Code)]
+	if !ok {
+		return []string{fmt.Sprintf("%s %s doesn't document a %d response", method, path, recorder.Code)}
+	}
+	if len(response.Ref) > 0 {
+		response = s.Components.Responses[strings.TrimPrefix(response.Ref, "#/components/responses/")]
+	}
+
+	var problems []string
+	for header := range response.Headers {
+		if len(recorder.Header().Get(header)) == 0 {
+			problems = append(problems, "missing "+header+" header")
+		}
+	}
+
+	content, ok := response.Content["application/json"]
+	if !ok {
+		return problems
+	}
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "application/json") {
+		return append(problems, "Content-Type is "+recorder.Header().Get("Content-Type"))
+	}
+	var body interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		return append(problems, "invalid JSON: "+err.Error())
+	}
+	return append(problems, s.validate(body, content.Schema, "body")...)
+}
+
+// validate checks a decoded JSON value against a schema, supporting the parts of JSON schema the spec uses.
+func (s *spec) validate(value interface{}, sch *schema, at string) []string {
+	if len(sch.Ref) > 0 {
+		sch = s.Components.Schemas[strings.TrimPrefix(sch.Ref, "#/components/schemas/")]
+	}
+
+	switch sch.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{at + " is not an object"}
+		}
+		var problems []string
+		for _, name := range sch.Required {
+			if _, ok := object[name]; !ok {
+				problems = append(problems, at+"."+name+" is required")
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := sch.Properties[name]
+			if !ok {
+				if sch.AdditionalProperties != nil && !*sch.AdditionalProperties {
+					problems = append(problems, at+"."+name+" is not documented")
+				}
+				continue
+			}
+			problems = append(problems, s.validate(object[name], property, at+"."+name)...)
+		}
+		return problems
+
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return []string{at + " is not an array"}
+		}
+		var problems []string
+		for i, item := range array {
+			problems = append(problems, s.validate(item, sch.Items, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+		return problems
+
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return []string{at + " is not a string"}
+		}
+		if sch.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return []string{at + " is not a date-time"}
+			}
+		}
+		if len(sch.Enum) > 0 {
+			for _, allowed := range sch.Enum {
+				if str == allowed {
+					return nil
+				}
+			}
+			return []string{at + " is not one of " + strings.Join(sch.Enum, ", ")}
+		}
+		return nil
+
+	case "integer":
+		if number, ok := value.(float64); !ok || number != math.Trunc(number) {
+			return []string{at + " is not an integer"}
+		}
+		return nil
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{at + " is not a boolean"}
+		}
+		return nil
+	}
+	return []string{at + " has an unsupported schema type " + sch.Type}
+}
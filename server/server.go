@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/auth"
+	"github.com/ONSdigital/dp-dd-file-uploader/handler"
+	"github.com/ONSdigital/dp-dd-file-uploader/health"
+	"github.com/ONSdigital/dp-dd-file-uploader/metrics"
+	"github.com/ONSdigital/dp-dd-file-uploader/tracing"
+	"github.com/ONSdigital/go-ns/handlers/requestID"
+	"github.com/ONSdigital/go-ns/handlers/timeout"
+	"github.com/ONSdigital/go-ns/log"
+	"github.com/gorilla/pat"
+	"github.com/justinas/alice"
+)
+
+// Server serves the routes of an upload service over HTTP.
+type Server struct {
+	Uploads *handlers.UploadService
+	Health  *health.Checker
+	// Auth authenticates requests, if authentication is enabled.
+	Auth       *auth.Middleware
+	HTTPServer *http.Server
+}
+
+// PublicPaths are the paths that can be requested without authenticating: the health checks and
+// metrics used by the platform, and the pages browsers log in with.
+var PublicPaths = map[string]bool{
+	"/healthcheck":    true,
+	"/health/live":    true,
+	"/health/ready":   true,
+	"/metrics":        true,
+	auth.LoginPath:    true,
+	auth.CallbackPath: true,
+	auth.LogoutPath:   true,
+}
+
+// New creates a server for the upload service listening on bindAddr. As per
+// https://blog.cloudflare.com/the-complete-guide-to-golang-net-http-timeouts/ the upload timeout
+// is used for reading, writing and handling each request, as an upload encompasses all three.
+// If authentication is nil, requests are not authenticated.
+func New(bindAddr string, uploadTimeout time.Duration, uploads *handlers.UploadService, checker *health.Checker, authentication *auth.Middleware) *Server {
+	s := &Server{
+		Uploads: uploads,
+		Health:  checker,
+		Auth:    authentication,
+	}
+	s.HTTPServer = &http.Server{
+		Addr:         bindAddr,
+		Handler:      s.Handler(uploadTimeout),
+		ReadTimeout:  uploadTimeout,
+		WriteTimeout: uploadTimeout,
+	}
+	return s
+}
+
+// Router returns the server's routes, without any middleware.
+func (s *Server) Router() *pat.Router {
+	router := pat.New()
+
+	router.Get("/healthcheck", s.Uploads.Healthcheck)
+	router.Get("/workers", s.Uploads.WorkerStats)
+	router.Get("/health/live", health.LiveHandler)
+	router.Get("/health/ready", s.Health.ReadyHandler)
+	router.Add("GET", "/metrics", metrics.Handler())
+	router.Get("/audit", s.Uploads.AuditQuery)
+	if s.Auth != nil && s.Auth.OIDC != nil {
+		router.Get(auth.LoginPath, s.Auth.OIDC.Login)
+		router.Get(auth.CallbackPath, s.Auth.OIDC.Callback)
+		router.Get(auth.LogoutPath, s.Auth.OIDC.Logout)
+	}
+	// pat matches path prefixes, so an upload's routes must come before the list of uploads
+	uploads := handlers.APIPath + "/uploads"
+	router.Get(uploads+"/{id}/events", s.Uploads.UploadEvents)
+	router.Get(uploads+"/{id}", s.Uploads.GetUpload)
+	router.Delete(uploads+"/{id}", s.Uploads.CancelUpload)
+	router.Get(uploads, s.Uploads.ListUploads)
+	router.Add("POST", uploads, s.Uploads.RateLimit(http.HandlerFunc(s.Uploads.CreateUpload)))
+	router.Add("PUT", handlers.APIPath+"/files/{filename}", s.Uploads.RateLimit(http.HandlerFunc(s.Uploads.PutFile)))
+	router.Get("/", s.Uploads.Home)
+	router.Add("POST", "/", s.Uploads.RateLimit(http.HandlerFunc(s.Uploads.Upload)))
+
+	return router
+}
+
+// Handler returns the server's routes wrapped in the timeout, logging, request ID, tracing and,
+// if enabled, authentication middleware.
+func (s *Server) Handler(uploadTimeout time.Duration) http.Handler {
+	chain := alice.New(
+		log.Handler,
+		requestID.Handler(16),
+		tracing.Handler,
+	)
+	if s.Auth != nil {
+		chain = chain.Append(s.Auth.Handler)
+	}
+	handler := chain.Then(s.Router())
+	timed := timeout.Handler(uploadTimeout)(handler)
+
+	// The timeout handler holds back the response until the handler returns, so event streams are
+	// instead ended a little before the server's write timeout would cut them off
+	streamTimeout := uploadTimeout * 9 / 10
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !isEventStream(req.URL.Path) {
+			timed.ServeHTTP(w, req)
+			return
+		}
+		ctx, cancel := context.WithTimeout(req.Context(), streamTimeout)
+		defer cancel()
+		handler.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// isEventStream returns whether the path is that of an upload's event stream.
+func isEventStream(path string) bool {
+	return strings.HasPrefix(path, handlers.APIPath+"/uploads/") && strings.HasSuffix(path, "/events")
+}
+
+// ListenAndServe serves requests until the server is shut down.
+func (s *Server) ListenAndServe() error {
+	log.Debug("Starting server", log.Data{"bind_addr": s.HTTPServer.Addr})
+	return s.HTTPServer.ListenAndServe()
+}
+
+// Shutdown stops accepting uploads, then waits for in-flight requests and background uploads
+// to complete within the timeout.
+func (s *Server) Shutdown(shutdownTimeout time.Duration) error {
+	deadline := time.Now().Add(shutdownTimeout)
+	s.Uploads.StopAccepting()
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := s.HTTPServer.Shutdown(ctx); err != nil {
+		log.Error(err, log.Data{"message": "Failed to shut down HTTP server cleanly"})
+	}
+
+	err := s.Uploads.Workers.Shutdown(time.Until(deadline))
+	if auditErr := s.Uploads.Audit.Close(); auditErr != nil {
+		log.Error(auditErr, log.Data{"message": "Failed to close the audit log"})
+	}
+	return err
+}
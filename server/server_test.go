@@ -0,0 +1,105 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/aws"
+	"github.com/ONSdigital/dp-dd-file-uploader/event/eventtest"
+	"github.com/ONSdigital/dp-dd-file-uploader/file/filetest"
+	"github.com/ONSdigital/dp-dd-file-uploader/handler"
+	"github.com/ONSdigital/dp-dd-file-uploader/health"
+	"github.com/ONSdigital/dp-dd-file-uploader/metadata"
+	"github.com/ONSdigital/dp-dd-file-uploader/render"
+	"github.com/ONSdigital/dp-dd-file-uploader/server"
+	"github.com/ONSdigital/dp-dd-file-uploader/worker"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const multipartBody = "--boundary\r\n" +
+	"Content-Disposition: form-data; name=\"file\"; filename=\"test.csv\"\r\n" +
+	"Content-Type: text/csv\r\n\r\n" +
+	"a,b,c\r\n1,2,3\r\n" +
+	"--boundary--\r\n"
+
+func newServer() (*server.Server, *filetest.DummyFileStore) {
+	s3URL, _ := url.Parse("s3://bucket/dir")
+	fileStore := filetest.NewDummyFileStore()
+
+	uploads := &handlers.UploadService{
+		FileStore:      fileStore,
+		EventProducer:  eventtest.NewDummyEventProducer(),
+		S3Config:       aws.NewAWSConfig("region1", s3URL),
+		Workers:        worker.NewPool(1, 4),
+		Renderer:       render.New(),
+		TempDir:        os.TempDir(),
+		MetadataSchema: metadata.DefaultSchema(),
+	}
+	checker := health.NewChecker(time.Second, 0)
+	checker.Add("accepting", uploads.CheckAccepting)
+
+	return server.New(":0", time.Minute, uploads, checker, nil), fileStore
+}
+
+func upload(handler http.Handler) *httptest.ResponseRecorder {
+	request := httptest.NewRequest("POST", "/", strings.NewReader(multipartBody))
+	request.Header.Set("Content-Type", "multipart/form-data; boundary=boundary")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestServer(t *testing.T) {
+
+	Convey("Given two servers with their own dependencies", t, func() {
+		first, firstStore := newServer()
+		second, secondStore := newServer()
+
+		Convey("When a file is uploaded to each server", func() {
+			So(upload(first.HTTPServer.Handler).Code, ShouldEqual, http.StatusAccepted)
+			So(upload(second.HTTPServer.Handler).Code, ShouldEqual, http.StatusAccepted)
+			So(upload(second.HTTPServer.Handler).Code, ShouldEqual, http.StatusAccepted)
+			So(first.Shutdown(time.Second), ShouldBeNil)
+			So(second.Shutdown(time.Second), ShouldBeNil)
+
+			Convey("Then each file is stored by its own server's store", func() {
+				So(firstStore.Invocations, ShouldEqual, 1)
+				So(secondStore.Invocations, ShouldEqual, 2)
+			})
+		})
+
+		Convey("When one server is shut down", func() {
+			So(first.Shutdown(time.Second), ShouldBeNil)
+
+			Convey("Then it reports not ready while the other still accepts uploads", func() {
+				recorder := httptest.NewRecorder()
+				first.HTTPServer.Handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/health/ready", nil))
+				So(recorder.Code, ShouldEqual, http.StatusServiceUnavailable)
+
+				recorder = httptest.NewRecorder()
+				second.HTTPServer.Handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/health/ready", nil))
+				So(recorder.Code, ShouldEqual, http.StatusOK)
+				So(upload(second.HTTPServer.Handler).Code, ShouldEqual, http.StatusAccepted)
+			})
+		})
+	})
+
+	Convey("Given a server", t, func() {
+		srv, _ := newServer()
+
+		Convey("The upload form and liveness endpoint are routed", func() {
+			recorder := httptest.NewRecorder()
+			srv.HTTPServer.Handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+			So(recorder.Body.String(), ShouldContainSubstring, "<form")
+
+			recorder = httptest.NewRecorder()
+			srv.HTTPServer.Handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/health/live", nil))
+			So(recorder.Code, ShouldEqual, http.StatusOK)
+		})
+	})
+}
@@ -0,0 +1,41 @@
+// Package size reads sizes in bytes written for people, such as 2GB, as used by the configuration
+// and policy files.
+package size
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// units are the suffixes accepted for sizes, largest first so that e.g. MB is not read as B.
+var units = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// Parse parses a size in bytes, with an optional KB, MB or GB suffix.
+func Parse(value string) (int64, error) {
+	number, multiplier := strings.ToUpper(strings.TrimSpace(value)), int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(number, unit.suffix) {
+			number, multiplier = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix)), unit.multiplier
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if size > math.MaxInt64/multiplier || size < math.MinInt64/multiplier {
+		return 0, fmt.Errorf("%s is too large a size", value)
+	}
+	return size * multiplier, nil
+}
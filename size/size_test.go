@@ -0,0 +1,33 @@
+package size_test
+
+import (
+	"testing"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/size"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParse(t *testing.T) {
+
+	Convey("Sizes are read in bytes or with a unit", t, func() {
+		parsed, err := size.Parse("2GB")
+		So(err, ShouldBeNil)
+		So(parsed, ShouldEqual, 2<<30)
+
+		parsed, err = size.Parse("512")
+		So(err, ShouldBeNil)
+		So(parsed, ShouldEqual, 512)
+	})
+
+	Convey("Sizes too large to hold are an error rather than wrapping around", t, func() {
+		_, err := size.Parse("99999999999GB")
+		So(err, ShouldNotBeNil)
+
+		_, err = size.Parse("-99999999999GB")
+		So(err, ShouldNotBeNil)
+
+		parsed, err := size.Parse("8589934591GB")
+		So(err, ShouldBeNil)
+		So(parsed, ShouldEqual, int64(8589934591)<<30)
+	})
+}
@@ -0,0 +1,20 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// TraceparentHeader is the W3C trace context header used to propagate spans between services.
+const TraceparentHeader = "traceparent"
+
+// propagator reads and writes W3C trace context.
+var propagator = propagation.TraceContext{}
+
+// ContextWithTraceparent returns a copy of the context in which new spans are children of the
+// span in the given W3C traceparent value, e.g. one received in a header. The context is returned
+// unchanged if the value isn't valid.
+func ContextWithTraceparent(ctx context.Context, traceparent string) context.Context {
+	return propagator.Extract(ctx, propagation.MapCarrier{TraceparentHeader: traceparent})
+}
@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Handler starts a span for each request, continuing the caller's trace if the request has a
+// traceparent header. The span is the current span in the request context.
+func Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := ContextWithTraceparent(req.Context(), req.Header.Get(TraceparentHeader))
+		ctx, span := Start(ctx, "HTTP "+req.Method+" "+req.URL.Path, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+		span.SetAttribute("http.method", req.Method)
+		span.SetAttribute("http.target", req.URL.Path)
+		if requestID := req.Header.Get("X-Request-Id"); len(requestID) > 0 {
+			span.SetAttribute("request.id", requestID)
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(recorder, req.WithContext(ctx))
+		span.SetAttribute("http.status_code", recorder.status)
+		// Only server errors fail a server span, as client errors are the caller's
+		if recorder.status >= http.StatusInternalServerError {
+			span.span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// statusRecorder records the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes flushes through to the underlying writer, so streamed responses still work.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the spans started by the service.
+const instrumentationName = "github.com/ONSdigital/dp-dd-file-uploader"
+
+// Span records a timed operation within a trace. All methods are safe to call on a nil span,
+// so code can be traced without checking whether tracing is enabled.
+type Span struct {
+	span trace.Span
+}
+
+// Start starts a span as a child of the current span in the context, or of a remote parent
+// set with ContextWithTraceparent, or as the root of a new trace if there is neither.
+// The returned context has the new span as its current span. Spans are internal unless a
+// kind is given with trace.WithSpanKind.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, *Span) {
+	ctx, span := otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+	return ctx, &Span{span: span}
+}
+
+// FromContext returns the current span in the context. Its methods do nothing if there isn't one.
+func FromContext(ctx context.Context) *Span {
+	return &Span{span: trace.SpanFromContext(ctx)}
+}
+
+// SetAttribute records a string, integer, float or boolean attribute against the span. Other
+// values are recorded as strings.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	var kv attribute.KeyValue
+	switch v := value.(type) {
+	case string:
+		kv = attribute.String(key, v)
+	case int:
+		kv = attribute.Int(key, v)
+	case int64:
+		kv = attribute.Int64(key, v)
+	case float64:
+		kv = attribute.Float64(key, v)
+	case bool:
+		kv = attribute.Bool(key, v)
+	default:
+		kv = attribute.String(key, fmt.Sprint(v))
+	}
+	s.span.SetAttributes(kv)
+}
+
+// RecordError marks the span as failed, if err is not nil.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// Traceparent returns the W3C traceparent value for the span, to propagate it to other services.
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(trace.ContextWithSpan(context.Background(), s.span), carrier)
+	return carrier[TraceparentHeader]
+}
+
+// End records the end time of the span and exports it. Only the first call has any effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.span.End()
+}
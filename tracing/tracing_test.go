@@ -0,0 +1,185 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/tracing"
+	"github.com/ONSdigital/dp-dd-file-uploader/tracing/tracingtest"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const exampleTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// attributeValue returns the value of a span's attribute, or nil if it isn't set.
+func attributeValue(span sdktrace.ReadOnlySpan, key string) interface{} {
+	for _, kv := range span.Attributes() {
+		if kv.Key == attribute.Key(key) {
+			return kv.Value.AsInterface()
+		}
+	}
+	return nil
+}
+
+func TestStart(t *testing.T) {
+
+	Convey("Given spans are being recorded", t, func() {
+		spans := tracingtest.NewRecorder()
+		defer spans.Close()
+
+		Convey("A span started without a parent is the root of a new sampled trace", func() {
+			_, span := tracing.Start(context.Background(), "root")
+			span.End()
+
+			root := spans.Named("root")
+			So(root, ShouldNotBeNil)
+			So(root.SpanContext().IsValid(), ShouldBeTrue)
+			So(root.SpanContext().IsSampled(), ShouldBeTrue)
+			So(root.Parent().IsValid(), ShouldBeFalse)
+			So(root.SpanKind(), ShouldEqual, trace.SpanKindInternal)
+		})
+
+		Convey("A span started from a span's context is its child", func() {
+			ctx, parent := tracing.Start(context.Background(), "parent")
+			_, child := tracing.Start(ctx, "child", trace.WithSpanKind(trace.SpanKindClient))
+			child.SetAttribute("rows", 3)
+			child.SetAttribute("s3.key", "dir/file.csv")
+			child.RecordError(errors.New("failed"))
+			child.End()
+			parent.End()
+
+			recorded := spans.Named("child")
+			So(recorded.Parent().SpanID(), ShouldEqual, spans.Named("parent").SpanContext().SpanID())
+			So(recorded.SpanContext().TraceID(), ShouldEqual, spans.Named("parent").SpanContext().TraceID())
+			So(recorded.SpanKind(), ShouldEqual, trace.SpanKindClient)
+			So(attributeValue(recorded, "rows"), ShouldEqual, int64(3))
+			So(attributeValue(recorded, "s3.key"), ShouldEqual, "dir/file.csv")
+			So(recorded.Status().Code, ShouldEqual, codes.Error)
+			So(recorded.Status().Description, ShouldEqual, "failed")
+		})
+
+		Convey("A span started from a traceparent continues its trace", func() {
+			_, span := tracing.Start(tracing.ContextWithTraceparent(context.Background(), exampleTraceparent), "continued")
+			span.End()
+
+			recorded := spans.Named("continued")
+			So(recorded.SpanContext().TraceID().String(), ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+			So(recorded.Parent().SpanID().String(), ShouldEqual, "00f067aa0ba902b7")
+			So(span.Traceparent(), ShouldEqual, tracingtest.Traceparent(recorded))
+		})
+
+		Convey("An invalid traceparent is ignored", func() {
+			_, span := tracing.Start(tracing.ContextWithTraceparent(context.Background(), "invalid"), "new")
+			span.End()
+
+			So(spans.Named("new").Parent().IsValid(), ShouldBeFalse)
+		})
+
+		Convey("Spans in an unsampled trace are not recorded", func() {
+			unsampled := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"
+			_, span := tracing.Start(tracing.ContextWithTraceparent(context.Background(), unsampled), "unsampled")
+			span.End()
+
+			So(spans.Spans(), ShouldBeEmpty)
+		})
+	})
+
+	Convey("A nil span can be used safely", t, func() {
+		var span *tracing.Span
+		span.SetAttribute("key", "value")
+		span.RecordError(errors.New("failed"))
+		span.End()
+		So(span.Traceparent(), ShouldBeEmpty)
+	})
+}
+
+func TestHandler(t *testing.T) {
+
+	Convey("Given spans are being recorded", t, func() {
+		spans := tracingtest.NewRecorder()
+		defer spans.Close()
+
+		var traceparent string
+		status := http.StatusTeapot
+		handler := tracing.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			traceparent = tracing.FromContext(req.Context()).Traceparent()
+			w.WriteHeader(status)
+		}))
+
+		Convey("When a request is received with a traceparent header", func() {
+			req := httptest.NewRequest("GET", "/path", nil)
+			req.Header.Set("traceparent", exampleTraceparent)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			Convey("Then the request span is a server span continuing the trace, available to the handler", func() {
+				span := spans.Named("HTTP GET /path")
+				So(span, ShouldNotBeNil)
+				So(span.SpanKind(), ShouldEqual, trace.SpanKindServer)
+				So(span.Parent().SpanID().String(), ShouldEqual, "00f067aa0ba902b7")
+				So(traceparent, ShouldEqual, tracingtest.Traceparent(span))
+				So(attributeValue(span, "http.status_code"), ShouldEqual, int64(http.StatusTeapot))
+				So(span.Status().Code, ShouldEqual, codes.Unset)
+			})
+		})
+
+		Convey("When a request is received with an invalid traceparent header", func() {
+			req := httptest.NewRequest("GET", "/path", nil)
+			req.Header.Set("traceparent", "invalid")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			Convey("Then a new trace is started", func() {
+				So(spans.Named("HTTP GET /path").Parent().IsValid(), ShouldBeFalse)
+			})
+		})
+
+		Convey("When a request fails with a server error", func() {
+			status = http.StatusInternalServerError
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/path", nil))
+
+			Convey("Then the request span is marked as failed", func() {
+				So(spans.Named("HTTP GET /path").Status().Code, ShouldEqual, codes.Error)
+			})
+		})
+	})
+}
+
+func TestNewProvider(t *testing.T) {
+
+	Convey("NewProvider rejects unsupported exporters", t, func() {
+		_, err := tracing.NewProvider("zipkin", "", "")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Given spans are sent with the OTLP exporter to a collector", t, func() {
+		requests := make(chan *http.Request, 1)
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			select {
+			case requests <- req:
+			default:
+			}
+		}))
+		defer collector.Close()
+
+		provider, err := tracing.NewProvider(tracing.ExporterOTLP, collector.URL, "test-service")
+		So(err, ShouldBeNil)
+
+		Convey("When a span ends and the provider is shut down", func() {
+			_, span := tracing.Start(context.Background(), "sent")
+			span.End()
+			So(provider.Shutdown(context.Background()), ShouldBeNil)
+
+			Convey("Then the span is sent to the collector's traces endpoint", func() {
+				req := <-requests
+				So(req.URL.Path, ShouldEqual, "/v1/traces")
+				So(req.Header.Get("Content-Type"), ShouldEqual, "application/x-protobuf")
+			})
+		})
+	})
+}
@@ -0,0 +1,47 @@
+package tracingtest
+
+import (
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// NewRecorder makes the spans started with tracing.Start be recorded, until the recorder is closed.
+func NewRecorder() *Recorder {
+	r := &Recorder{recorder: tracetest.NewSpanRecorder(), previous: otel.GetTracerProvider()}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(r.recorder)))
+	return r
+}
+
+// Recorder records the spans that end while it is open, for tests.
+type Recorder struct {
+	recorder *tracetest.SpanRecorder
+	previous trace.TracerProvider
+}
+
+// Close stops recording spans, restoring the tracer provider used before the recorder was made.
+func (r *Recorder) Close() {
+	otel.SetTracerProvider(r.previous)
+}
+
+// Spans returns the spans recorded so far, in the order they ended.
+func (r *Recorder) Spans() []sdktrace.ReadOnlySpan {
+	return r.recorder.Ended()
+}
+
+// Named returns the recorded span with the given name, or nil if there isn't one.
+func (r *Recorder) Named(name string) sdktrace.ReadOnlySpan {
+	for _, span := range r.Spans() {
+		if span.Name() == name {
+			return span
+		}
+	}
+	return nil
+}
+
+// Traceparent returns the W3C traceparent value for a recorded span.
+func Traceparent(span sdktrace.ReadOnlySpan) string {
+	sc := span.SpanContext()
+	return "00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-" + sc.TraceFlags().String()
+}
@@ -0,0 +1,160 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned when a job is submitted while the queue is at capacity.
+	ErrQueueFull = errors.New("The job queue is full")
+	// ErrStopped is returned when a job is submitted after the pool has been shut down.
+	ErrStopped = errors.New("The worker pool has been shut down")
+	// ErrShutdownTimeout is returned when jobs are still running after the shutdown deadline.
+	ErrShutdownTimeout = errors.New("Timed out waiting for queued jobs to finish")
+)
+
+// Job is a unit of background work.
+type Job func()
+
+// Pool runs jobs on a fixed number of workers, queueing up to a fixed number of jobs
+// while all of the workers are busy.
+type Pool struct {
+	size    int
+	jobs    chan Job
+	busy    int32
+	mutex   sync.RWMutex
+	stopped bool
+	// stopping is closed as shutdown begins, releasing jobs waiting for room in the queue.
+	stopping chan struct{}
+	stop     sync.Once
+	workers  sync.WaitGroup
+}
+
+// Stats describes the current load on a pool.
+type Stats struct {
+	Workers       int     `json:"workers"`
+	BusyWorkers   int     `json:"busyWorkers"`
+	QueueDepth    int     `json:"queueDepth"`
+	QueueCapacity int     `json:"queueCapacity"`
+	Utilisation   float64 `json:"utilisation"`
+}
+
+// NewPool creates a pool and starts its workers.
+func NewPool(size int, queueSize int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	pool := &Pool{
+		size:     size,
+		jobs:     make(chan Job, queueSize),
+		stopping: make(chan struct{}),
+	}
+
+	pool.workers.Add(size)
+	for i := 0; i < size; i++ {
+		go pool.work()
+	}
+
+	return pool
+}
+
+func (pool *Pool) work() {
+	defer pool.workers.Done()
+	for job := range pool.jobs {
+		atomic.AddInt32(&pool.busy, 1)
+		job()
+		atomic.AddInt32(&pool.busy, -1)
+	}
+}
+
+// Submit queues a job to be run, without blocking. ErrQueueFull is returned if there is no room in the queue.
+func (pool *Pool) Submit(job Job) error {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+
+	if pool.stopped {
+		return ErrStopped
+	}
+
+	select {
+	case pool.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// SubmitWait queues a job to be run, waiting for room in the queue. ErrStopped is returned if the pool
+// is shut down first.
+func (pool *Pool) SubmitWait(job Job) error {
+	pool.mutex.RLock()
+	defer pool.mutex.RUnlock()
+
+	if pool.stopped {
+		return ErrStopped
+	}
+
+	select {
+	case pool.jobs <- job:
+		return nil
+	case <-pool.stopping:
+		return ErrStopped
+	}
+}
+
+// Full returns true if a submitted job would currently be rejected.
+func (pool *Pool) Full() bool {
+	return len(pool.jobs) >= cap(pool.jobs) && int(atomic.LoadInt32(&pool.busy)) >= pool.size
+}
+
+// Check returns ErrQueueFull if the pool is saturated and can't accept any more jobs.
+func (pool *Pool) Check(ctx context.Context) error {
+	if pool.Full() {
+		return ErrQueueFull
+	}
+	return nil
+}
+
+// Stats returns the current queue depth and worker utilisation.
+func (pool *Pool) Stats() Stats {
+	busy := int(atomic.LoadInt32(&pool.busy))
+	return Stats{
+		Workers:       pool.size,
+		BusyWorkers:   busy,
+		QueueDepth:    len(pool.jobs),
+		QueueCapacity: cap(pool.jobs),
+		Utilisation:   float64(busy) / float64(pool.size),
+	}
+}
+
+// Shutdown stops accepting jobs and waits for those already queued to complete, or for the timeout to expire.
+func (pool *Pool) Shutdown(timeout time.Duration) error {
+	pool.stop.Do(func() { close(pool.stopping) })
+	pool.mutex.Lock()
+	if !pool.stopped {
+		pool.stopped = true
+		close(pool.jobs)
+	}
+	pool.mutex.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		pool.workers.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-time.After(timeout):
+		return ErrShutdownTimeout
+	}
+}
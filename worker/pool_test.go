@@ -0,0 +1,74 @@
+package worker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-file-uploader/worker"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPool(t *testing.T) {
+
+	Convey("Given a pool with one worker and a queue of one", t, func() {
+		pool := worker.NewPool(1, 1)
+		release := make(chan struct{})
+		started := make(chan struct{})
+
+		// Free the worker after every branch, not just the one that waits for it
+		Reset(func() {
+			select {
+			case <-release:
+			default:
+				close(release)
+			}
+			pool.Shutdown(time.Second)
+		})
+
+		Convey("When the worker is busy and a job is queued", func() {
+			So(pool.Submit(func() {
+				close(started)
+				<-release
+			}), ShouldBeNil)
+			<-started
+			So(pool.Submit(func() {}), ShouldBeNil)
+
+			Convey("Then further jobs are rejected as the queue is full", func() {
+				So(pool.Full(), ShouldBeTrue)
+				So(pool.Submit(func() {}), ShouldEqual, worker.ErrQueueFull)
+			})
+
+			Convey("Then a job waiting for room is queued once the worker is free", func() {
+				queued := make(chan error, 1)
+				go func() { queued <- pool.SubmitWait(func() {}) }()
+				close(release)
+				So(<-queued, ShouldBeNil)
+			})
+
+			Convey("Then a job waiting for room is refused once the pool is shut down", func() {
+				queued := make(chan error, 1)
+				go func() { queued <- pool.SubmitWait(func() {}) }()
+				So(pool.Shutdown(10*time.Millisecond), ShouldEqual, worker.ErrShutdownTimeout)
+				So(<-queued, ShouldEqual, worker.ErrStopped)
+			})
+
+			Convey("And the stats report the queue depth and utilisation", func() {
+				stats := pool.Stats()
+				So(stats.BusyWorkers, ShouldEqual, 1)
+				So(stats.QueueDepth, ShouldEqual, 1)
+				So(stats.QueueCapacity, ShouldEqual, 1)
+				So(stats.Utilisation, ShouldEqual, 1.0)
+			})
+
+			Convey("And shutdown times out while the job is still running", func() {
+				So(pool.Shutdown(10*time.Millisecond), ShouldEqual, worker.ErrShutdownTimeout)
+				So(pool.Submit(func() {}), ShouldEqual, worker.ErrStopped)
+
+				Convey("But completes once the queued jobs have run", func() {
+					close(release)
+					So(pool.Shutdown(time.Second), ShouldBeNil)
+				})
+			})
+		})
+	})
+}